@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -18,10 +20,11 @@ type AppServiceClient struct {
 	Context                 context.Context
 	Conn                    *websocket.Conn
 	AppInfo                 *connection.App
-	LoggedIn                bool
+	LoggedIn                atomic.Bool                        // set after a successful AppLogin, the read loop changes it
 	MessageHandlerRegister  *AppServiceMessageHandlerRegister  // list of message handler on the session
 	CallbackHandlerRegister *AppServiceCallbackHandlerRegister // hold a list of callback handler that are registered for a message with src attribute
-
+	OnDisconnect            func(ac *AppServiceClient)         // called when the websocket to the appservice is closed
+	writeMutex              sync.Mutex                         // messages can be sent from other go routines than the read loop
 }
 
 func NewAppServiceClient() *AppServiceClient {
@@ -104,6 +107,10 @@ func (ac *AppServiceClient) onConnect() error {
 	ac.Printf("WebSocket to '%s' connected\n", ac.AppInfo.Name)
 	ac.Send([]byte(`{"mt":"AppChallenge"}`))
 	ac.ReadWriteLoop()
+	ac.LoggedIn.Store(false)
+	if ac.OnDisconnect != nil {
+		ac.OnDisconnect(ac)
+	}
 	return nil
 }
 
@@ -114,6 +121,8 @@ func (ac *AppServiceClient) onDisconnect(code int, text string) error {
 
 func (ac *AppServiceClient) Send(message []byte) error {
 	ac.Printf("sending message: %s", string(message))
+	ac.writeMutex.Lock()
+	defer ac.writeMutex.Unlock()
 	err := ac.Conn.WriteMessage(websocket.TextMessage, message)
 	if err != nil {
 		fmt.Println("Error sending message:", err)
@@ -178,11 +187,11 @@ func (ac *AppServiceClient) received(message []byte) error {
 
 		if !msgl.Ok {
 			ac.Printf("error in response of the login to the appservice with the infos from the pbx: %+v", msgl)
-			ac.LoggedIn = false
+			ac.LoggedIn.Store(false)
 		} else {
 			ac.Printf("login successful")
 
-			ac.LoggedIn = true
+			ac.LoggedIn.Store(true)
 		}
 
 	}
@@ -0,0 +1,207 @@
+package contactsapp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/ricoschulte/go-myapps/appservice"
+	"github.com/ricoschulte/go-myapps/handler"
+	"github.com/ricoschulte/go-myapps/service/events"
+)
+
+var ErrNotConnected = errors.New("contactsapp: not logged in to the contacts app service")
+
+type ContactsApp struct {
+	Handler  *handler.HandleAppService
+	Client   *appservice.AppServiceClient // the connection to the app service, set after a successful login and cleared on disconnect
+	Books    map[int]*Book                // holds a map of books by their Id
+	Contacts map[int]*Contact             // holds a map of contacts by their Id
+	Mutex    sync.Mutex                   // guards Client, Books and Contacts
+
+	ReceiverBuffer int // the number of events buffered for a receiver of AddReceiver, DefaultReceiverBuffer if 0
+
+	mu        sync.Mutex
+	events    *events.Bus[ContactsAppEvent]
+	receivers map[chan ContactsAppEvent]*events.Subscription[ContactsAppEvent]
+}
+
+func NewContactsApp() *ContactsApp {
+	contactsapp := &ContactsApp{ReceiverBuffer: DefaultReceiverBuffer}
+	contactsapp.Handler = &handler.HandleAppService{
+		Name:         "contacts",
+		OnDisconnect: contactsapp.onDisconnect,
+	}
+	contactsapp.Books = map[int]*Book{}
+	contactsapp.Contacts = map[int]*Contact{}
+
+	// register the handler on the appservice
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleAppLoginResult{ContactsApp: contactsapp})
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleGetBooksResult{ContactsApp: contactsapp})
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleResult{ContactsApp: contactsapp, Mt: "AddBookResult", EventType: ContactsAppEventAddBookResult})
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleResult{ContactsApp: contactsapp, Mt: "UpdateBookResult", EventType: ContactsAppEventUpdateBookResult})
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleResult{ContactsApp: contactsapp, Mt: "DelBookResult", EventType: ContactsAppEventDelBookResult})
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleBookUpdate{ContactsApp: contactsapp, Mt: "BookAdded", EventType: ContactsAppEventBookAdded})
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleBookUpdate{ContactsApp: contactsapp, Mt: "BookUpdated", EventType: ContactsAppEventBookUpdated})
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleBookUpdate{ContactsApp: contactsapp, Mt: "BookRemoved", EventType: ContactsAppEventBookRemoved})
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleFindContactsResult{ContactsApp: contactsapp})
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleGetContactsResult{ContactsApp: contactsapp})
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleResult{ContactsApp: contactsapp, Mt: "AddContactResult", EventType: ContactsAppEventAddContactResult})
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleResult{ContactsApp: contactsapp, Mt: "UpdateContactResult", EventType: ContactsAppEventUpdateContactResult})
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleResult{ContactsApp: contactsapp, Mt: "DelContactResult", EventType: ContactsAppEventDelContactResult})
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleContactUpdate{ContactsApp: contactsapp, Mt: "ContactAdded", EventType: ContactsAppEventContactAdded})
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleContactUpdate{ContactsApp: contactsapp, Mt: "ContactUpdated", EventType: ContactsAppEventContactUpdated})
+	contactsapp.Handler.MessageHandlerRegister.AddHandler(&HandleContactUpdate{ContactsApp: contactsapp, Mt: "ContactRemoved", EventType: ContactsAppEventContactRemoved})
+
+	return contactsapp
+}
+
+// sends a message to the contacts app service
+func (app *ContactsApp) send(msg interface{}) error {
+	app.Mutex.Lock()
+	client := app.Client
+	app.Mutex.Unlock()
+	if client == nil || !client.LoggedIn.Load() {
+		return ErrNotConnected
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return client.Send(b)
+}
+
+// requests the list of books. With recvUpdates, BookAdded, BookUpdated and BookRemoved events are received afterwards.
+// returns the src of the request to match the ContactsAppEventGetBooksResult event
+func (app *ContactsApp) GetBooks(recvUpdates bool) (string, error) {
+	msg := NewGetBooks(recvUpdates)
+	return msg.Src, app.send(msg)
+}
+
+// creates a new book. returns the src of the request to match the ContactsAppEventAddBookResult event
+func (app *ContactsApp) AddBook(name string, public bool) (string, error) {
+	msg := NewAddBook(name, public)
+	return msg.Src, app.send(msg)
+}
+
+// updates an existing book, identified by book.Id. returns the src of the request to match the ContactsAppEventUpdateBookResult event
+func (app *ContactsApp) UpdateBook(book Book) (string, error) {
+	msg := NewUpdateBook(book)
+	return msg.Src, app.send(msg)
+}
+
+// deletes a book and all of its contacts. returns the src of the request to match the ContactsAppEventDelBookResult event
+func (app *ContactsApp) DelBook(id int) (string, error) {
+	msg := NewDelBook(id)
+	return msg.Src, app.send(msg)
+}
+
+// searches for contacts. A bookId of 0 searches all books, a count of 0 returns all matches.
+// returns the src of the request to match the ContactsAppEventFindContactsResult events
+func (app *ContactsApp) FindContacts(search string, bookId, start, count int) (string, error) {
+	msg := NewFindContacts(search, bookId, start, count)
+	return msg.Src, app.send(msg)
+}
+
+// lists the contacts of a book. With recvUpdates, ContactAdded, ContactUpdated and ContactRemoved events of the book are received afterwards.
+// returns the src of the request to match the ContactsAppEventGetContactsResult events
+func (app *ContactsApp) GetContacts(bookId, start, count int, recvUpdates bool) (string, error) {
+	msg := NewGetContacts(bookId, start, count, recvUpdates)
+	return msg.Src, app.send(msg)
+}
+
+// creates a new contact in the book contact.BookId. returns the src of the request to match the ContactsAppEventAddContactResult event
+func (app *ContactsApp) AddContact(contact Contact) (string, error) {
+	msg := NewAddContact(contact)
+	return msg.Src, app.send(msg)
+}
+
+// updates an existing contact, identified by contact.Id. returns the src of the request to match the ContactsAppEventUpdateContactResult event
+func (app *ContactsApp) UpdateContact(contact Contact) (string, error) {
+	msg := NewUpdateContact(contact)
+	return msg.Src, app.send(msg)
+}
+
+// deletes a contact. returns the src of the request to match the ContactsAppEventDelContactResult event
+func (app *ContactsApp) DelContact(id int) (string, error) {
+	msg := NewDelContact(id)
+	return msg.Src, app.send(msg)
+}
+
+// returns a copy of the currently known books in a go routine save way
+func (app *ContactsApp) GetBookList() []Book {
+	app.Mutex.Lock()
+	defer app.Mutex.Unlock()
+	list := make([]Book, 0, len(app.Books))
+	for _, book := range app.Books {
+		list = append(list, *book)
+	}
+	return list
+}
+
+// returns a copy of the currently known contacts in a go routine save way
+func (app *ContactsApp) GetContactList() []Contact {
+	app.Mutex.Lock()
+	defer app.Mutex.Unlock()
+	list := make([]Contact, 0, len(app.Contacts))
+	for _, contact := range app.Contacts {
+		list = append(list, *contact)
+	}
+	return list
+}
+
+// clears the Client if it is the closed connection
+func (app *ContactsApp) onDisconnect(client *appservice.AppServiceClient) {
+	app.Mutex.Lock()
+	current := app.Client == client
+	if current {
+		app.Client = nil
+	}
+	app.Mutex.Unlock()
+	if current {
+		app.sendEvent(ContactsAppEvent{Type: ContactsAppEventDisconnect})
+	}
+}
+
+func (app *ContactsApp) getEvents() *events.Bus[ContactsAppEvent] {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if app.events == nil {
+		app.events = events.NewBus[ContactsAppEvent]()
+	}
+	return app.events
+}
+
+// returns a channel for all events. When it is full, the oldest event is dropped, so a slow receiver does not block the others.
+func (app *ContactsApp) AddReceiver() chan ContactsAppEvent {
+	bus := app.getEvents()
+	size := app.ReceiverBuffer
+	if size <= 0 {
+		size = DefaultReceiverBuffer
+	}
+	ch := make(chan ContactsAppEvent, size)
+	subscription := bus.SubscribeChan(context.Background(), ch, events.WithPolicy[ContactsAppEvent](events.DropOldest))
+	app.mu.Lock()
+	defer app.mu.Unlock()
+	if app.receivers == nil {
+		app.receivers = map[chan ContactsAppEvent]*events.Subscription[ContactsAppEvent]{}
+	}
+	app.receivers[ch] = subscription
+	return ch
+}
+
+// removes the receiver and closes its channel
+func (app *ContactsApp) RemoveReceiver(ch chan ContactsAppEvent) {
+	app.mu.Lock()
+	subscription, ok := app.receivers[ch]
+	delete(app.receivers, ch)
+	app.mu.Unlock()
+	if ok {
+		subscription.Unsubscribe()
+	}
+}
+
+func (app *ContactsApp) sendEvent(event ContactsAppEvent) {
+	app.getEvents().Publish(event)
+}
@@ -0,0 +1,199 @@
+package contactsapp
+
+import (
+	"encoding/json"
+
+	"github.com/ricoschulte/go-myapps/appservice"
+)
+
+type HandleAppLoginResult struct {
+	ContactsApp *ContactsApp
+}
+
+func (m *HandleAppLoginResult) GetMt() string {
+	return "AppLoginResult"
+}
+
+func (m *HandleAppLoginResult) HandleMessage(appserviceclient *appservice.AppServiceClient, message []byte) error {
+	appserviceclient.Printf("%s %v", m.GetMt(), string(message))
+	var msgl appservice.AppLoginResult
+	if err := json.Unmarshal(message, &msgl); err != nil {
+		appserviceclient.Println("error unmarshalling:", m.GetMt(), err)
+	}
+
+	if msgl.Ok {
+		m.ContactsApp.Mutex.Lock()
+		m.ContactsApp.Client = appserviceclient
+		m.ContactsApp.Mutex.Unlock()
+
+		getbooks, _ := json.Marshal(NewGetBooks(true))
+		appserviceclient.Send(getbooks)
+
+		m.ContactsApp.sendEvent(ContactsAppEvent{Type: ContactsAppEventConnect})
+	}
+
+	return nil
+}
+
+type HandleGetBooksResult struct {
+	ContactsApp *ContactsApp
+}
+
+func (m *HandleGetBooksResult) GetMt() string {
+	return "GetBooksResult"
+}
+
+func (m *HandleGetBooksResult) HandleMessage(appserviceclient *appservice.AppServiceClient, message []byte) error {
+	appserviceclient.Printf("%s %v", m.GetMt(), string(message))
+	var msgl GetBooksResult
+	if err := json.Unmarshal(message, &msgl); err != nil {
+		appserviceclient.Println("error unmarshalling:", m.GetMt(), err)
+	}
+
+	m.ContactsApp.Mutex.Lock()
+	for i := range msgl.Books {
+		book := msgl.Books[i]
+		m.ContactsApp.Books[book.Id] = &book
+	}
+	m.ContactsApp.Mutex.Unlock()
+
+	m.ContactsApp.sendEvent(ContactsAppEvent{Type: ContactsAppEventGetBooksResult, Src: msgl.Src, Last: msgl.Last, Books: msgl.Books})
+	return nil
+}
+
+type HandleFindContactsResult struct {
+	ContactsApp *ContactsApp
+}
+
+func (m *HandleFindContactsResult) GetMt() string {
+	return "FindContactsResult"
+}
+
+func (m *HandleFindContactsResult) HandleMessage(appserviceclient *appservice.AppServiceClient, message []byte) error {
+	appserviceclient.Printf("%s %v", m.GetMt(), string(message))
+	var msgl FindContactsResult
+	if err := json.Unmarshal(message, &msgl); err != nil {
+		appserviceclient.Println("error unmarshalling:", m.GetMt(), err)
+	}
+
+	// search results are not added to the local contacts, they would be incomplete anyway
+	m.ContactsApp.sendEvent(ContactsAppEvent{Type: ContactsAppEventFindContactsResult, Src: msgl.Src, Last: msgl.Last, Contacts: msgl.Contacts})
+	return nil
+}
+
+type HandleGetContactsResult struct {
+	ContactsApp *ContactsApp
+}
+
+func (m *HandleGetContactsResult) GetMt() string {
+	return "GetContactsResult"
+}
+
+func (m *HandleGetContactsResult) HandleMessage(appserviceclient *appservice.AppServiceClient, message []byte) error {
+	appserviceclient.Printf("%s %v", m.GetMt(), string(message))
+	var msgl GetContactsResult
+	if err := json.Unmarshal(message, &msgl); err != nil {
+		appserviceclient.Println("error unmarshalling:", m.GetMt(), err)
+	}
+
+	m.ContactsApp.Mutex.Lock()
+	for i := range msgl.Contacts {
+		contact := msgl.Contacts[i]
+		m.ContactsApp.Contacts[contact.Id] = &contact
+	}
+	m.ContactsApp.Mutex.Unlock()
+
+	m.ContactsApp.sendEvent(ContactsAppEvent{Type: ContactsAppEventGetContactsResult, Src: msgl.Src, Last: msgl.Last, Contacts: msgl.Contacts})
+	return nil
+}
+
+// handles the results of the add, update and delete requests of books and contacts
+type HandleResult struct {
+	ContactsApp *ContactsApp
+	Mt          string
+	EventType   int
+}
+
+func (m *HandleResult) GetMt() string {
+	return m.Mt
+}
+
+func (m *HandleResult) HandleMessage(appserviceclient *appservice.AppServiceClient, message []byte) error {
+	appserviceclient.Printf("%s %v", m.GetMt(), string(message))
+	var msgl Result
+	if err := json.Unmarshal(message, &msgl); err != nil {
+		appserviceclient.Println("error unmarshalling:", m.GetMt(), err)
+	}
+	if msgl.Error != "" {
+		appserviceclient.Printf("%s: error '%s'", m.GetMt(), msgl.Error)
+	}
+	m.ContactsApp.sendEvent(ContactsAppEvent{Type: m.EventType, Src: msgl.Src, Id: msgl.Id, Error: msgl.Error})
+	return nil
+}
+
+// handles BookAdded, BookUpdated and BookRemoved
+type HandleBookUpdate struct {
+	ContactsApp *ContactsApp
+	Mt          string
+	EventType   int
+}
+
+func (m *HandleBookUpdate) GetMt() string {
+	return m.Mt
+}
+
+func (m *HandleBookUpdate) HandleMessage(appserviceclient *appservice.AppServiceClient, message []byte) error {
+	appserviceclient.Printf("%s %v", m.GetMt(), string(message))
+	var msgl BookUpdate
+	if err := json.Unmarshal(message, &msgl); err != nil {
+		appserviceclient.Println("error unmarshalling:", m.GetMt(), err)
+	}
+
+	m.ContactsApp.Mutex.Lock()
+	if m.EventType == ContactsAppEventBookRemoved {
+		delete(m.ContactsApp.Books, msgl.Book.Id)
+		for id, contact := range m.ContactsApp.Contacts {
+			if contact.BookId == msgl.Book.Id {
+				delete(m.ContactsApp.Contacts, id)
+			}
+		}
+	} else {
+		book := msgl.Book
+		m.ContactsApp.Books[book.Id] = &book
+	}
+	m.ContactsApp.Mutex.Unlock()
+
+	m.ContactsApp.sendEvent(ContactsAppEvent{Type: m.EventType, Id: msgl.Book.Id, Book: &msgl.Book})
+	return nil
+}
+
+// handles ContactAdded, ContactUpdated and ContactRemoved
+type HandleContactUpdate struct {
+	ContactsApp *ContactsApp
+	Mt          string
+	EventType   int
+}
+
+func (m *HandleContactUpdate) GetMt() string {
+	return m.Mt
+}
+
+func (m *HandleContactUpdate) HandleMessage(appserviceclient *appservice.AppServiceClient, message []byte) error {
+	appserviceclient.Printf("%s %v", m.GetMt(), string(message))
+	var msgl ContactUpdate
+	if err := json.Unmarshal(message, &msgl); err != nil {
+		appserviceclient.Println("error unmarshalling:", m.GetMt(), err)
+	}
+
+	m.ContactsApp.Mutex.Lock()
+	if m.EventType == ContactsAppEventContactRemoved {
+		delete(m.ContactsApp.Contacts, msgl.Contact.Id)
+	} else {
+		contact := msgl.Contact
+		m.ContactsApp.Contacts[contact.Id] = &contact
+	}
+	m.ContactsApp.Mutex.Unlock()
+
+	m.ContactsApp.sendEvent(ContactsAppEvent{Type: m.EventType, Id: msgl.Contact.Id, Contact: &msgl.Contact})
+	return nil
+}
@@ -0,0 +1,52 @@
+package contactsapp_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ricoschulte/go-myapps/appservice"
+	"github.com/ricoschulte/go-myapps/connection"
+	"github.com/ricoschulte/go-myapps/contactsapp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContactsApp_Disconnect(t *testing.T) {
+	app := contactsapp.NewContactsApp()
+	slow := app.AddReceiver() // never read
+	ch := app.AddReceiver()
+	defer app.RemoveReceiver(ch)
+
+	client := &appservice.AppServiceClient{
+		MyAppsConnection: &connection.MyAppsConnection{Config: &connection.Config{}},
+		AppInfo:          &connection.App{Name: "contacts"},
+	}
+	app.Client = client
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < app.ReceiverBuffer+10; i++ {
+			message := `{"mt":"BookAdded","book":{"id":` + strconv.Itoa(i) + `}}`
+			app.Handler.MessageHandlerRegister.HandleMessage(client, "BookAdded", []byte(message))
+		}
+		app.Handler.OnDisconnect(client)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("a receiver that is not read blocks the events")
+	}
+	assert.Nil(t, app.Client)
+	_, err := app.GetBooks(false)
+	assert.ErrorIs(t, err, contactsapp.ErrNotConnected)
+
+	// the oldest events are dropped
+	event := <-slow
+	assert.Equal(t, 11, event.Id)
+	app.RemoveReceiver(slow)
+	last := contactsapp.ContactsAppEvent{}
+	for len(ch) > 0 {
+		last = <-ch
+	}
+	assert.Equal(t, contactsapp.ContactsAppEventDisconnect, last.Type)
+}
@@ -0,0 +1,254 @@
+package contactsapp
+
+import "github.com/ricoschulte/go-myapps/connection"
+
+// returns a new random src to correlate a request with its result
+func newSrc() string {
+	return connection.GetRandomHexString(10)
+}
+
+type Book struct {
+	Id     int    `json:"id"`
+	Name   string `json:"name"`
+	Public bool   `json:"public"`
+	Count  int    `json:"count,omitempty"`
+}
+
+type Contact struct {
+	Id              int    `json:"id,omitempty"`
+	BookId          int    `json:"book"`
+	Cn              string `json:"cn,omitempty"`
+	Sn              string `json:"sn,omitempty"`
+	Givenname       string `json:"givenname,omitempty"`
+	Company         string `json:"company,omitempty"`
+	Department      string `json:"department,omitempty"`
+	Title           string `json:"title,omitempty"`
+	Email           string `json:"email,omitempty"`
+	Telephonenumber string `json:"telephonenumber,omitempty"`
+	Mobile          string `json:"mobile,omitempty"`
+	Homephone       string `json:"homephone,omitempty"`
+	Fax             string `json:"fax,omitempty"`
+	Street          string `json:"street,omitempty"`
+	Zip             string `json:"zip,omitempty"`
+	City            string `json:"city,omitempty"`
+	Country         string `json:"country,omitempty"`
+	Url             string `json:"url,omitempty"`
+	Note            string `json:"note,omitempty"`
+}
+
+// --------------------------------------
+type GetBooks struct {
+	Mt          string `json:"mt"`
+	Src         string `json:"src,omitempty"`
+	RecvUpdates bool   `json:"recvUpdates"`
+}
+
+func NewGetBooks(recvUpdates bool) GetBooks {
+	return GetBooks{
+		Mt:          "GetBooks",
+		Src:         newSrc(),
+		RecvUpdates: recvUpdates,
+	}
+}
+
+type GetBooksResult struct {
+	Mt    string `json:"mt"`
+	Src   string `json:"src,omitempty"`
+	Last  bool   `json:"last"`
+	Books []Book `json:"books"`
+}
+
+type AddBook struct {
+	Mt     string `json:"mt"`
+	Src    string `json:"src,omitempty"`
+	Name   string `json:"name"`
+	Public bool   `json:"public"`
+}
+
+func NewAddBook(name string, public bool) AddBook {
+	return AddBook{
+		Mt:     "AddBook",
+		Src:    newSrc(),
+		Name:   name,
+		Public: public,
+	}
+}
+
+type UpdateBook struct {
+	Mt   string `json:"mt"`
+	Src  string `json:"src,omitempty"`
+	Book Book   `json:"book"`
+}
+
+func NewUpdateBook(book Book) UpdateBook {
+	return UpdateBook{
+		Mt:   "UpdateBook",
+		Src:  newSrc(),
+		Book: book,
+	}
+}
+
+type DelBook struct {
+	Mt  string `json:"mt"`
+	Src string `json:"src,omitempty"`
+	Id  int    `json:"id"`
+}
+
+func NewDelBook(id int) DelBook {
+	return DelBook{
+		Mt:  "DelBook",
+		Src: newSrc(),
+		Id:  id,
+	}
+}
+
+// --------------------------------------
+type FindContacts struct {
+	Mt     string `json:"mt"`
+	Src    string `json:"src,omitempty"`
+	Search string `json:"search"`
+	BookId int    `json:"book,omitempty"` // 0 searches in all books
+	Start  int    `json:"start"`
+	Count  int    `json:"count,omitempty"`
+}
+
+func NewFindContacts(search string, bookId, start, count int) FindContacts {
+	return FindContacts{
+		Mt:     "FindContacts",
+		Src:    newSrc(),
+		Search: search,
+		BookId: bookId,
+		Start:  start,
+		Count:  count,
+	}
+}
+
+type FindContactsResult struct {
+	Mt       string    `json:"mt"`
+	Src      string    `json:"src,omitempty"`
+	Last     bool      `json:"last"`
+	Contacts []Contact `json:"contacts"`
+}
+
+type GetContacts struct {
+	Mt          string `json:"mt"`
+	Src         string `json:"src,omitempty"`
+	BookId      int    `json:"book"`
+	Start       int    `json:"start"`
+	Count       int    `json:"count,omitempty"`
+	RecvUpdates bool   `json:"recvUpdates"`
+}
+
+func NewGetContacts(bookId, start, count int, recvUpdates bool) GetContacts {
+	return GetContacts{
+		Mt:          "GetContacts",
+		Src:         newSrc(),
+		BookId:      bookId,
+		Start:       start,
+		Count:       count,
+		RecvUpdates: recvUpdates,
+	}
+}
+
+type GetContactsResult struct {
+	Mt       string    `json:"mt"`
+	Src      string    `json:"src,omitempty"`
+	Last     bool      `json:"last"`
+	Contacts []Contact `json:"contacts"`
+}
+
+type AddContact struct {
+	Mt      string  `json:"mt"`
+	Src     string  `json:"src,omitempty"`
+	Contact Contact `json:"contact"`
+}
+
+func NewAddContact(contact Contact) AddContact {
+	return AddContact{
+		Mt:      "AddContact",
+		Src:     newSrc(),
+		Contact: contact,
+	}
+}
+
+type UpdateContact struct {
+	Mt      string  `json:"mt"`
+	Src     string  `json:"src,omitempty"`
+	Contact Contact `json:"contact"`
+}
+
+func NewUpdateContact(contact Contact) UpdateContact {
+	return UpdateContact{
+		Mt:      "UpdateContact",
+		Src:     newSrc(),
+		Contact: contact,
+	}
+}
+
+type DelContact struct {
+	Mt  string `json:"mt"`
+	Src string `json:"src,omitempty"`
+	Id  int    `json:"id"`
+}
+
+func NewDelContact(id int) DelContact {
+	return DelContact{
+		Mt:  "DelContact",
+		Src: newSrc(),
+		Id:  id,
+	}
+}
+
+// the result of AddBook, UpdateBook, DelBook, AddContact, UpdateContact and DelContact
+type Result struct {
+	Mt    string `json:"mt"`
+	Src   string `json:"src,omitempty"`
+	Id    int    `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// --------------------------------------
+// sent by the app service to subscribers of GetBooks with recvUpdates
+type BookUpdate struct {
+	Mt   string `json:"mt"` // BookAdded, BookUpdated or BookRemoved
+	Book Book   `json:"book"`
+}
+
+// sent by the app service to subscribers of GetContacts with recvUpdates
+type ContactUpdate struct {
+	Mt      string  `json:"mt"` // ContactAdded, ContactUpdated or ContactRemoved
+	Contact Contact `json:"contact"`
+}
+
+type ContactsAppEvent struct {
+	Type     int
+	Src      string // the src of the request, if the event is the result of one
+	Id       int    // the id of an added, updated or deleted book or contact
+	Error    string
+	Last     bool
+	Book     *Book
+	Books    []Book
+	Contact  *Contact
+	Contacts []Contact
+}
+
+const ContactsAppEventDisconnect = -20
+const ContactsAppEventConnect = -10
+const ContactsAppEventGetBooksResult = 10
+const ContactsAppEventAddBookResult = 11
+const ContactsAppEventUpdateBookResult = 12
+const ContactsAppEventDelBookResult = 13
+const ContactsAppEventBookAdded = 15
+const ContactsAppEventBookUpdated = 16
+const ContactsAppEventBookRemoved = 17
+const ContactsAppEventFindContactsResult = 20
+const ContactsAppEventGetContactsResult = 21
+const ContactsAppEventAddContactResult = 22
+const ContactsAppEventUpdateContactResult = 23
+const ContactsAppEventDelContactResult = 24
+const ContactsAppEventContactAdded = 25
+const ContactsAppEventContactUpdated = 26
+const ContactsAppEventContactRemoved = 27
+
+// the ReceiverBuffer of NewContactsApp
+const DefaultReceiverBuffer = 100
@@ -5,6 +5,8 @@ go 1.19
 require (
 	github.com/go-chi/chi v1.5.4
 	github.com/gorilla/websocket v1.5.0
+	github.com/lib/pq v1.9.0
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/stretchr/testify v1.8.1
 	gotest.tools v2.2.0+incompatible
 )
@@ -13,6 +13,7 @@ import (
 type HandleAppService struct {
 	Name                   string                                      // the name of the app
 	MessageHandlerRegister appservice.AppServiceMessageHandlerRegister // list of message handler on the session
+	OnDisconnect           func(ac *appservice.AppServiceClient)       // called when a connection to the appservice is closed
 }
 
 func (m *HandleAppService) GetMt() string {
@@ -41,6 +42,7 @@ func (m *HandleAppService) StartConnectionToAppService(myAppsConnection *connect
 		MyAppsConnection:       myAppsConnection,
 		AppInfo:                &message.App,
 		MessageHandlerRegister: &m.MessageHandlerRegister,
+		OnDisconnect:           m.OnDisconnect,
 	}
 
 	err := appconnect.Connect()
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// The identity of the user of a logged in client, from its AppLogin
+type AppSession struct {
+	App        string   // the name of the app without .htm
+	Domain     string   // the domain of the user
+	Sip        string   // the sip/h323 name of the user
+	Guid       string   // the guid of the user object
+	Dn         string   // the display name of the user
+	Cn         string   // the common name of the user
+	Groups     []string // the groups of the user
+	Info       *AppLoginInfo
+	Connection *AppServicePbxConnection // the connection of the client, to send messages outside of a request
+}
+
+// returns true, if the user is member of the group
+func (session *AppSession) InGroup(group string) bool {
+	return containsString(session.Groups, group)
+}
+
+// returns the session of the logged in user of the connection
+func (connection *AppServicePbxConnection) Session() *AppSession {
+	login, info := connection.GetAppLogin()
+	session := &AppSession{
+		App:        AppName(login.App),
+		Domain:     login.Domain,
+		Sip:        login.Sip,
+		Guid:       login.Guid,
+		Dn:         login.Dn,
+		Info:       info,
+		Connection: connection,
+	}
+	if info != nil {
+		session.Cn = info.Cn
+		session.Groups = info.Groups
+	}
+	return session
+}
+
+// sends the replies to a message of a client
+type AppResponseWriter interface {
+	// sends the result of the request. If v has no mt, it is set to the mt of the request with "Result" appended.
+	// The src of the request is set, if v has none.
+	WriteResult(v interface{}) error
+	// sends a message to the client without relation to the request, e.g. an update of a subscription
+	WriteMessage(v interface{}) error
+	// sends a message with mt "Error" and the src of the request to the client
+	WriteError(text string) error
+}
+
+// handles the messages with a mt of the clients of an app
+type AppMessageHandler interface {
+	GetMt() string // the mt of the messages handled
+	// a returned error is sent to the client with WriteError
+	HandleAppMessage(session *AppSession, msg *BaseMessage, message []byte, w AppResponseWriter) error
+}
+
+type appMessageHandlerFunc[T any] struct {
+	mt string
+	f  func(session *AppSession, request *T, w AppResponseWriter) error
+}
+
+func (h *appMessageHandlerFunc[T]) GetMt() string {
+	return h.mt
+}
+
+func (h *appMessageHandlerFunc[T]) HandleAppMessage(session *AppSession, msg *BaseMessage, message []byte, w AppResponseWriter) error {
+	request := new(T)
+	if err := json.Unmarshal(message, request); err != nil {
+		return fmt.Errorf("invalid message '%s': %v", msg.Mt, err)
+	}
+	return h.f(session, request, w)
+}
+
+// returns an AppMessageHandler for the mt that unmarshals the messages into a T and calls f with it
+func NewAppMessageHandlerFunc[T any](mt string, f func(session *AppSession, request *T, w AppResponseWriter) error) AppMessageHandler {
+	return &appMessageHandlerFunc[T]{mt: mt, f: f}
+}
+
+// adds a handler for a mt of the clients of an app, name is the name of the app with or without .htm.
+// A handler for the same mt of the app is replaced.
+func (s *AppService) RegisterAppMessageHandler(name string, handler AppMessageHandler) error {
+	if AppName(name) == "" {
+		return fmt.Errorf("the app has no name")
+	}
+	if handler.GetMt() == "" {
+		return fmt.Errorf("the handler has no mt")
+	}
+	s.AppsMutex.Lock()
+	defer s.AppsMutex.Unlock()
+	if s.AppMessageHandler == nil {
+		s.AppMessageHandler = map[string]map[string]AppMessageHandler{}
+	}
+	if s.AppMessageHandler[AppName(name)] == nil {
+		s.AppMessageHandler[AppName(name)] = map[string]AppMessageHandler{}
+	}
+	s.AppMessageHandler[AppName(name)][handler.GetMt()] = handler
+	return nil
+}
+
+// returns the handler for a mt of an app
+func (s *AppService) GetAppMessageHandler(name string, mt string) (AppMessageHandler, bool) {
+	s.AppsMutex.RLock()
+	defer s.AppsMutex.RUnlock()
+	handler, ok := s.AppMessageHandler[AppName(name)][mt]
+	return handler, ok
+}
+
+type appResponseWriter struct {
+	connection *AppServicePbxConnection
+	request    BaseMessage
+}
+
+func (w *appResponseWriter) WriteResult(v interface{}) error {
+	return w.write(v, w.request.Mt+"Result", w.request.Src)
+}
+
+func (w *appResponseWriter) WriteMessage(v interface{}) error {
+	return w.write(v, "", "")
+}
+
+func (w *appResponseWriter) WriteError(text string) error {
+	return w.write(map[string]interface{}{"mt": "Error", "text": text}, "", w.request.Src)
+}
+
+// sends v with the mt and src set, if v has none
+func (w *appResponseWriter) write(v interface{}, mt string, src string) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	// numbers are kept as they are, a float64 would change integers above 2^53
+	msg := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&msg); err != nil {
+		return fmt.Errorf("the message is not a json object: %v", err)
+	}
+	if value, _ := msg["mt"].(string); value == "" {
+		if mt == "" {
+			return fmt.Errorf("the message has no mt")
+		}
+		msg["mt"] = mt
+	}
+	if value, _ := msg["src"].(string); value == "" {
+		if src != "" {
+			msg["src"] = src
+		} else {
+			delete(msg, "src")
+		}
+	}
+	// messages to clients have no api, but structs with BaseMessage would send it empty
+	if value, ok := msg["api"].(string); ok && value == "" {
+		delete(msg, "api")
+	}
+	b, err = json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return w.connection.WriteMessage(b)
+}
@@ -0,0 +1,88 @@
+package service_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/stretchr/testify/assert"
+)
+
+type getGreeting struct {
+	service.BaseMessage
+	Name string `json:"name"`
+}
+
+type getGreetingResult struct {
+	Text string `json:"text"`
+}
+
+func TestAppService_AppMessageHandler(t *testing.T) {
+	s := &service.AppService{}
+	assert.NoError(t, s.RegisterAppMessageHandler("user.htm", service.NewAppMessageHandlerFunc("GetGreeting", func(session *service.AppSession, request *getGreeting, w service.AppResponseWriter) error {
+		assert.Equal(t, "user", session.App)
+		assert.Equal(t, "rico", session.Sip)
+		assert.True(t, session.InGroup("admins"))
+		return w.WriteResult(getGreetingResult{Text: "hello " + request.Name})
+	})))
+	assert.NoError(t, s.RegisterAppMessageHandler("user", service.NewAppMessageHandlerFunc("Fail", func(session *service.AppSession, request *service.BaseMessage, w service.AppResponseWriter) error {
+		return errors.New("not allowed")
+	})))
+	assert.Error(t, s.RegisterAppMessageHandler("", service.NewAppMessageHandlerFunc("X", func(session *service.AppSession, request *service.BaseMessage, w service.AppResponseWriter) error {
+		return nil
+	})))
+	assert.True(t, s.HandlesApp("user.htm"))
+
+	connection, client := newTestConnection(t, s)
+	connection.AppLogin.App = "user.htm"
+	connection.AppLogin.Sip = "rico"
+	connection.Info = &service.AppLoginInfo{Groups: []string{"admins"}}
+	connection.Authenticated = true
+
+	s.HandleAppMessage(connection, []byte(`{"mt":"GetGreeting","src":"1","name":"rico"}`))
+	result := readMessage(t, client)
+	assert.Equal(t, "GetGreetingResult", result["mt"])
+	assert.Equal(t, "1", result["src"])
+	assert.Equal(t, "hello rico", result["text"])
+
+	s.HandleAppMessage(connection, []byte(`{"mt":"Fail","src":"2"}`))
+	result = readMessage(t, client)
+	assert.Equal(t, "Error", result["mt"])
+	assert.Equal(t, "2", result["src"])
+	assert.Equal(t, "not allowed", result["text"])
+
+	s.HandleAppMessage(connection, []byte(`{"mt":"Unknown","src":"3"}`))
+	result = readMessage(t, client)
+	assert.Equal(t, "Error", result["mt"])
+	assert.Equal(t, "3", result["src"])
+}
+
+type getCounter struct {
+	Id int64 `json:"id"`
+}
+
+func TestAppService_AppMessageOrder(t *testing.T) {
+	s := &service.AppService{}
+	next := int64(1 << 60)
+	assert.NoError(t, s.RegisterAppMessageHandler("user", service.NewAppMessageHandlerFunc("GetCounter", func(session *service.AppSession, request *service.BaseMessage, w service.AppResponseWriter) error {
+		next++
+		return w.WriteResult(getCounter{Id: next})
+	})))
+	connection, client := newTestConnection(t, s)
+	connection.AppLogin.App = "user"
+	connection.Authenticated = true
+	go connection.Loop()
+
+	// the messages of a client are handled in the order they were sent
+	for src := 1; src <= 20; src++ {
+		assert.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(`{"mt":"GetCounter","src":"`+strconv.Itoa(src)+`"}`)))
+	}
+	for src := 1; src <= 20; src++ {
+		_, message, err := client.ReadMessage()
+		assert.NoError(t, err)
+		// integers above 2^53 are sent unchanged
+		assert.JSONEq(t, `{"mt":"GetCounterResult","src":"`+strconv.Itoa(src)+`","id":`+strconv.FormatInt(int64(1<<60)+int64(src), 10)+`}`, string(message))
+	}
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// An app of the app service, like user.htm, admin.htm or a custom app.
+// The AppInfoResult sent to the PBX for the app is generated from it.
+type App struct {
+	Name        string                 // the name of the app without .htm, e.g. "user", "admin" or "searchapi"
+	Hidden      bool                   // the app is not shown in the myApps launcher, e.g. for api only apps
+	Icon        string                 // the icon of the app, relative to the path of the instance, e.g. "user.png"
+	Apis        map[string]interface{} // the apis provided by the app to other apps, e.g. com.innovaphone.search
+	ServiceApis map[string]interface{} // the apis provided by the app service, e.g. com.innovaphone.replicator
+}
+
+// returns the name of an app without the .htm suffix
+func AppName(app string) string {
+	return strings.TrimSuffix(app, ".htm")
+}
+
+// the app used for AppInfo requests of apps that are not registered,
+// as it was sent before apps could be registered
+func defaultApp(name string) *App {
+	return &App{
+		Name:        name,
+		Hidden:      name == "searchapi",
+		Apis:        map[string]interface{}{SearchApiName: map[string]interface{}{}},
+		ServiceApis: map[string]interface{}{ReplicatorApiName: map[string]interface{}{}},
+	}
+}
+
+// adds an app to the registry of the app service or replaces a registered app with the same name
+func (s *AppService) RegisterApp(app *App) error {
+	if app == nil || AppName(app.Name) == "" {
+		return fmt.Errorf("the app has no name")
+	}
+	s.AppsMutex.Lock()
+	defer s.AppsMutex.Unlock()
+	if s.Apps == nil {
+		s.Apps = map[string]*App{}
+	}
+	s.Apps[AppName(app.Name)] = app
+	return nil
+}
+
+// removes an app from the registry
+func (s *AppService) UnregisterApp(name string) {
+	s.AppsMutex.Lock()
+	defer s.AppsMutex.Unlock()
+	delete(s.Apps, AppName(name))
+}
+
+// returns the registered app by its name, with or without .htm
+func (s *AppService) GetApp(name string) (*App, bool) {
+	s.AppsMutex.RLock()
+	defer s.AppsMutex.RUnlock()
+	app, ok := s.Apps[AppName(name)]
+	return app, ok
+}
+
+// returns the AppInfoResult for the app. Apps that are not registered get the default AppInfoResult.
+func (s *AppService) GetAppInfoResult(name string) AppInfoResult {
+	app, ok := s.GetApp(name)
+	if !ok {
+		app = defaultApp(AppName(name))
+	}
+
+	return AppInfoResult{
+		BaseMessage: BaseMessage{
+			Mt: "AppInfoResult",
+		},
+		App: name,
+		Info: AppInfoInfo{
+			Hidden: app.Hidden,
+			Icon:   app.Icon,
+			Apis:   copyApis(app.Apis),
+		},
+		Serviceinfo: AppInfoServiceInfo{
+			Apis: copyApis(app.ServiceApis),
+		},
+	}
+}
+
+// returns a copy of the apis, apis without a value are sent as empty object
+func copyApis(apis map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for name, value := range apis {
+		if value == nil {
+			value = map[string]interface{}{}
+		}
+		result[name] = value
+	}
+	return result
+}
+
+// handles the clients of an app, like user.htm, admin.htm or reports.htm.
+// Messages of logged in clients without an api are passed to HandleMessage.
+type AppHandler interface {
+	OnConnect(connection *AppServicePbxConnection)
+	OnDisconnect(connection *AppServicePbxConnection)
+	HandleMessage(connection *AppServicePbxConnection, msg *BaseMessage, message []byte)
+}
+
+// sets the handler for the clients of an app, name is the name of the app with or without .htm
+func (s *AppService) RegisterAppHandler(name string, handler AppHandler) error {
+	if AppName(name) == "" {
+		return fmt.Errorf("the app has no name")
+	}
+	s.AppsMutex.Lock()
+	defer s.AppsMutex.Unlock()
+	if s.AppHandler == nil {
+		s.AppHandler = map[string]AppHandler{}
+	}
+	s.AppHandler[AppName(name)] = handler
+	return nil
+}
+
+// returns the handler of an app by its name, with or without .htm
+func (s *AppService) GetAppHandler(name string) (AppHandler, bool) {
+	s.AppsMutex.RLock()
+	defer s.AppsMutex.RUnlock()
+	handler, ok := s.AppHandler[AppName(name)]
+	return handler, ok
+}
+
+// returns true, if messages of clients of the app are handled by an AppHandler or AppMessageHandler
+func (s *AppService) HandlesApp(name string) bool {
+	s.AppsMutex.RLock()
+	defer s.AppsMutex.RUnlock()
+	_, hasHandler := s.AppHandler[AppName(name)]
+	return hasHandler || len(s.AppMessageHandler[AppName(name)]) > 0
+}
+
+// passes a message of a logged in client to the AppMessageHandler for its mt
+// or to the AppHandler of its app, if there is none
+func (s *AppService) HandleAppMessage(connection *AppServicePbxConnection, message []byte) {
+	msg := BaseMessage{}
+	if err := json.Unmarshal(message, &msg); err != nil {
+		connection.log().Errorf("server: error unmarshalling message: %v", err)
+		return
+	}
+	login, _ := connection.GetAppLogin()
+	app := AppName(login.App)
+
+	if handler, ok := s.GetAppMessageHandler(app, msg.Mt); ok {
+		w := &appResponseWriter{connection: connection, request: msg}
+		if err := handler.HandleAppMessage(connection.Session(), &msg, message, w); err != nil {
+			connection.log().Warnf("handling '%s' of app '%s' failed: %v", msg.Mt, app, err)
+			w.WriteError(err.Error())
+		}
+		return
+	}
+	if handler, ok := s.GetAppHandler(app); ok {
+		handler.HandleMessage(connection, &msg, message)
+		return
+	}
+	connection.log().Warnf("unknown mt: %s", string(message))
+	w := &appResponseWriter{connection: connection, request: msg}
+	w.WriteError("unknown mt '" + msg.Mt + "'")
+}
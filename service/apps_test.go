@@ -0,0 +1,121 @@
+package service_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppService_GetAppInfoResult(t *testing.T) {
+	s := &service.AppService{}
+	assert.NoError(t, s.RegisterApp(&service.App{
+		Name: "reports.htm",
+		Icon: "reports.png",
+		Apis: map[string]interface{}{
+			"com.innovaphone.search": nil,
+		},
+		ServiceApis: map[string]interface{}{
+			"com.innovaphone.replicator": map[string]interface{}{"info": map[string]interface{}{"tables": []string{"reports"}}},
+		},
+	}))
+	assert.NoError(t, s.RegisterApp(&service.App{Name: "wallboard", Hidden: true}))
+	assert.Error(t, s.RegisterApp(&service.App{Name: ".htm"}))
+
+	tests := []struct {
+		name     string
+		app      string
+		expected string
+	}{
+		{
+			"not registered",
+			"user",
+			`{"api":"","mt":"AppInfoResult","app":"user","info":{"hidden":false,"apis":{"com.innovaphone.search":{}}},"serviceInfo":{"apis":{"com.innovaphone.replicator":{}}}}`,
+		},
+		{
+			"not registered searchapi",
+			"searchapi",
+			`{"api":"","mt":"AppInfoResult","app":"searchapi","info":{"hidden":true,"apis":{"com.innovaphone.search":{}}},"serviceInfo":{"apis":{"com.innovaphone.replicator":{}}}}`,
+		},
+		{
+			"registered",
+			"reports",
+			`{"api":"","mt":"AppInfoResult","app":"reports","info":{"hidden":false,"icon":"reports.png","apis":{"com.innovaphone.search":{}}},"serviceInfo":{"apis":{"com.innovaphone.replicator":{"info":{"tables":["reports"]}}}}}`,
+		},
+		{
+			"registered without apis",
+			"wallboard.htm",
+			`{"api":"","mt":"AppInfoResult","app":"wallboard.htm","info":{"hidden":true,"apis":{}},"serviceInfo":{"apis":{}}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := json.Marshal(s.GetAppInfoResult(test.app))
+			assert.NoError(t, err)
+			assert.JSONEq(t, test.expected, string(result))
+		})
+	}
+
+	s.UnregisterApp("wallboard.htm")
+	_, ok := s.GetApp("wallboard")
+	assert.False(t, ok)
+}
+
+type testAppHandler struct {
+	connected    chan *service.AppServicePbxConnection
+	disconnected chan *service.AppServicePbxConnection
+	messages     chan string
+}
+
+func (h *testAppHandler) OnConnect(connection *service.AppServicePbxConnection) {
+	h.connected <- connection
+}
+
+func (h *testAppHandler) OnDisconnect(connection *service.AppServicePbxConnection) {
+	h.disconnected <- connection
+}
+
+func (h *testAppHandler) HandleMessage(connection *service.AppServicePbxConnection, msg *service.BaseMessage, message []byte) {
+	h.messages <- msg.Mt
+}
+
+func TestAppService_AppHandler(t *testing.T) {
+	s := &service.AppService{}
+	handler := &testAppHandler{
+		connected:    make(chan *service.AppServicePbxConnection, 1),
+		disconnected: make(chan *service.AppServicePbxConnection, 1),
+		messages:     make(chan string, 1),
+	}
+	assert.NoError(t, s.RegisterAppHandler("wallboard.htm", handler))
+
+	reports, reportsClient := newTestConnection(t, s)
+	reports.AppLogin.App = "reports.htm"
+	reports.Authenticated = true
+	s.AddConnection(reports)
+
+	wallboard, wallboardClient := newTestConnection(t, s)
+	wallboard.AppLogin.App = "wallboard"
+	wallboard.Authenticated = true
+	s.AddConnection(wallboard)
+
+	s.HandleAppConnected(wallboard, nil)
+	assert.Equal(t, wallboard, <-handler.connected)
+
+	assert.True(t, s.HandlesApp("wallboard"))
+	assert.False(t, s.HandlesApp("reports"))
+	s.HandleAppMessage(wallboard, []byte(`{"mt":"GetQueues"}`))
+	assert.Equal(t, "GetQueues", <-handler.messages)
+
+	s.SendToApp("wallboard.htm", []byte(`{"mt":"QueueUpdate"}`))
+	assert.Equal(t, "QueueUpdate", readMessage(t, wallboardClient)["mt"])
+
+	s.SendToApp("reports", []byte(`{"mt":"ReportUpdate"}`))
+	assert.Equal(t, "ReportUpdate", readMessage(t, reportsClient)["mt"])
+
+	s.DeleteConnection(wallboard)
+	s.HandleApiDisConnected(wallboard)
+	assert.Equal(t, wallboard, <-handler.disconnected)
+	assert.Len(t, s.GetConnections(), 1)
+}
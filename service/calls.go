@@ -0,0 +1,219 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// the timeout of a Call, if the context has no deadline
+var DefaultCallTimeout = 30 * time.Second
+
+var ErrConnectionClosed = errors.New("connection closed")
+
+// the error of a result with an error code, like "SetPresenceResult" with "error" and "errorText"
+type CallError struct {
+	Api  string
+	Mt   string
+	Code int
+	Text string
+}
+
+func (e *CallError) Error() string {
+	return fmt.Sprintf("%s %s: error %d: %s", e.Api, e.Mt, e.Code, e.Text)
+}
+
+type callResult struct {
+	Api       string `json:"api"`
+	Mt        string `json:"mt"`
+	Error     int    `json:"error,omitempty"`
+	Errortext string `json:"errorText,omitempty"`
+}
+
+var callCounter uint64
+
+// returns msg as json object for a call, fails if it has no mt
+func CallRequest(msg interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	// numbers are kept as they are, a float64 would change integers above 2^53
+	request := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&request); err != nil {
+		return nil, fmt.Errorf("the message is not a json object: %v", err)
+	}
+	if mt, _ := request["mt"].(string); mt == "" {
+		return nil, fmt.Errorf("the message has no mt")
+	}
+	return request, nil
+}
+
+// returns a *CallError if the result of the api has an error code
+func ResultError(api string, message json.RawMessage) error {
+	result := callResult{}
+	if err := json.Unmarshal(message, &result); err == nil && result.Error != 0 {
+		return &CallError{Api: api, Mt: result.Mt, Code: result.Error, Text: result.Errortext}
+	}
+	return nil
+}
+
+/*
+unmarshals the result of a call of the api into a T.
+
+callErr is the error of the call, the result is returned with it if the call returned a message,
+like for a *CallError.
+*/
+func ResultFor[T any](api string, message json.RawMessage, callErr error) (*T, error) {
+	if message == nil {
+		return nil, callErr
+	}
+	result := new(T)
+	if err := json.Unmarshal(message, result); err != nil {
+		return nil, fmt.Errorf("invalid result of %s: %v", api, err)
+	}
+	return result, callErr
+}
+
+/*
+the running calls of a connection, the results are correlated to the calls by their src.
+
+The connection passes every received message with a src to Resolve, and calls Close when it is closed.
+The zero value is ready to use.
+*/
+type Calls struct {
+	mu     sync.Mutex
+	calls  map[string]chan json.RawMessage // by src
+	closed bool
+}
+
+/*
+sends a message of an api with send and returns the first message passed to Resolve with the same src.
+
+The api of msg is set to api if it is not empty, the src to a new unique value. If the result has an
+error code, it is returned together with a *CallError. Without a deadline of the ctx, the call times out
+after DefaultCallTimeout.
+Only the messages the connection passes to Resolve are results, a result that is not passed times out the call.
+*/
+func (calls *Calls) Call(ctx context.Context, api string, msg interface{}, send func(message []byte) error) (json.RawMessage, error) {
+	request, err := CallRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+	src := "call" + strconv.FormatUint(atomic.AddUint64(&callCounter, 1), 10) + "-" + (&MyAppsUtils{}).GetRandomHexString(8)
+	if api != "" {
+		request["api"] = api
+	}
+	request["src"] = src
+	b, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultCallTimeout)
+		defer cancel()
+	}
+
+	ch, err := calls.add(src)
+	if err != nil {
+		return nil, err
+	}
+	defer calls.remove(src)
+
+	if err := send(b); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%s %s: %w", api, request["mt"], ctx.Err())
+	case message, ok := <-ch:
+		if !ok {
+			return nil, ErrConnectionClosed
+		}
+		return message, ResultError(api, message)
+	}
+}
+
+func (calls *Calls) add(src string) (chan json.RawMessage, error) {
+	calls.mu.Lock()
+	defer calls.mu.Unlock()
+	if calls.closed {
+		return nil, ErrConnectionClosed
+	}
+	if calls.calls == nil {
+		calls.calls = map[string]chan json.RawMessage{}
+	}
+	ch := make(chan json.RawMessage, 1)
+	calls.calls[src] = ch
+	return ch, nil
+}
+
+func (calls *Calls) remove(src string) {
+	calls.mu.Lock()
+	defer calls.mu.Unlock()
+	delete(calls.calls, src)
+}
+
+// passes the message to the Call waiting for its src, returns false if there is none
+func (calls *Calls) Resolve(src string, message []byte) bool {
+	if src == "" {
+		return false
+	}
+	calls.mu.Lock()
+	defer calls.mu.Unlock()
+	ch, ok := calls.calls[src]
+	if !ok {
+		return false
+	}
+	// the first message with the src is the result
+	delete(calls.calls, src)
+	ch <- message
+	return true
+}
+
+// lets the running calls return ErrConnectionClosed, new calls are rejected
+func (calls *Calls) Close() {
+	calls.mu.Lock()
+	defer calls.mu.Unlock()
+	calls.closed = true
+	for src, ch := range calls.calls {
+		close(ch)
+		delete(calls.calls, src)
+	}
+}
+
+/*
+sends a message of an api of the PBX and returns the first message sent back with the same src.
+
+The src of msg is set to a new unique value, so the result is correlated to the call.
+The result is not passed to the handlers of the api. If it has an error code, it is returned
+together with a *CallError. Without a deadline of the ctx, the call times out after DefaultCallTimeout.
+
+Results are only taken from messages with an api received after the AppLogin of the PBX,
+so the PBX has to send the result with the api of the call, and the connection has to be authenticated.
+Otherwise the call times out.
+*/
+func (connection *AppServicePbxConnection) Call(ctx context.Context, api string, msg interface{}) (json.RawMessage, error) {
+	return connection.calls.Call(ctx, api, msg, connection.WriteMessage)
+}
+
+/*
+sends msg with Call and unmarshals the result into a T.
+
+The error of a result with an error code is a *CallError, the result is returned with it.
+*/
+func CallFor[T any](ctx context.Context, connection *AppServicePbxConnection, api string, msg interface{}) (*T, error) {
+	message, err := connection.Call(ctx, api, msg)
+	return ResultFor[T](api, message, err)
+}
@@ -0,0 +1,149 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/stretchr/testify/assert"
+)
+
+type nodeInfo struct {
+	service.BaseMessage
+	Name string `json:"name"`
+}
+
+// records the messages passed to the handler of the api
+type recordingApi struct {
+	messages chan string
+}
+
+func (api *recordingApi) GetApiName() string                                       { return "PbxApi" }
+func (api *recordingApi) OnConnect(connection *service.AppServicePbxConnection)    {}
+func (api *recordingApi) OnDisconnect(connection *service.AppServicePbxConnection) {}
+func (api *recordingApi) HandleMessage(connection *service.AppServicePbxConnection, msg *service.BaseMessage, message []byte) {
+	api.messages <- msg.Src
+}
+
+func TestAppServicePbxConnection_Call(t *testing.T) {
+	api := &recordingApi{messages: make(chan string, 1)}
+	s := &service.AppService{}
+	s.RegisterHandler(api)
+	connection, pbx := newTestConnection(t, s)
+	connection.Authenticated = true
+	go connection.Loop()
+
+	ctx := context.Background()
+	results := make(chan *nodeInfo, 1)
+	errs := make(chan error, 1)
+	go func() {
+		result, err := service.CallFor[nodeInfo](ctx, connection, "PbxApi", service.BaseMessage{Mt: "GetNodeInfo", Src: "ignored"})
+		results <- result
+		errs <- err
+	}()
+
+	request := readMessage(t, pbx)
+	assert.Equal(t, "PbxApi", request["api"])
+	assert.Equal(t, "GetNodeInfo", request["mt"])
+	src := request["src"].(string)
+	assert.NotEqual(t, "ignored", src)
+
+	// messages with another src are passed to the handler
+	assert.NoError(t, pbx.WriteJSON(map[string]string{"api": "PbxApi", "mt": "PresenceUpdate", "src": "other"}))
+	assert.Equal(t, "other", <-api.messages)
+
+	assert.NoError(t, pbx.WriteJSON(map[string]string{"api": "PbxApi", "mt": "GetNodeInfoResult", "src": src, "name": "master"}))
+	assert.NoError(t, <-errs)
+	assert.Equal(t, "master", (<-results).Name)
+
+	// a result with an error code
+	go func() {
+		_, err := connection.Call(ctx, "PbxApi", map[string]string{"mt": "SetPresence"})
+		errs <- err
+	}()
+	request = readMessage(t, pbx)
+	assert.NoError(t, pbx.WriteJSON(map[string]interface{}{"api": "PbxApi", "mt": "SetPresenceResult", "src": request["src"], "error": 3, "errorText": "no user"}))
+	err := <-errs
+	callErr := &service.CallError{}
+	assert.True(t, errors.As(err, &callErr))
+	assert.Equal(t, 3, callErr.Code)
+	assert.Equal(t, "no user", callErr.Text)
+
+	// a timeout
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = connection.Call(timeoutCtx, "PbxApi", map[string]string{"mt": "GetNodeInfo"})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	readMessage(t, pbx)
+
+	_, err = connection.Call(ctx, "PbxApi", json.RawMessage(`[1]`))
+	assert.Error(t, err)
+
+	// a closed connection
+	go func() {
+		_, err := connection.Call(ctx, "PbxApi", map[string]string{"mt": "GetNodeInfo"})
+		errs <- err
+	}()
+	readMessage(t, pbx)
+	pbx.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	select {
+	case err := <-errs:
+		assert.Equal(t, service.ErrConnectionClosed, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("call did not return after the connection was closed")
+	}
+	_, err = connection.Call(ctx, "PbxApi", map[string]string{"mt": "GetNodeInfo"})
+	assert.Equal(t, service.ErrConnectionClosed, err)
+}
+
+func TestCalls(t *testing.T) {
+	calls := &service.Calls{}
+	sent := make(chan map[string]interface{}, 1)
+	send := func(message []byte) error {
+		request := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(message, &request))
+		sent <- request
+		return nil
+	}
+	ctx := context.Background()
+
+	results := make(chan json.RawMessage, 1)
+	go func() {
+		result, err := calls.Call(ctx, "", map[string]string{"mt": "Search"}, send)
+		assert.NoError(t, err)
+		results <- result
+	}()
+	request := <-sent
+	assert.Nil(t, request["api"], "an empty api is not set")
+	assert.False(t, calls.Resolve("other", []byte(`{"mt":"SearchResult"}`)))
+	assert.True(t, calls.Resolve(request["src"].(string), []byte(`{"mt":"SearchResult"}`)))
+	assert.JSONEq(t, `{"mt":"SearchResult"}`, string(<-results))
+	assert.False(t, calls.Resolve(request["src"].(string), []byte(`{"mt":"SearchResult"}`)), "the first message is the result")
+
+	// integers above 2^53 are sent unchanged
+	request, err := service.CallRequest(struct {
+		Mt string `json:"mt"`
+		Id int64  `json:"id"`
+	}{Mt: "GetObject", Id: 1<<60 + 1})
+	assert.NoError(t, err)
+	b, err := json.Marshal(request)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"mt":"GetObject","id":1152921504606846977}`, string(b))
+
+	failed := errors.New("write failed")
+	_, err = calls.Call(ctx, "Search", map[string]string{"mt": "Search"}, func(message []byte) error { return failed })
+	assert.Equal(t, failed, err)
+
+	calls.Close()
+	_, err = calls.Call(ctx, "Search", map[string]string{"mt": "Search"}, send)
+	assert.Equal(t, service.ErrConnectionClosed, err)
+
+	result, err := service.ResultFor[nodeInfo]("PbxApi", json.RawMessage(`{"mt":"GetNodeInfoResult","name":"master","error":1}`), service.ResultError("PbxApi", json.RawMessage(`{"mt":"GetNodeInfoResult","error":1}`)))
+	assert.Equal(t, "master", result.Name)
+	callErr := &service.CallError{}
+	assert.ErrorAs(t, err, &callErr)
+}
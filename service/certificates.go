@@ -0,0 +1,287 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+provides the certificate of the Http/Tls server for every handshake, see tls.Config.GetCertificate.
+
+The autocert.Manager of golang.org/x/crypto/acme/autocert implements it and can be used to get
+certificates from Let's Encrypt or another ACME server.
+*/
+type CertificateProvider interface {
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// implemented by CertificateProvider that answer ACME http-01 challenges, like autocert.Manager.
+// The Http server of the AppService is wrapped with the handler.
+type httpChallengeHandler interface {
+	HTTPHandler(fallback http.Handler) http.Handler
+}
+
+// provides always the same certificate
+type StaticCertificateProvider struct {
+	Certificate *tls.Certificate
+}
+
+// returns a provider for a certificate and its private key in PEM format
+func NewStaticCertificateProvider(certPEM, keyPEM string) (*StaticCertificateProvider, error) {
+	cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("error loading certificate: %v", err)
+	}
+	return &StaticCertificateProvider{Certificate: &cert}, nil
+}
+
+func (p *StaticCertificateProvider) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return p.Certificate, nil
+}
+
+/*
+provides the certificate from PEM files and reloads it when the files change.
+
+The files are checked on handshakes, at most once every CheckInterval. If a changed file cannot be
+loaded, e.g. because the certificate was written but the key not yet, the last certificate is used
+until both files are valid.
+*/
+type FileCertificateProvider struct {
+	CertFile      string
+	KeyFile       string
+	CheckInterval time.Duration // default 10 seconds
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheck   time.Time
+}
+
+// returns a provider for the files, the certificate is loaded immediately
+func NewFileCertificateProvider(certFile, keyFile string) (*FileCertificateProvider, error) {
+	p := &FileCertificateProvider{
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+		CheckInterval: 10 * time.Second,
+	}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// loads the certificate from the files
+func (p *FileCertificateProvider) Reload() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.load()
+}
+
+// the mutex has to be locked
+func (p *FileCertificateProvider) load() error {
+	certInfo, err := os.Stat(p.CertFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(p.KeyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+	if err != nil {
+		return fmt.Errorf("error loading certificate '%s': %v", p.CertFile, err)
+	}
+	p.certificate = &cert
+	p.certModTime = certInfo.ModTime()
+	p.keyModTime = keyInfo.ModTime()
+	p.lastCheck = time.Now()
+	return nil
+}
+
+// the mutex has to be locked
+func (p *FileCertificateProvider) changed() bool {
+	certInfo, err := os.Stat(p.CertFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(p.KeyFile)
+	if err != nil {
+		return false
+	}
+	return !certInfo.ModTime().Equal(p.certModTime) || !keyInfo.ModTime().Equal(p.keyModTime)
+}
+
+func (p *FileCertificateProvider) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	interval := p.CheckInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	if time.Since(p.lastCheck) >= interval {
+		p.lastCheck = time.Now()
+		if p.changed() {
+			if err := p.load(); err != nil {
+				log.Warnf("reloading certificate failed, using the last one: %v", err)
+			} else {
+				log.Infof("certificate '%s' reloaded", p.CertFile)
+			}
+		}
+	}
+	if p.certificate == nil {
+		return nil, fmt.Errorf("no certificate loaded from '%s'", p.CertFile)
+	}
+	return p.certificate, nil
+}
+
+/*
+issues certificates for the requested server names on demand, signed by a CA created in memory.
+
+It behaves like an ACME provider without the need of an ACME server and can be used for tests and
+local development. Clients have to trust the CA, see CACertificate.
+*/
+type SelfSignedCertificateProvider struct {
+	// returns an error for hosts no certificate should be issued for, all hosts are allowed if not set
+	HostPolicy func(host string) error
+	// the name used for clients that send no server name, default "localhost"
+	DefaultHost string
+	// the validity of issued certificates, default 90 days
+	Validity time.Duration
+
+	mu           sync.Mutex
+	caKey        *ecdsa.PrivateKey
+	ca           *x509.Certificate
+	certificates map[string]*tls.Certificate // by host
+}
+
+// returns a provider that issues certificates for the hosts only, or for all hosts if none are given
+func NewSelfSignedCertificateProvider(hosts ...string) (*SelfSignedCertificateProvider, error) {
+	p := &SelfSignedCertificateProvider{
+		DefaultHost:  "localhost",
+		Validity:     90 * 24 * time.Hour,
+		certificates: map[string]*tls.Certificate{},
+	}
+	if len(hosts) > 0 {
+		p.HostPolicy = func(host string) error {
+			if !containsString(hosts, host) {
+				return fmt.Errorf("host '%s' not allowed", host)
+			}
+			return nil
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          randomSerialNumber(),
+		Subject:               pkix.Name{CommonName: "go-myapps local CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	p.caKey = key
+	p.ca = ca
+	return p, nil
+}
+
+// returns the CA the certificates are signed with, e.g. to add it to the RootCAs of a client
+func (p *SelfSignedCertificateProvider) CACertificate() *x509.Certificate {
+	return p.ca
+}
+
+func (p *SelfSignedCertificateProvider) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if host == "" {
+		host = p.DefaultHost
+	}
+	if p.HostPolicy != nil {
+		if err := p.HostPolicy(host); err != nil {
+			return nil, err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cert, ok := p.certificates[host]; ok && time.Now().Before(cert.Leaf.NotAfter) {
+		return cert, nil
+	}
+	cert, err := p.issue(host)
+	if err != nil {
+		return nil, err
+	}
+	p.certificates[host] = cert
+	return cert, nil
+}
+
+// the mutex has to be locked
+func (p *SelfSignedCertificateProvider) issue(host string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	validity := p.Validity
+	if validity <= 0 {
+		validity = 90 * 24 * time.Hour
+	}
+	template := &x509.Certificate{
+		SerialNumber: randomSerialNumber(),
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, p.ca, &key.PublicKey, p.caKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, p.ca.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func randomSerialNumber() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(err)
+	}
+	return serial
+}
@@ -0,0 +1,111 @@
+package service_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// writes a certificate for the host as PEM files and returns its serial number
+func writeCertificate(t *testing.T, ca *service.SelfSignedCertificateProvider, host, certFile, keyFile string) string {
+	cert, err := ca.GetCertificate(&tls.ClientHelloInfo{ServerName: host})
+	assert.NoError(t, err)
+	key, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600))
+	return cert.Leaf.SerialNumber.String()
+}
+
+func TestFileCertificateProvider_Reload(t *testing.T) {
+	ca, err := service.NewSelfSignedCertificateProvider()
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+
+	first := writeCertificate(t, ca, "first.example.com", certFile, keyFile)
+	p, err := service.NewFileCertificateProvider(certFile, keyFile)
+	assert.NoError(t, err)
+	p.CheckInterval = time.Millisecond
+
+	serial := func() string {
+		cert, err := p.GetCertificate(&tls.ClientHelloInfo{})
+		assert.NoError(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		assert.NoError(t, err)
+		return leaf.SerialNumber.String()
+	}
+	assert.Equal(t, first, serial())
+
+	// a broken file keeps the last certificate
+	assert.NoError(t, os.WriteFile(keyFile, []byte("broken"), 0600))
+	os.Chtimes(keyFile, time.Now().Add(time.Minute), time.Now().Add(time.Minute))
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, first, serial())
+
+	second := writeCertificate(t, ca, "second.example.com", certFile, keyFile)
+	os.Chtimes(certFile, time.Now().Add(2*time.Minute), time.Now().Add(2*time.Minute))
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, second, serial())
+
+	_, err = service.NewFileCertificateProvider(filepath.Join(dir, "missing.crt"), keyFile)
+	assert.Error(t, err)
+}
+
+func TestSelfSignedCertificateProvider_HostPolicy(t *testing.T) {
+	p, err := service.NewSelfSignedCertificateProvider("apps.example.com")
+	assert.NoError(t, err)
+
+	cert, err := p.GetCertificate(&tls.ClientHelloInfo{ServerName: "apps.example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"apps.example.com"}, cert.Leaf.DNSNames)
+	assert.NoError(t, cert.Leaf.CheckSignatureFrom(p.CACertificate()))
+
+	again, err := p.GetCertificate(&tls.ClientHelloInfo{ServerName: "APPS.example.com."})
+	assert.NoError(t, err)
+	assert.Same(t, cert, again)
+
+	_, err = p.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"})
+	assert.Error(t, err)
+}
+
+func TestAppService_TlsCertificateProvider(t *testing.T) {
+	_, err := service.NewAppService("127.0.0.1", 0, 4443, "", "", "example.com", "go", "instance", "pwd", nil)
+	assert.Error(t, err)
+	_, err = service.NewAppServiceWithCertificateProvider("127.0.0.1", 0, 4443, nil, "example.com", "go", "instance", "pwd", nil)
+	assert.Error(t, err)
+
+	provider, err := service.NewSelfSignedCertificateProvider("localhost")
+	assert.NoError(t, err)
+	port := freePort(t)
+	s, err := service.NewAppServiceWithCertificateProvider("127.0.0.1", 0, port, provider, "example.com", "go", "instance", "pwd", nil)
+	assert.NoError(t, err)
+	s.TlsMinVersion = tls.VersionTLS13
+	assert.NoError(t, s.Start())
+	defer s.Shutdown(context.Background())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(provider.CACertificate())
+	address := fmt.Sprintf("127.0.0.1:%d", port)
+
+	conn, err := tls.Dial("tcp", address, &tls.Config{ServerName: "localhost", RootCAs: roots})
+	assert.NoError(t, err)
+	if err == nil {
+		assert.Equal(t, uint16(tls.VersionTLS13), conn.ConnectionState().Version)
+		conn.Close()
+	}
+
+	_, err = tls.Dial("tcp", address, &tls.Config{ServerName: "localhost", RootCAs: roots, MaxVersion: tls.VersionTLS12})
+	assert.Error(t, err)
+}
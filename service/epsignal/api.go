@@ -1,11 +1,27 @@
 package epsignal
 
 import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/events"
 	log "github.com/sirupsen/logrus"
 )
 
 type EpSignal struct {
+	events.Publisher[EpSignalEvent]
+
+	mu    sync.Mutex
+	calls map[*service.AppServicePbxConnection]map[int]*EpCall
+}
+
+func NewEpSignal() *EpSignal {
+	return &EpSignal{
+		calls: map[*service.AppServicePbxConnection]map[int]*EpCall{},
+	}
 }
 
 func (api *EpSignal) GetApiName() string {
@@ -13,13 +29,166 @@ func (api *EpSignal) GetApiName() string {
 }
 
 func (api *EpSignal) OnConnect(connection *service.AppServicePbxConnection) {
-	log.WithField("api", api.GetApiName()).Warn("OnConnect not implemented ")
+	api.Publish(EpSignalEvent{Type: EpSignalEventConnect, Connection: connection})
 }
 
 func (api *EpSignal) OnDisconnect(connection *service.AppServicePbxConnection) {
-	log.WithField("api", api.GetApiName()).Warn("OnDisconnect not implemented ")
+	api.mu.Lock()
+	delete(api.calls, connection)
+	api.mu.Unlock()
+	api.Publish(EpSignalEvent{Type: EpSignalEventDisconnect, Connection: connection})
 }
 
 func (api *EpSignal) HandleMessage(connection *service.AppServicePbxConnection, msg *service.BaseMessage, message []byte) {
-	log.WithField("api", api.GetApiName()).Warn("HandleMessage not implemented ")
+	switch msg.Mt {
+	case "AttachResult":
+		msg := AttachResult{}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Errorf("EpSignal: error unmarshalling message: %v", err)
+			return
+		}
+		if msg.Error != 0 {
+			log.Errorf("AttachResult: error %d: %s", msg.Error, msg.Errortext)
+		}
+		api.Publish(EpSignalEvent{Type: EpSignalEventAttachResult, AttachResult: &msg, Connection: connection})
+	case "DetachResult":
+		msg := DetachResult{}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Errorf("EpSignal: error unmarshalling message: %v", err)
+			return
+		}
+		api.Publish(EpSignalEvent{Type: EpSignalEventDetachResult, DetachResult: &msg, Connection: connection})
+	case "Signaling":
+		msg := Signaling{}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Errorf("EpSignal: error unmarshalling message: %v", err)
+			return
+		}
+		call := api.setCall(connection, msg, true)
+		api.Publish(EpSignalEvent{Type: EpSignalEventSignaling, Signaling: &msg, Call: &call, Connection: connection})
+	default:
+		log.Warnf("unknown message received: %s", msg.Mt)
+	}
+}
+
+/*
+sends the signaling message for the call, a setup starts an outgoing call with the id.
+
+The call is updated before the message is written, so an answer of the PBX that is handled
+before WriteMessage returns finds the outgoing call. If the write fails, the call is restored.
+*/
+func (api *EpSignal) Send(connection *service.AppServicePbxConnection, call int, sig Sig) error {
+	msg := NewSignaling(call, sig, "")
+	mbytes, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	previous, existed := api.getCall(connection, call)
+	api.setCall(connection, *msg, false)
+	if err := connection.WriteMessage(mbytes); err != nil {
+		api.restoreCall(connection, call, previous, existed)
+		return err
+	}
+	return nil
+}
+
+// calls the party with a new call with the id
+func (api *EpSignal) Setup(connection *service.AppServicePbxConnection, call int, cd Party) error {
+	return api.Send(connection, call, Sig{Type: SigSetup, Cd: &cd})
+}
+
+// signals that the endpoint is ringing for the incoming call
+func (api *EpSignal) Alert(connection *service.AppServicePbxConnection, call int) error {
+	return api.Send(connection, call, Sig{Type: SigAlert})
+}
+
+// accepts the incoming call
+func (api *EpSignal) Connect(connection *service.AppServicePbxConnection, call int) error {
+	return api.Send(connection, call, Sig{Type: SigConn})
+}
+
+// releases the call with the cause, 0 for a normal release
+func (api *EpSignal) Release(connection *service.AppServicePbxConnection, call int, cause int) error {
+	return api.Send(connection, call, Sig{Type: SigRel, Cause: cause})
+}
+
+// sends DTMF digits on the connected call
+func (api *EpSignal) SendDtmf(connection *service.AppServicePbxConnection, call int, digits string) error {
+	return api.Send(connection, call, Sig{Type: SigDtmf, Dtmf: digits})
+}
+
+// updates the call with the signaling message and returns a copy of it, incoming is true for messages of the PBX
+func (api *EpSignal) setCall(connection *service.AppServicePbxConnection, msg Signaling, incoming bool) EpCall {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.calls == nil {
+		api.calls = map[*service.AppServicePbxConnection]map[int]*EpCall{}
+	}
+	calls, ok := api.calls[connection]
+	if !ok {
+		calls = map[int]*EpCall{}
+		api.calls[connection] = calls
+	}
+	call, ok := calls[msg.Call]
+	if !ok {
+		call = &EpCall{Call: msg.Call, Incoming: incoming, Started: time.Now()}
+		calls[msg.Call] = call
+	}
+	if msg.Sig.Cg != nil {
+		call.Cg = *msg.Sig.Cg
+	}
+	if msg.Sig.Cd != nil {
+		call.Cd = *msg.Sig.Cd
+	}
+	switch msg.Sig.Type {
+	case SigDtmf, SigInfo:
+		// does not change the state
+	default:
+		call.State = msg.Sig.Type
+	}
+	if msg.Sig.Type == SigConn && call.Connected.IsZero() {
+		call.Connected = time.Now()
+	}
+	if msg.Sig.Type == SigRel {
+		delete(calls, msg.Call)
+	}
+	return *call
+}
+
+// returns a copy of the call with the id
+func (api *EpSignal) getCall(connection *service.AppServicePbxConnection, call int) (EpCall, bool) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if c, ok := api.calls[connection][call]; ok {
+		return *c, true
+	}
+	return EpCall{}, false
+}
+
+// sets the call back to the copy of getCall, or removes it if it did not exist
+func (api *EpSignal) restoreCall(connection *service.AppServicePbxConnection, call int, previous EpCall, existed bool) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	calls, ok := api.calls[connection]
+	if !ok {
+		// the connection is gone
+		return
+	}
+	if !existed {
+		delete(calls, call)
+		return
+	}
+	calls[call] = &previous
+}
+
+// returns the calls of the connection, sorted by id
+func (api *EpSignal) GetCalls(connection *service.AppServicePbxConnection) []EpCall {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	calls := make([]EpCall, 0, len(api.calls[connection]))
+	for _, call := range api.calls[connection] {
+		calls = append(calls, *call)
+	}
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Call < calls[j].Call })
+	return calls
 }
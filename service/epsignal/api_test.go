@@ -0,0 +1,103 @@
+package epsignal_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/epsignal"
+	"github.com/ricoschulte/go-myapps/service/events"
+	"github.com/ricoschulte/go-myapps/service/servicetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEpSignal(t *testing.T) {
+	requests := make(chan map[string]interface{}, 20)
+	connection := servicetest.NewConnection(t, func(peer *websocket.Conn, message map[string]interface{}) {
+		requests <- message
+		switch message["mt"] {
+		case "Attach":
+			peer.WriteJSON(map[string]interface{}{"api": "EpSignal", "mt": "AttachResult", "src": message["src"], "num": "10"})
+		case "Detach":
+			peer.WriteJSON(map[string]interface{}{"api": "EpSignal", "mt": "DetachResult", "src": message["src"]})
+		}
+	})
+	api := epsignal.NewEpSignal()
+	s := &service.AppService{}
+	s.RegisterHandler(api)
+	connection.AppService = s
+	connection.Authenticated = true
+	go connection.Loop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signaling := api.Subscribe(ctx, events.ForTypes[epsignal.EpSignalEvent](epsignal.EpSignalEventSignaling))
+
+	attached, err := epsignal.CallAttach(ctx, connection, "alice", "softphone-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "10", attached.Num)
+	request := <-requests
+	assert.Equal(t, "alice", request["sip"])
+	assert.Equal(t, "softphone-1", request["hw"])
+
+	// an incoming call
+	handle := func(message string) {
+		api.HandleMessage(connection, &service.BaseMessage{Api: "EpSignal", Mt: "Signaling"}, []byte(message))
+	}
+	handle(`{"api":"EpSignal","mt":"Signaling","call":1,"sig":{"type":"setup","cg":{"num":"20","dn":"Bob"},"cd":{"num":"10"}}}`)
+	event := <-signaling.C
+	assert.Equal(t, epsignal.SigSetup, event.Signaling.Sig.Type)
+	assert.True(t, event.Call.Incoming)
+	assert.Equal(t, "Bob", event.Call.Cg.Dn)
+
+	assert.NoError(t, api.Alert(connection, 1))
+	assert.NoError(t, api.Connect(connection, 1))
+	assert.NoError(t, api.SendDtmf(connection, 1, "5"))
+	for _, want := range []string{"alert", "conn", "dtmf"} {
+		request := <-requests
+		assert.Equal(t, "Signaling", request["mt"])
+		assert.Equal(t, want, request["sig"].(map[string]interface{})["type"])
+	}
+	calls := api.GetCalls(connection)
+	if assert.Len(t, calls, 1) {
+		assert.Equal(t, epsignal.SigConn, calls[0].State)
+		assert.False(t, calls[0].Connected.IsZero())
+	}
+
+	// an outgoing call
+	assert.NoError(t, api.Setup(connection, 2, epsignal.Party{Num: "30"}))
+	assert.Equal(t, "30", (<-requests)["sig"].(map[string]interface{})["cd"].(map[string]interface{})["num"])
+	calls = api.GetCalls(connection)
+	if assert.Len(t, calls, 2) {
+		assert.False(t, calls[1].Incoming)
+	}
+
+	handle(`{"api":"EpSignal","mt":"Signaling","call":1,"sig":{"type":"rel","cause":16}}`)
+	event = <-signaling.C
+	assert.Equal(t, 16, event.Signaling.Sig.Cause)
+	assert.NoError(t, api.Release(connection, 2, 0))
+	<-requests
+	assert.Empty(t, api.GetCalls(connection))
+
+	_, err = epsignal.CallDetach(ctx, connection)
+	assert.NoError(t, err)
+}
+
+func TestEpSignal_SendFails(t *testing.T) {
+	connection := servicetest.NewConnection(t, nil)
+	api := epsignal.NewEpSignal()
+	handle := func(message string) {
+		api.HandleMessage(connection, &service.BaseMessage{Api: "EpSignal", Mt: "Signaling"}, []byte(message))
+	}
+	handle(`{"api":"EpSignal","mt":"Signaling","call":1,"sig":{"type":"setup","cg":{"num":"20"}}}`)
+
+	// a message that is not written does not change the calls
+	assert.NoError(t, connection.Close(websocket.CloseNormalClosure, ""))
+	assert.Error(t, api.Alert(connection, 1))
+	assert.Error(t, api.Setup(connection, 2, epsignal.Party{Num: "30"}))
+	calls := api.GetCalls(connection)
+	if assert.Len(t, calls, 1) {
+		assert.Equal(t, epsignal.SigSetup, calls[0].State)
+		assert.True(t, calls[0].Incoming)
+	}
+}
@@ -0,0 +1,17 @@
+package epsignal
+
+import (
+	"context"
+
+	"github.com/ricoschulte/go-myapps/service"
+)
+
+// registers the app as endpoint of the user and waits for the result
+func CallAttach(ctx context.Context, connection *service.AppServicePbxConnection, sip, hw string) (*AttachResult, error) {
+	return service.CallFor[AttachResult](ctx, connection, "EpSignal", NewAttach(sip, hw, ""))
+}
+
+// removes the registration and waits for the result
+func CallDetach(ctx context.Context, connection *service.AppServicePbxConnection) (*DetachResult, error) {
+	return service.CallFor[DetachResult](ctx, connection, "EpSignal", NewDetach(""))
+}
@@ -0,0 +1,128 @@
+package epsignal
+
+import (
+	"time"
+
+	"github.com/ricoschulte/go-myapps/service"
+)
+
+func newBaseMessage(mt, src string) service.BaseMessage {
+	return service.BaseMessage{
+		Api: "EpSignal",
+		Mt:  mt,
+		Src: src,
+	}
+}
+
+// registers the app as endpoint of the user with the sip name, calls to it are sent as Signaling
+type Attach struct {
+	service.BaseMessage
+	Sip string `json:"sip"`
+	Hw  string `json:"hw,omitempty"` // the id of the device of the user, the app registers as
+	Dn  string `json:"dn,omitempty"`
+}
+
+func NewAttach(sip, hw, src string) *Attach {
+	return &Attach{
+		BaseMessage: newBaseMessage("Attach", src),
+		Sip:         sip,
+		Hw:          hw,
+	}
+}
+
+type AttachResult struct {
+	service.BaseMessage
+	Num       string `json:"num,omitempty"` // the number of the endpoint
+	Error     int    `json:"error,omitempty"`
+	Errortext string `json:"errorText,omitempty"`
+}
+
+// removes the registration of Attach
+type Detach struct {
+	service.BaseMessage
+}
+
+func NewDetach(src string) *Detach {
+	return &Detach{BaseMessage: newBaseMessage("Detach", src)}
+}
+
+type DetachResult struct {
+	service.BaseMessage
+	Error     int    `json:"error,omitempty"`
+	Errortext string `json:"errorText,omitempty"`
+}
+
+// a calling or called party
+type Party struct {
+	Num string `json:"num,omitempty"`
+	Sip string `json:"sip,omitempty"`
+	Dn  string `json:"dn,omitempty"`
+}
+
+// the values of Sig.Type
+const (
+	SigSetup = "setup"
+	SigAlert = "alert"
+	SigConn  = "conn"
+	SigRel   = "rel"
+	SigDtmf  = "dtmf"
+	SigInfo  = "info"
+)
+
+// a signaling message of a call
+type Sig struct {
+	Type  string `json:"type"`
+	Cg    *Party `json:"cg,omitempty"`    // the calling party
+	Cd    *Party `json:"cd,omitempty"`    // the called party
+	Cause int    `json:"cause,omitempty"` // the cause of a rel
+	Dtmf  string `json:"dtmf,omitempty"`  // the digits of a dtmf
+}
+
+// a signaling message of a call of the endpoint, in both directions
+type Signaling struct {
+	service.BaseMessage
+	Call int `json:"call"`
+	Sig  Sig `json:"sig"`
+}
+
+func NewSignaling(call int, sig Sig, src string) *Signaling {
+	return &Signaling{
+		BaseMessage: newBaseMessage("Signaling", src),
+		Call:        call,
+		Sig:         sig,
+	}
+}
+
+// a call of the endpoint, tracked from the Signaling messages
+type EpCall struct {
+	Call      int
+	Incoming  bool
+	State     string // the Type of the last Sig
+	Cg        Party
+	Cd        Party
+	Started   time.Time
+	Connected time.Time
+}
+
+type EpSignalEvent struct {
+	Type         int
+	Connection   *service.AppServicePbxConnection
+	Signaling    *Signaling
+	Call         *EpCall // a copy of the call after the Signaling
+	AttachResult *AttachResult
+	DetachResult *DetachResult
+}
+
+const EpSignalEventDisconnect = -20
+const EpSignalEventConnect = -10
+const EpSignalEventAttachResult = 10
+const EpSignalEventDetachResult = 20
+const EpSignalEventSignaling = 30
+
+func (event EpSignalEvent) GetType() int {
+	return event.Type
+}
+
+func (event EpSignalEvent) GetConnection() *service.AppServicePbxConnection {
+	return event.Connection
+}
@@ -0,0 +1,284 @@
+/*
+Package events distributes the events of the apis of an app service to their subscribers.
+
+Every subscriber has its own buffered queue, so a slow subscriber does not block the
+others. What happens when the queue of a subscriber is full is set by its Policy.
+*/
+package events
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// what happens with an event when the queue of a subscriber is full
+type Policy int
+
+const (
+	Drop       Policy = iota // the new event is dropped
+	DropOldest               // the oldest event in the queue is dropped
+	Block                    // the publisher waits until the subscriber takes the event, or the BlockTimeout is over
+)
+
+const DefaultBuffer = 100
+
+type Option[E any] func(s *Subscription[E])
+
+// sets the size of the queue of the subscriber, default DefaultBuffer
+func WithBuffer[E any](size int) Option[E] {
+	return func(s *Subscription[E]) {
+		s.buffer = size
+	}
+}
+
+// sets the policy for a full queue, default Drop
+func WithPolicy[E any](policy Policy) Option[E] {
+	return func(s *Subscription[E]) {
+		s.policy = policy
+	}
+}
+
+// limits the time a publisher waits with the Block policy, the event is dropped then. No limit if 0.
+func WithBlockTimeout[E any](timeout time.Duration) Option[E] {
+	return func(s *Subscription[E]) {
+		s.blockTimeout = timeout
+	}
+}
+
+// calls onDrop with every event that is dropped for the subscriber, e.g. to log it.
+// It is called by the publisher, so it must not block.
+func WithOnDrop[E any](onDrop func(event E)) Option[E] {
+	return func(s *Subscription[E]) {
+		s.onDrop = onDrop
+	}
+}
+
+// passes only the events the filter returns true for, multiple filters must all match
+func WithFilter[E any](filter func(event E) bool) Option[E] {
+	return func(s *Subscription[E]) {
+		s.filters = append(s.filters, filter)
+	}
+}
+
+// implemented by the events of the apis
+type Typed interface {
+	GetType() int
+}
+
+// implemented by the events of the apis, C is the type of their connections, like *service.AppServicePbxConnection
+type Connected[C comparable] interface {
+	GetConnection() C
+}
+
+// passes only events of the types
+func ForTypes[E Typed](types ...int) Option[E] {
+	return WithFilter(func(event E) bool {
+		for _, t := range types {
+			if event.GetType() == t {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// passes only events of the connection
+func ForConnection[E Connected[C], C comparable](connection C) Option[E] {
+	return WithFilter(func(event E) bool {
+		return event.GetConnection() == connection
+	})
+}
+
+// a subscriber of a Bus
+type Subscription[E any] struct {
+	C <-chan E // the events, closed by Unsubscribe
+
+	bus          *Bus[E]
+	ch           chan E
+	buffer       int
+	policy       Policy
+	blockTimeout time.Duration
+	filters      []func(event E) bool
+	onDrop       func(event E)
+
+	mu      sync.Mutex // held while an event is put into ch, so it is not closed meanwhile
+	closed  bool
+	done    chan struct{}
+	once    sync.Once
+	dropped uint64
+}
+
+// returns the number of events dropped because the queue was full
+func (s *Subscription[E]) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// removes the subscriber from the bus and closes C
+func (s *Subscription[E]) Unsubscribe() {
+	s.once.Do(func() {
+		close(s.done)
+		s.bus.remove(s)
+		s.mu.Lock()
+		s.closed = true
+		close(s.ch)
+		s.mu.Unlock()
+	})
+}
+
+func (s *Subscription[E]) drop(event E) {
+	atomic.AddUint64(&s.dropped, 1)
+	if s.onDrop != nil {
+		s.onDrop(event)
+	}
+}
+
+func (s *Subscription[E]) matches(event E) bool {
+	for _, filter := range s.filters {
+		if !filter(event) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Subscription[E]) deliver(event E) {
+	if !s.matches(event) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	switch s.policy {
+	case Block:
+		var timeout <-chan time.Time
+		if s.blockTimeout > 0 {
+			timer := time.NewTimer(s.blockTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case s.ch <- event:
+		case <-s.done:
+		case <-timeout:
+			s.drop(event)
+		}
+	case DropOldest:
+		if cap(s.ch) == 0 {
+			// there is no oldest event
+			select {
+			case s.ch <- event:
+			default:
+				s.drop(event)
+			}
+			return
+		}
+		for {
+			select {
+			case s.ch <- event:
+				return
+			default:
+			}
+			select {
+			case oldest := <-s.ch:
+				s.drop(oldest)
+			default:
+			}
+		}
+	default:
+		select {
+		case s.ch <- event:
+		default:
+			s.drop(event)
+		}
+	}
+}
+
+// distributes events of type E to its subscribers
+type Bus[E any] struct {
+	mu          sync.RWMutex
+	subscribers []*Subscription[E]
+}
+
+func NewBus[E any]() *Bus[E] {
+	return &Bus[E]{}
+}
+
+// adds a subscriber, it is removed when ctx is done or Unsubscribe is called
+func (b *Bus[E]) Subscribe(ctx context.Context, options ...Option[E]) *Subscription[E] {
+	return b.subscribe(ctx, nil, options)
+}
+
+// adds a subscriber that receives the events on ch, e.g. for receivers created before the Bus.
+// The size of the queue is the capacity of ch, ch is closed by Unsubscribe.
+func (b *Bus[E]) SubscribeChan(ctx context.Context, ch chan E, options ...Option[E]) *Subscription[E] {
+	return b.subscribe(ctx, ch, options)
+}
+
+func (b *Bus[E]) subscribe(ctx context.Context, ch chan E, options []Option[E]) *Subscription[E] {
+	s := &Subscription[E]{
+		bus:    b,
+		buffer: DefaultBuffer,
+		policy: Drop,
+		done:   make(chan struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if ch == nil {
+		if s.buffer < 0 {
+			s.buffer = 0
+		}
+		ch = make(chan E, s.buffer)
+	}
+	s.ch = ch
+	s.C = ch
+
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, s)
+	b.mu.Unlock()
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.Unsubscribe()
+			case <-s.done:
+			}
+		}()
+	}
+	return s
+}
+
+func (b *Bus[E]) remove(s *Subscription[E]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, subscriber := range b.subscribers {
+		if subscriber == s {
+			b.subscribers = append(b.subscribers[:i], b.subscribers[i+1:]...)
+			return
+		}
+	}
+}
+
+// returns the number of subscribers
+func (b *Bus[E]) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers)
+}
+
+// passes the event to all subscribers. Only subscribers with the Block policy can delay it.
+func (b *Bus[E]) Publish(event E) {
+	b.mu.RLock()
+	subscribers := make([]*Subscription[E], len(b.subscribers))
+	copy(subscribers, b.subscribers)
+	b.mu.RUnlock()
+
+	for _, s := range subscribers {
+		s.deliver(event)
+	}
+}
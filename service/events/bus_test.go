@@ -0,0 +1,167 @@
+package events_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ricoschulte/go-myapps/service/events"
+	"github.com/stretchr/testify/assert"
+)
+
+type testConnection struct {
+	name string
+}
+
+type testEvent struct {
+	Type       int
+	Connection *testConnection
+}
+
+func (e testEvent) GetType() int                   { return e.Type }
+func (e testEvent) GetConnection() *testConnection { return e.Connection }
+
+// returns the types of the events in the channel
+func drain(ch <-chan testEvent) []int {
+	types := []int{}
+	for {
+		select {
+		case event, ok := <-ch:
+			if !ok {
+				return types
+			}
+			types = append(types, event.Type)
+		default:
+			return types
+		}
+	}
+}
+
+func TestBus_Policies(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []events.Option[testEvent]
+		want    []int
+		dropped uint64
+	}{
+		{"drop", []events.Option[testEvent]{events.WithBuffer[testEvent](2)}, []int{1, 2}, 2},
+		{"drop oldest", []events.Option[testEvent]{events.WithBuffer[testEvent](2), events.WithPolicy[testEvent](events.DropOldest)}, []int{3, 4}, 2},
+		{"drop oldest unbuffered", []events.Option[testEvent]{events.WithBuffer[testEvent](0), events.WithPolicy[testEvent](events.DropOldest)}, []int{}, 4},
+		{"block with timeout", []events.Option[testEvent]{events.WithBuffer[testEvent](2), events.WithPolicy[testEvent](events.Block), events.WithBlockTimeout[testEvent](time.Millisecond)}, []int{1, 2}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := events.NewBus[testEvent]()
+			subscription := bus.Subscribe(context.Background(), tt.options...)
+			for i := 1; i <= 4; i++ {
+				bus.Publish(testEvent{Type: i})
+			}
+			assert.Equal(t, tt.want, drain(subscription.C))
+			assert.Equal(t, tt.dropped, subscription.Dropped())
+		})
+	}
+}
+
+func TestBus_SlowSubscriber(t *testing.T) {
+	bus := events.NewBus[testEvent]()
+	slow := bus.Subscribe(context.Background(), events.WithBuffer[testEvent](0), events.WithPolicy[testEvent](events.Block))
+	fast := bus.Subscribe(context.Background())
+
+	published := make(chan struct{})
+	go func() {
+		bus.Publish(testEvent{Type: 1})
+		close(published)
+	}()
+	// the blocked publisher ends when the slow subscriber is removed
+	time.Sleep(10 * time.Millisecond)
+	slow.Unsubscribe()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("publish blocked after unsubscribe")
+	}
+	_, ok := <-slow.C
+	assert.False(t, ok)
+
+	bus.Publish(testEvent{Type: 2})
+	assert.Equal(t, []int{1, 2}, drain(fast.C))
+	assert.Equal(t, 1, bus.Len())
+}
+
+func TestBus_Filters(t *testing.T) {
+	bus := events.NewBus[testEvent]()
+	a := &testConnection{name: "a"}
+	b := &testConnection{name: "b"}
+	ofA := bus.Subscribe(context.Background(), events.ForConnection[testEvent](a), events.ForTypes[testEvent](1, 3))
+
+	bus.Publish(testEvent{Type: 1, Connection: a})
+	bus.Publish(testEvent{Type: 1, Connection: b})
+	bus.Publish(testEvent{Type: 2, Connection: a})
+	bus.Publish(testEvent{Type: 3, Connection: a})
+	assert.Equal(t, []int{1, 3}, drain(ofA.C))
+}
+
+func TestBus_ContextUnsubscribe(t *testing.T) {
+	bus := events.NewBus[testEvent]()
+	ctx, cancel := context.WithCancel(context.Background())
+	subscription := bus.Subscribe(ctx)
+	assert.Equal(t, 1, bus.Len())
+
+	cancel()
+	select {
+	case _, ok := <-subscription.C:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("subscription was not closed")
+	}
+	assert.Equal(t, 0, bus.Len())
+	// a second unsubscribe does nothing
+	subscription.Unsubscribe()
+}
+
+func TestPublisher(t *testing.T) {
+	publisher := &events.Publisher[testEvent]{}
+	receiver := publisher.AddReceiver()
+	subscription := publisher.Subscribe(context.Background(), events.ForTypes[testEvent](2))
+
+	// a receiver gets all events
+	count := events.DefaultBuffer * 3
+	received := make(chan int)
+	go func() {
+		n := 0
+		for range receiver {
+			n++
+			if n == count {
+				break
+			}
+		}
+		received <- n
+	}()
+	for i := 1; i <= count; i++ {
+		publisher.Publish(testEvent{Type: i%2 + 1})
+	}
+	assert.Equal(t, count, <-received)
+	assert.Len(t, drain(subscription.C), events.DefaultBuffer, "the subscriber drops the events of type 2 it can not take")
+	assert.Equal(t, uint64(count/2-events.DefaultBuffer), subscription.Dropped())
+
+	publisher.RemoveReceiver(receiver)
+
+	// a receiver that is not read delays Publish at most ReceiverTimeout
+	publisher.ReceiverTimeout = time.Millisecond
+	receiver = publisher.AddReceiver()
+	for i := 0; i < events.DefaultBuffer+2; i++ {
+		publisher.Publish(testEvent{Type: 3})
+	}
+	assert.Len(t, drain(receiver), events.DefaultBuffer)
+
+	// a shared bus gets the events of both publishers
+	shared := &events.Publisher[testEvent]{}
+	shared.SetBus(publisher.Bus())
+	shared.Publish(testEvent{Type: 3})
+	assert.Equal(t, []int{3}, drain(receiver))
+
+	publisher.RemoveReceiver(receiver)
+	_, ok := <-receiver
+	assert.False(t, ok, "the channel is closed")
+	publisher.RemoveReceiver(receiver)
+}
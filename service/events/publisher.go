@@ -0,0 +1,89 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+/*
+publishes the events of an api on a Bus, it is embedded in the apis for their Subscribe and AddReceiver.
+
+The zero value is ready to use, the Bus is created with the first use.
+*/
+type Publisher[E any] struct {
+	// the time Publish waits for a receiver of AddReceiver with a full channel, the event is dropped and logged then.
+	// With 0 Publish waits until the receiver takes the event, so the receivers get every event.
+	ReceiverTimeout time.Duration
+
+	mu        sync.Mutex
+	bus       *Bus[E]
+	receivers map[chan E]*Subscription[E]
+}
+
+// returns the bus the events are published on
+func (p *Publisher[E]) Bus() *Bus[E] {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.bus == nil {
+		p.bus = NewBus[E]()
+	}
+	return p.bus
+}
+
+// publishes the events on bus, e.g. to share one bus between several apis. Existing subscribers stay on the old bus.
+func (p *Publisher[E]) SetBus(bus *Bus[E]) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.bus = bus
+}
+
+// adds a subscriber for the events, e.g. with ForTypes or ForConnection as option.
+// The subscriber is removed when ctx is done.
+func (p *Publisher[E]) Subscribe(ctx context.Context, options ...Option[E]) *Subscription[E] {
+	return p.Bus().Subscribe(ctx, options...)
+}
+
+/*
+returns a channel for all events.
+
+No event is lost: when the channel is full, Publish waits until the receiver takes the event, or at most
+ReceiverTimeout if it is set. So a receiver has to be read, use Subscribe for a subscriber with another Policy.
+*/
+func (p *Publisher[E]) AddReceiver() chan E {
+	ch := make(chan E, DefaultBuffer)
+	timeout := p.ReceiverTimeout
+	subscription := p.Bus().SubscribeChan(context.Background(), ch,
+		WithPolicy[E](Block),
+		WithBlockTimeout[E](timeout),
+		WithOnDrop(func(event E) {
+			log.Warnf("events: a receiver did not take the %T within %v, it is dropped", event, timeout)
+		}),
+	)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.receivers == nil {
+		p.receivers = map[chan E]*Subscription[E]{}
+	}
+	p.receivers[ch] = subscription
+	return ch
+}
+
+// removes the receiver and closes its channel
+func (p *Publisher[E]) RemoveReceiver(ch chan E) {
+	p.mu.Lock()
+	subscription, ok := p.receivers[ch]
+	delete(p.receivers, ch)
+	p.mu.Unlock()
+	if ok {
+		// Close the channel to signal the receiver that it should stop listening
+		subscription.Unsubscribe()
+	}
+}
+
+// passes the event to the subscribers
+func (p *Publisher[E]) Publish(event E) {
+	p.Bus().Publish(event)
+}
@@ -0,0 +1,57 @@
+package service_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+)
+
+// returns a AppServicePbxConnection on the server side and the websocket of the client connected to it
+func newTestConnection(t *testing.T, appservice *service.AppService) (*service.AppServicePbxConnection, *websocket.Conn) {
+	upgrader := websocket.Upgrader{}
+	connections := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		connections <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	conn := <-connections
+	t.Cleanup(func() { conn.Close() })
+	return service.NewAppServicePbxConnection(appservice, conn), client
+}
+
+func readMessage(t *testing.T, client *websocket.Conn) map[string]interface{} {
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, message, err := client.ReadMessage()
+	if err != nil {
+		t.Fatalf("reading message failed: %v", err)
+	}
+	msg := map[string]interface{}{}
+	if err := json.Unmarshal(message, &msg); err != nil {
+		t.Fatalf("unmarshalling message failed: %v", err)
+	}
+	return msg
+}
+
+func handle(api service.PbxApiInterface, connection *service.AppServicePbxConnection, message string) {
+	msg := service.BaseMessage{}
+	json.Unmarshal([]byte(message), &msg)
+	api.HandleMessage(connection, &msg, []byte(message))
+}
@@ -15,16 +15,16 @@ func AppIndex(appservice *AppService, w http.ResponseWriter, req *http.Request)
 }
 
 // handles both websocket and http GET/POST requests on the same path
-func handleConnectionForHttpOrWebsocket(appservice *AppService, w http.ResponseWriter, r *http.Request) {
+func handleConnectionForHttpOrWebsocket(appservice *AppService, instance *AppInstance, w http.ResponseWriter, r *http.Request) {
 	log.Warnf("serve handleConnectionForHttpOrWebsocket %s", r.URL.Path)
-	
+
 	if r.Method != http.MethodGet && r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	if r.Header.Get("Upgrade") == "websocket" {
-		HandleWebsocket(appservice, w, r)
+		handleWebsocket(appservice, instance, w, r)
 	} else {
 		AppIndex(appservice, w, r)
 	}
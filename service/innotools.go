@@ -1,13 +1,11 @@
 package service
 
 import (
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"math/rand"
-	"strings"
-	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -43,14 +41,14 @@ func (mu *MyAppsUtils) GetDigestForAppLoginFromJson(message_json, password, chal
 	return calculated_digest, nil
 }
 
+// returns a random string of n hex chars, read from crypto/rand
 func (mu *MyAppsUtils) GetRandomHexString(n int) string {
-	charPool := "abcdef0123456789"
-	rand.Seed(time.Now().UnixNano()) // does
-	b := strings.Builder{}
-	for i := 0; i < n; i++ {
-		b.WriteByte(charPool[rand.Intn(len(charPool))])
+	b := make([]byte, (n+1)/2)
+	if _, err := rand.Read(b); err != nil {
+		// without a working random source no secure challenges can be created
+		panic(fmt.Sprintf("reading random bytes failed: %v", err))
 	}
-	return b.String()
+	return hex.EncodeToString(b)[:n]
 }
 
 func CheckAppPasswordForMaximumLength(password string) error {
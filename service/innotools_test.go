@@ -75,3 +75,10 @@ func TestGetRandomHexString(t *testing.T) {
 		t.Errorf("two equal strings received, when two should be not equal aka random")
 	}
 }
+
+func TestGetRandomHexString_OddLength(t *testing.T) {
+	mu := &service.MyAppsUtils{}
+	for _, length := range []int{0, 1, 15, 33} {
+		assert.Equal(t, length, len(mu.GetRandomHexString(length)))
+	}
+}
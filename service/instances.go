@@ -0,0 +1,550 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service/store"
+	log "github.com/sirupsen/logrus"
+)
+
+var ErrInstanceNotFound = errors.New("instance not found")
+
+// an instance of the app service for a PBX, served on /<domain>/<name>/<instance>
+type AppInstance struct {
+	Domain   string `json:"domain"`
+	Instance string `json:"instance"`
+	Password string `json:"password,omitempty"` // the password the PBX uses for the AppLogin
+	Dialect  string `json:"dialect,omitempty"`  // the dialect of the database, see store.GetDialect
+	Database string `json:"database,omitempty"` // the dsn of the database, the instance has no database if empty
+
+	Store      *store.Store      `json:"-"` // the opened database of the instance
+	Fs         http.FileSystem   `json:"-"` // the static files of the instance, the Fs of the AppService is used if nil
+	ApiHandler []PbxApiInterface `json:"-"` // the handlers of this instance, used in addition to the ones of the AppService
+
+	mu          sync.RWMutex
+	connections []*AppServicePbxConnection
+	deleted     bool // set by DeleteInstance, connections are not added anymore
+}
+
+// returns the key of the instance in the InstanceStore
+func (instance *AppInstance) Key() string {
+	return instanceKey(instance.Domain, instance.Instance)
+}
+
+func instanceKey(domain, instance string) string {
+	return strings.ToLower(domain) + "/" + instance
+}
+
+func (instance *AppInstance) validate() error {
+	for name, value := range map[string]string{"domain": instance.Domain, "instance": instance.Instance} {
+		if value == "" {
+			return fmt.Errorf("the %s is missing", name)
+		}
+		if strings.ContainsAny(value, "/?#") {
+			return fmt.Errorf("the %s '%s' contains invalid characters", name, value)
+		}
+	}
+	if instance.Password == "" {
+		return fmt.Errorf("the password is missing")
+	}
+	if instance.Database != "" {
+		if _, err := store.GetDialect(instance.Dialect); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// persists the instances of the app service by key, implemented by FileInstanceStore and store.Repository[AppInstance]
+type InstanceStore interface {
+	List(ctx context.Context) (map[string]*AppInstance, error)
+	Put(ctx context.Context, key string, instance *AppInstance) error
+	Delete(ctx context.Context, key string) error
+}
+
+var _ InstanceStore = (*store.Repository[AppInstance])(nil)
+
+// stores the instances in a json file
+type FileInstanceStore struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func NewFileInstanceStore(path string) *FileInstanceStore {
+	return &FileInstanceStore{Path: path}
+}
+
+// the mutex has to be locked
+func (fs *FileInstanceStore) read() (map[string]*AppInstance, error) {
+	instances := map[string]*AppInstance{}
+	data, err := os.ReadFile(fs.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return instances, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &instances); err != nil {
+		return nil, fmt.Errorf("invalid instances file '%s': %v", fs.Path, err)
+	}
+	return instances, nil
+}
+
+// the mutex has to be locked
+func (fs *FileInstanceStore) write(instances map[string]*AppInstance) error {
+	data, err := json.MarshalIndent(instances, "", "  ")
+	if err != nil {
+		return err
+	}
+	// replace the file at once, so it is never written partly
+	tmp, err := os.CreateTemp(filepath.Dir(fs.Path), filepath.Base(fs.Path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fs.Path)
+}
+
+func (fs *FileInstanceStore) List(ctx context.Context) (map[string]*AppInstance, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.read()
+}
+
+func (fs *FileInstanceStore) Put(ctx context.Context, key string, instance *AppInstance) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	instances, err := fs.read()
+	if err != nil {
+		return err
+	}
+	instances[key] = instance
+	return fs.write(instances)
+}
+
+func (fs *FileInstanceStore) Delete(ctx context.Context, key string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	instances, err := fs.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := instances[key]; !ok {
+		return ErrInstanceNotFound
+	}
+	delete(instances, key)
+	return fs.write(instances)
+}
+
+// adds a handler for the connections of this instance only
+func (instance *AppInstance) RegisterHandler(handler PbxApiInterface) {
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+	instance.ApiHandler = append(instance.ApiHandler, handler)
+}
+
+func (instance *AppInstance) getApiHandler() []PbxApiInterface {
+	instance.mu.RLock()
+	defer instance.mu.RUnlock()
+	handler := make([]PbxApiInterface, len(instance.ApiHandler))
+	copy(handler, instance.ApiHandler)
+	return handler
+}
+
+func (instance *AppInstance) getPassword() string {
+	instance.mu.RLock()
+	defer instance.mu.RUnlock()
+	return instance.Password
+}
+
+// returns the database of the instance, nil if it has none
+func (instance *AppInstance) GetStore() *store.Store {
+	instance.mu.RLock()
+	defer instance.mu.RUnlock()
+	return instance.Store
+}
+
+func (instance *AppInstance) getFs() http.FileSystem {
+	instance.mu.RLock()
+	defer instance.mu.RUnlock()
+	return instance.Fs
+}
+
+func (instance *AppInstance) addConnection(connection *AppServicePbxConnection) {
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+	if !instance.deleted {
+		instance.connections = append(instance.connections, connection)
+	}
+}
+
+func (instance *AppInstance) isDeleted() bool {
+	instance.mu.RLock()
+	defer instance.mu.RUnlock()
+	return instance.deleted
+}
+
+// marks the instance as deleted and returns its connections
+func (instance *AppInstance) markDeleted() []*AppServicePbxConnection {
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+	instance.deleted = true
+	connections := make([]*AppServicePbxConnection, len(instance.connections))
+	copy(connections, instance.connections)
+	return connections
+}
+
+func (instance *AppInstance) deleteConnection(connection *AppServicePbxConnection) {
+	instance.mu.Lock()
+	defer instance.mu.Unlock()
+	for i, value := range instance.connections {
+		if value == connection {
+			instance.connections = append(instance.connections[:i], instance.connections[i+1:]...)
+			break
+		}
+	}
+}
+
+// returns a copy of the list of connections of the instance
+func (instance *AppInstance) GetConnections() []*AppServicePbxConnection {
+	instance.mu.RLock()
+	defer instance.mu.RUnlock()
+	connections := make([]*AppServicePbxConnection, len(instance.connections))
+	copy(connections, instance.connections)
+	return connections
+}
+
+// sends the message to all logged in clients of an app of this instance, app is the name with or without .htm
+func (instance *AppInstance) SendToApp(app string, message []byte) {
+	for _, connection := range instance.GetConnections() {
+		if login, _ := connection.GetAppLogin(); connection.Authenticated && AppName(login.App) == AppName(app) {
+			connection.WriteMessage(message)
+		}
+	}
+}
+
+// sends the message to all connections of the user of this instance with the sip/h323 name
+func (instance *AppInstance) SendToAllConnectionsOfSip(message []byte, sip string) {
+	for _, connection := range instance.GetConnections() {
+		if login, _ := connection.GetAppLogin(); login.Sip == sip {
+			connection.WriteMessage(message)
+		}
+	}
+}
+
+// returns the instance served on the path /<domain>/<name>/<instance>.
+// The instance of the Domain, Instance and Password of the AppService is returned too.
+func (s *AppService) GetInstance(domain, instance string) (*AppInstance, bool) {
+	s.instancesMutex.RLock()
+	found, ok := s.instances[instanceKey(domain, instance)]
+	s.instancesMutex.RUnlock()
+	if ok {
+		return found, true
+	}
+	if found := s.defaultInstance(); found.Domain != "" && strings.EqualFold(domain, found.Domain) && instance == found.Instance {
+		return found, true
+	}
+	return nil, false
+}
+
+// returns the instance of the Domain, Instance, Password and Store of the AppService.
+// It is created by Start or the first connection, later changes of the fields are not used.
+func (s *AppService) defaultInstance() *AppInstance {
+	s.defaultInstOnce.Do(func() {
+		s.defaultInst = &AppInstance{
+			Domain:   s.Domain,
+			Instance: s.Instance,
+			Password: s.Password,
+			Store:    s.Store,
+		}
+	})
+	return s.defaultInst
+}
+
+// returns the instances added with PutInstance or LoadInstances
+func (s *AppService) GetInstances() []*AppInstance {
+	s.instancesMutex.RLock()
+	defer s.instancesMutex.RUnlock()
+	instances := []*AppInstance{}
+	for _, instance := range s.instances {
+		instances = append(instances, instance)
+	}
+	return instances
+}
+
+/*
+adds or updates an instance and persists it in the InstanceStore, if set.
+
+If the instance has a Database, it is opened and the Migrations are applied.
+A new instance is passed to OnInstanceAdded, e.g. to register its handlers, before it is served.
+An existing instance is updated, so its handlers and connections are kept.
+The returned instance is the one that is served.
+*/
+func (s *AppService) PutInstance(ctx context.Context, instance *AppInstance) (*AppInstance, error) {
+	if err := instance.validate(); err != nil {
+		return nil, err
+	}
+	unlock := s.lockInstance(instance.Key())
+	defer unlock()
+	s.instancesMutex.RLock()
+	current, exists := s.instances[instance.Key()]
+	s.instancesMutex.RUnlock()
+
+	var db *store.Store
+	if exists && current.sameDatabase(instance) {
+		db = current.GetStore()
+	} else {
+		var err error
+		if db, err = s.openInstanceStore(ctx, instance); err != nil {
+			return nil, err
+		}
+	}
+	closeNewStore := func() {
+		if db != nil && (!exists || db != current.GetStore()) {
+			db.Close()
+		}
+	}
+
+	if s.InstanceStore != nil {
+		if err := s.InstanceStore.Put(ctx, instance.Key(), instance.config()); err != nil {
+			closeNewStore()
+			return nil, fmt.Errorf("saving instance '%s' failed: %v", instance.Key(), err)
+		}
+	}
+
+	if exists {
+		current.update(instance, db)
+		log.Infof("instance %s of %s updated", instance.Key(), s.Name)
+		return current, nil
+	}
+	instance.Store = db
+	if err := s.addInstance(instance); err != nil {
+		closeNewStore()
+		if s.InstanceStore != nil {
+			s.InstanceStore.Delete(ctx, instance.Key())
+		}
+		return nil, err
+	}
+	return instance, nil
+}
+
+// returns the persisted fields of the instance
+func (instance *AppInstance) config() *AppInstance {
+	instance.mu.RLock()
+	defer instance.mu.RUnlock()
+	return &AppInstance{
+		Domain:   instance.Domain,
+		Instance: instance.Instance,
+		Password: instance.Password,
+		Dialect:  instance.Dialect,
+		Database: instance.Database,
+	}
+}
+
+func (instance *AppInstance) sameDatabase(other *AppInstance) bool {
+	instance.mu.RLock()
+	defer instance.mu.RUnlock()
+	return instance.Dialect == other.Dialect && instance.Database == other.Database
+}
+
+// takes the settings of other and the database, the database used before is closed
+func (instance *AppInstance) update(other *AppInstance, db *store.Store) {
+	instance.mu.Lock()
+	old := instance.Store
+	instance.Password = other.Password
+	instance.Dialect = other.Dialect
+	instance.Database = other.Database
+	instance.Store = db
+	if other.Fs != nil {
+		instance.Fs = other.Fs
+	}
+	instance.mu.Unlock()
+	if old != nil && old != db {
+		old.Close()
+	}
+}
+
+type instanceLock struct {
+	mu    sync.Mutex
+	users int
+}
+
+// locks the changes of the instance with the key, so concurrent puts of the same instance do not both add it.
+// The returned function unlocks it.
+func (s *AppService) lockInstance(key string) func() {
+	s.instancesMutex.Lock()
+	if s.instanceLocks == nil {
+		s.instanceLocks = map[string]*instanceLock{}
+	}
+	lock, ok := s.instanceLocks[key]
+	if !ok {
+		lock = &instanceLock{}
+		s.instanceLocks[key] = lock
+	}
+	lock.users++
+	s.instancesMutex.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		s.instancesMutex.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(s.instanceLocks, key)
+		}
+		s.instancesMutex.Unlock()
+	}
+}
+
+// calls OnInstanceAdded and adds the instance to the served instances, fails if an instance with the key exists
+func (s *AppService) addInstance(instance *AppInstance) error {
+	if s.OnInstanceAdded != nil {
+		if err := s.OnInstanceAdded(instance); err != nil {
+			return fmt.Errorf("setting up instance '%s' failed: %v", instance.Key(), err)
+		}
+	}
+	s.instancesMutex.Lock()
+	if s.instances == nil {
+		s.instances = map[string]*AppInstance{}
+	}
+	if _, exists := s.instances[instance.Key()]; exists {
+		s.instancesMutex.Unlock()
+		if s.OnInstanceRemoved != nil {
+			s.OnInstanceRemoved(instance)
+		}
+		return fmt.Errorf("instance '%s' exists already", instance.Key())
+	}
+	s.instances[instance.Key()] = instance
+	s.instancesMutex.Unlock()
+	log.Infof("instance %s of %s added", instance.Key(), s.Name)
+	return nil
+}
+
+/*
+removes the instance and deletes it from the InstanceStore, if set.
+
+The connections of the instance get a close frame and DeleteInstance waits until their handlers are finished,
+then OnInstanceRemoved is called and the database is closed. When ctx is done before, the remaining
+connections are closed without waiting for the close frame of the PBX.
+*/
+func (s *AppService) DeleteInstance(ctx context.Context, domain, instance string) error {
+	key := instanceKey(domain, instance)
+	unlock := s.lockInstance(key)
+	defer unlock()
+	s.instancesMutex.Lock()
+	old, ok := s.instances[key]
+	delete(s.instances, key)
+	s.instancesMutex.Unlock()
+	if !ok {
+		return ErrInstanceNotFound
+	}
+	connections := old.markDeleted()
+	for _, connection := range connections {
+		connection.Close(websocket.CloseGoingAway, "instance deleted")
+	}
+	done := make(chan struct{})
+	go func() {
+		for _, connection := range connections {
+			connection.handlers.Wait()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		for _, connection := range connections {
+			connection.conn.Close()
+		}
+		<-done
+	}
+	if s.OnInstanceRemoved != nil {
+		s.OnInstanceRemoved(old)
+	}
+	if db := old.GetStore(); db != nil {
+		db.Close()
+	}
+	if s.InstanceStore != nil {
+		if err := s.InstanceStore.Delete(ctx, key); err != nil && !errors.Is(err, ErrInstanceNotFound) && !errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("deleting instance '%s' failed: %v", key, err)
+		}
+	}
+	log.Infof("instance %s of %s deleted", key, s.Name)
+	return nil
+}
+
+// adds the instances of the InstanceStore, e.g. after a restart of the service
+func (s *AppService) LoadInstances(ctx context.Context) error {
+	if s.InstanceStore == nil {
+		return fmt.Errorf("the service has no InstanceStore")
+	}
+	instances, err := s.InstanceStore.List(ctx)
+	if err != nil {
+		return fmt.Errorf("loading instances failed: %v", err)
+	}
+	for key, instance := range instances {
+		if err := instance.validate(); err != nil {
+			log.Errorf("skipping invalid instance '%s': %v", key, err)
+			continue
+		}
+		s.loadInstance(ctx, key, instance)
+	}
+	return nil
+}
+
+func (s *AppService) loadInstance(ctx context.Context, key string, instance *AppInstance) {
+	unlock := s.lockInstance(instance.Key())
+	defer unlock()
+	if _, exists := s.GetInstance(instance.Domain, instance.Instance); exists {
+		return
+	}
+	db, err := s.openInstanceStore(ctx, instance)
+	if err != nil {
+		// without its database the instance is not served, it is loaded again with the next PutInstance
+		log.Errorf("skipping instance '%s', opening its database failed: %v", key, err)
+		return
+	}
+	instance.Store = db
+	if err := s.addInstance(instance); err != nil {
+		log.Error(err)
+		if db != nil {
+			db.Close()
+		}
+	}
+}
+
+// opens the database of the instance and applies the Migrations, returns nil if it has no database
+func (s *AppService) openInstanceStore(ctx context.Context, instance *AppInstance) (*store.Store, error) {
+	if instance.Database == "" {
+		return nil, nil
+	}
+	dialect, err := store.GetDialect(instance.Dialect)
+	if err != nil {
+		return nil, err
+	}
+	db, err := store.Open(ctx, dialect, instance.Database, instance.Domain, instance.Instance)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Migrate(ctx, s.Migrations...); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,261 @@
+package service_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func managerRequest(t *testing.T, server *httptest.Server, method, path, password, body string) (int, string) {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	assert.NoError(t, err)
+	req.SetBasicAuth("manager", password)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestAppService_ManagerInstances(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "instances.json")
+	s, err := service.NewAppService("127.0.0.1", 0, 0, "", "", "example.com", "go", "instance", "pwd", nil)
+	assert.NoError(t, err)
+	s.InstanceStore = service.NewFileInstanceStore(file)
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	status, _ := managerRequest(t, server, http.MethodGet, "/manager/instances", "secret", "")
+	assert.Equal(t, http.StatusForbidden, status)
+
+	s.ManagerPassword = "secret"
+	status, _ = managerRequest(t, server, http.MethodGet, "/manager/instances", "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, _ = managerRequest(t, server, http.MethodPut, "/manager/instances/customer.com/app1", "secret", `{}`)
+	assert.Equal(t, http.StatusBadRequest, status, "a password is required")
+
+	status, body := managerRequest(t, server, http.MethodPut, "/manager/instances/customer.com/app1", "secret", `{"password":"pwd1"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"domain":"customer.com","instance":"app1"}`, body)
+
+	status, _ = managerRequest(t, server, http.MethodPost, "/manager/instances", "secret", `{"domain":"other.com","instance":"app2","password":"pwd2"}`)
+	assert.Equal(t, http.StatusOK, status)
+
+	status, body = managerRequest(t, server, http.MethodGet, "/manager/instances", "secret", "")
+	assert.Equal(t, http.StatusOK, status)
+	instances := []service.AppInstance{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &instances))
+	assert.Len(t, instances, 2)
+	assert.NotContains(t, body, "pwd")
+
+	// an update without password keeps it
+	status, _ = managerRequest(t, server, http.MethodPut, "/manager/instances/customer.com/app1", "secret", `{}`)
+	assert.Equal(t, http.StatusOK, status)
+	instance, ok := s.GetInstance("customer.com", "app1")
+	assert.True(t, ok)
+	assert.Equal(t, "pwd1", instance.Password)
+
+	// the instances are served
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http")
+	client, _, err := websocket.DefaultDialer.Dial(wsUrl+"/customer.com/go/app1", nil)
+	assert.NoError(t, err)
+	assert.True(t, login(t, client, newAppLogin(t, "pwd1", getChallenge(t, client))))
+	client.Close()
+
+	client, _, err = websocket.DefaultDialer.Dial(wsUrl+"/example.com/go/instance", nil)
+	assert.NoError(t, err)
+	assert.True(t, login(t, client, newAppLogin(t, "pwd", getChallenge(t, client))))
+	client.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsUrl+"/unknown.com/go/app1", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// the instances are persisted
+	restarted := &service.AppService{Name: "go", InstanceStore: service.NewFileInstanceStore(file)}
+	assert.NoError(t, restarted.LoadInstances(context.Background()))
+	assert.Len(t, restarted.GetInstances(), 2)
+	instance, ok = restarted.GetInstance("Customer.com", "app1")
+	assert.True(t, ok)
+	assert.Equal(t, "pwd1", instance.Password)
+
+	status, _ = managerRequest(t, server, http.MethodDelete, "/manager/instances/customer.com/app1", "secret", "")
+	assert.Equal(t, http.StatusNoContent, status)
+	status, _ = managerRequest(t, server, http.MethodDelete, "/manager/instances/customer.com/app1", "secret", "")
+	assert.Equal(t, http.StatusNotFound, status)
+	_, resp, err = websocket.DefaultDialer.Dial(wsUrl+"/customer.com/go/app1", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	restarted = &service.AppService{Name: "go", InstanceStore: service.NewFileInstanceStore(file)}
+	assert.NoError(t, restarted.LoadInstances(context.Background()))
+	assert.Len(t, restarted.GetInstances(), 1)
+}
+
+// counts the messages of an api received on the connections of an instance
+type countingApi struct {
+	messages chan *service.AppServicePbxConnection
+}
+
+func (api *countingApi) GetApiName() string                                       { return "com.example.count" }
+func (api *countingApi) OnConnect(connection *service.AppServicePbxConnection)    {}
+func (api *countingApi) OnDisconnect(connection *service.AppServicePbxConnection) {}
+func (api *countingApi) HandleMessage(connection *service.AppServicePbxConnection, msg *service.BaseMessage, message []byte) {
+	api.messages <- connection
+}
+
+func TestAppService_MultipleInstances(t *testing.T) {
+	s := &service.AppService{Name: "go"}
+	apis := map[string]*countingApi{}
+	s.OnInstanceAdded = func(instance *service.AppInstance) error {
+		api := &countingApi{messages: make(chan *service.AppServicePbxConnection, 1)}
+		apis[instance.Instance] = api
+		instance.RegisterHandler(api)
+		instance.Fs = http.FS(fstest.MapFS{"user.htm": {Data: []byte("user of " + instance.Instance)}})
+		return nil
+	}
+	removed := make(chan *service.AppInstance, 1)
+	s.OnInstanceRemoved = func(instance *service.AppInstance) { removed <- instance }
+
+	ctx := context.Background()
+	a, err := s.PutInstance(ctx, &service.AppInstance{Domain: "a.com", Instance: "app", Password: "pwd-a"})
+	assert.NoError(t, err)
+	b, err := s.PutInstance(ctx, &service.AppInstance{Domain: "b.com", Instance: "app", Password: "pwd-b"})
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	resp, err := http.Get(server.URL + "/b.com/go/app/user.htm")
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "user of app", string(body))
+
+	clientA, _, err := websocket.DefaultDialer.Dial(wsUrl+"/a.com/go/app", nil)
+	assert.NoError(t, err)
+	defer clientA.Close()
+	assert.False(t, login(t, clientA, newAppLogin(t, "pwd-b", getChallenge(t, clientA))), "the password of another instance")
+	assert.True(t, login(t, clientA, newAppLogin(t, "pwd-a", getChallenge(t, clientA))))
+
+	clientB, _, err := websocket.DefaultDialer.Dial(wsUrl+"/b.com/go/app", nil)
+	assert.NoError(t, err)
+	defer clientB.Close()
+	assert.True(t, login(t, clientB, newAppLogin(t, "pwd-b", getChallenge(t, clientB))))
+
+	assert.Len(t, a.GetConnections(), 1)
+	assert.Len(t, b.GetConnections(), 1)
+
+	// the handlers of an instance get the messages of its connections only
+	assert.NoError(t, clientB.WriteMessage(websocket.TextMessage, []byte(`{"api":"com.example.count","mt":"Count"}`)))
+	assert.Equal(t, b.GetConnections()[0], <-apis["app"].messages)
+	assert.Equal(t, b, b.GetConnections()[0].Instance)
+
+	// messages to an app of an instance
+	b.SendToApp("user", []byte(`{"mt":"Update"}`))
+	assert.Equal(t, "Update", readMessage(t, clientB)["mt"])
+
+	// an update keeps handlers and connections
+	updated, err := s.PutInstance(ctx, &service.AppInstance{Domain: "a.com", Instance: "app", Password: "pwd-new"})
+	assert.NoError(t, err)
+	assert.Same(t, a, updated)
+	assert.Len(t, a.GetConnections(), 1)
+	clientA2, _, err := websocket.DefaultDialer.Dial(wsUrl+"/a.com/go/app", nil)
+	assert.NoError(t, err)
+	defer clientA2.Close()
+	assert.True(t, login(t, clientA2, newAppLogin(t, "pwd-new", getChallenge(t, clientA2))))
+
+	// deleting an instance closes its connections and waits for their handlers
+	closed := make(chan error, 2)
+	for _, client := range []*websocket.Conn{clientA, clientA2} {
+		go func(client *websocket.Conn) {
+			client.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, _, err := client.ReadMessage()
+			closed <- err
+		}(client)
+	}
+	assert.NoError(t, s.DeleteInstance(ctx, "a.com", "app"))
+	assert.Empty(t, a.GetConnections(), "the handlers of the connections are finished")
+	assert.Same(t, a, <-removed)
+	for i := 0; i < 2; i++ {
+		err := <-closed
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "unexpected error: %v", err)
+	}
+	assert.Len(t, b.GetConnections(), 1)
+}
+
+func TestAppService_PutInstanceConcurrent(t *testing.T) {
+	s := &service.AppService{Name: "go"}
+	var added int32
+	s.OnInstanceAdded = func(instance *service.AppInstance) error {
+		atomic.AddInt32(&added, 1)
+		// widens the window between the check and the add
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan *service.AppInstance, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			instance, err := s.PutInstance(context.Background(), &service.AppInstance{Domain: "a.com", Instance: "app", Password: "pwd" + strconv.Itoa(i)})
+			assert.NoError(t, err)
+			results <- instance
+		}(i)
+	}
+	wg.Wait()
+	close(results)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&added))
+	assert.Len(t, s.GetInstances(), 1)
+	served, ok := s.GetInstance("a.com", "app")
+	assert.True(t, ok)
+	for instance := range results {
+		assert.Same(t, served, instance)
+	}
+}
+
+func TestAppService_DefaultInstance(t *testing.T) {
+	s := &service.AppService{Name: "go", Domain: "example.com", Instance: "instance", Password: "pwd"}
+	assert.NoError(t, s.Start())
+	defer s.Shutdown(context.Background())
+	// the fields are taken once by Start
+	s.Password = "changed"
+
+	var wg sync.WaitGroup
+	connections := make([]*service.AppServicePbxConnection, 10)
+	for i := range connections {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			connections[i] = service.NewAppServicePbxConnection(s, nil)
+		}(i)
+	}
+	wg.Wait()
+	instance, ok := s.GetInstance("Example.com", "instance")
+	assert.True(t, ok)
+	assert.Equal(t, "pwd", instance.Password)
+	for _, connection := range connections {
+		assert.Same(t, instance, connection.Instance)
+	}
+}
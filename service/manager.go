@@ -0,0 +1,136 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ricoschulte/go-myapps/service/store"
+	log "github.com/sirupsen/logrus"
+)
+
+const managerInstancesPath = "/manager/instances"
+
+/*
+the provisioning api for the plugin of the App Platform Manager, served on /manager/instances.
+
+	GET    /manager/instances                       lists the instances, without passwords
+	POST   /manager/instances                       creates or updates the instance in the body
+	GET    /manager/instances/<domain>/<instance>   returns the instance, without passwords
+	PUT    /manager/instances/<domain>/<instance>   creates or updates the instance
+	DELETE /manager/instances/<domain>/<instance>   deletes the instance
+
+The requests are authenticated with basic auth and the ManagerPassword, the api is disabled without one.
+The responses contain the database with the credentials redacted, see store.RedactDSN. An update with the
+redacted database of the instance keeps its database.
+*/
+func (s *AppService) HandleManagerInstances(w http.ResponseWriter, r *http.Request) {
+	if s.ManagerPassword == "" {
+		http.Error(w, "manager api disabled", http.StatusForbidden)
+		return
+	}
+	_, password, ok := r.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(s.ManagerPassword)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="manager"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, managerInstancesPath), "/")
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			instances := []*AppInstance{}
+			for _, instance := range s.GetInstances() {
+				instances = append(instances, withoutPassword(instance))
+			}
+			writeJson(w, http.StatusOK, instances)
+		case http.MethodPost:
+			s.putManagerInstance(w, r, nil)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	domain, name := parts[0], parts[1]
+	switch r.Method {
+	case http.MethodGet:
+		s.instancesMutex.RLock()
+		instance, ok := s.instances[instanceKey(domain, name)]
+		s.instancesMutex.RUnlock()
+		if !ok {
+			http.Error(w, ErrInstanceNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		writeJson(w, http.StatusOK, withoutPassword(instance))
+	case http.MethodPut:
+		s.putManagerInstance(w, r, &AppInstance{Domain: domain, Instance: name})
+	case http.MethodDelete:
+		if err := s.DeleteInstance(r.Context(), domain, name); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrInstanceNotFound) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// creates or updates the instance of the body, path holds domain and instance of a PUT
+func (s *AppService) putManagerInstance(w http.ResponseWriter, r *http.Request, path *AppInstance) {
+	instance := &AppInstance{}
+	if err := json.NewDecoder(r.Body).Decode(instance); err != nil {
+		http.Error(w, "invalid instance: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if path != nil {
+		instance.Domain = path.Domain
+		instance.Instance = path.Instance
+	}
+	s.instancesMutex.RLock()
+	if current, ok := s.instances[instance.Key()]; ok {
+		// an update without password keeps the current one
+		if instance.Password == "" {
+			instance.Password = current.getPassword()
+		}
+		// the database was returned redacted
+		config := current.config()
+		if instance.Database != "" && instance.Database == store.RedactDSN(config.Database) {
+			instance.Database = config.Database
+		}
+	}
+	s.instancesMutex.RUnlock()
+	created, err := s.PutInstance(r.Context(), instance)
+	if err != nil {
+		log.Warnf("manager: putting instance %s failed: %v", instance.Key(), err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJson(w, http.StatusOK, withoutPassword(created))
+}
+
+// returns the config of the instance for responses, without the password and the credentials of the database
+func withoutPassword(instance *AppInstance) *AppInstance {
+	config := instance.config()
+	config.Password = ""
+	config.Database = store.RedactDSN(config.Database)
+	return config
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,71 @@
+//go:build cgo
+
+package service_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ricoschulte/go-myapps/service"
+	_ "github.com/ricoschulte/go-myapps/service/store/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppService_ManagerInstancesDatabase(t *testing.T) {
+	s, err := service.NewAppService("127.0.0.1", 0, 0, "", "", "example.com", "go", "instance", "pwd", nil)
+	assert.NoError(t, err)
+	s.ManagerPassword = "secret"
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+	defer s.DeleteInstance(context.Background(), "customer.com", "app1")
+
+	dsn := "file:" + filepath.ToSlash(filepath.Join(t.TempDir(), "app.db")) + "?_auth_user=app&_auth_pass=s3cret"
+	status, body := managerRequest(t, server, http.MethodPut, "/manager/instances/customer.com/app1", "secret", `{"password":"pwd1","dialect":"sqlite","database":"`+dsn+`"}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.NotContains(t, body, "s3cret")
+	assert.Contains(t, body, "app.db")
+
+	status, body = managerRequest(t, server, http.MethodGet, "/manager/instances", "secret", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.NotContains(t, body, "s3cret")
+
+	status, body = managerRequest(t, server, http.MethodGet, "/manager/instances/customer.com/app1", "secret", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.NotContains(t, body, "s3cret")
+
+	// putting back the returned instance keeps the database
+	status, _ = managerRequest(t, server, http.MethodPut, "/manager/instances/customer.com/app1", "secret", strings.Replace(body, `"domain"`, `"password":"pwd2","domain"`, 1))
+	assert.Equal(t, http.StatusOK, status)
+	instance, ok := s.GetInstance("customer.com", "app1")
+	if assert.True(t, ok) {
+		assert.Equal(t, dsn, instance.Database)
+		assert.Equal(t, "pwd2", instance.Password)
+	}
+}
+
+func TestAppService_LoadInstancesDatabaseFailed(t *testing.T) {
+	dir := t.TempDir()
+	// a file where the directory of the database is expected
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "file"), nil, 0o600))
+	instances := service.NewFileInstanceStore(filepath.Join(dir, "instances.json"))
+	ctx := context.Background()
+	assert.NoError(t, instances.Put(ctx, "a.com/app", &service.AppInstance{Domain: "a.com", Instance: "app", Password: "pwd"}))
+	assert.NoError(t, instances.Put(ctx, "b.com/app", &service.AppInstance{Domain: "b.com", Instance: "app", Password: "pwd", Dialect: "sqlite", Database: "file:" + filepath.ToSlash(filepath.Join(dir, "file", "app.db"))}))
+
+	s := &service.AppService{Name: "go", InstanceStore: instances}
+	assert.NoError(t, s.LoadInstances(ctx))
+	_, ok := s.GetInstance("a.com", "app")
+	assert.True(t, ok)
+	_, ok = s.GetInstance("b.com", "app")
+	assert.False(t, ok, "an instance without its database is not served")
+
+	// the instance is kept in the InstanceStore
+	stored, err := instances.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, stored, 2)
+}
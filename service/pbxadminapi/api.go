@@ -2,15 +2,14 @@ package pbxadminapi
 
 import (
 	"encoding/json"
-	"sync"
 
 	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/events"
 	log "github.com/sirupsen/logrus"
 )
 
 type PbxAdminApi struct {
-	mu        sync.Mutex
-	receivers []chan PbxAdminApiEvent
+	events.Publisher[PbxAdminApiEvent]
 }
 
 func NewPbxAdminApi() *PbxAdminApi {
@@ -22,11 +21,11 @@ func (api *PbxAdminApi) GetApiName() string {
 }
 
 func (api *PbxAdminApi) OnConnect(connection *service.AppServicePbxConnection) {
-	api.sendEvent(PbxAdminApiEvent{Type: PbxAdminApiEventConnect, Connection: connection})
+	api.Publish(PbxAdminApiEvent{Type: PbxAdminApiEventConnect, Connection: connection})
 }
 
 func (api *PbxAdminApi) OnDisconnect(connection *service.AppServicePbxConnection) {
-	api.sendEvent(PbxAdminApiEvent{Type: PbxAdminApiEventDisconnect, Connection: connection})
+	api.Publish(PbxAdminApiEvent{Type: PbxAdminApiEventDisconnect, Connection: connection})
 }
 
 func (api *PbxAdminApi) HandleMessage(connection *service.AppServicePbxConnection, msg *service.BaseMessage, message []byte) {
@@ -36,47 +35,14 @@ func (api *PbxAdminApi) HandleMessage(connection *service.AppServicePbxConnectio
 		if err := json.Unmarshal(message, &msg); err != nil {
 			log.Errorf("PbxAdminApi: error unmarshalling message: %v", err)
 		}
-		api.sendEvent(PbxAdminApiEvent{Type: PbxAdminApiGetPbxLicensesResult, GetPbxLicensesResult: &msg, Connection: connection})
+		api.Publish(PbxAdminApiEvent{Type: PbxAdminApiGetPbxLicensesResult, GetPbxLicensesResult: &msg, Connection: connection})
 	case "GetAppLicsResult":
 		msg := GetAppLicensesResult{}
 		if err := json.Unmarshal(message, &msg); err != nil {
 			log.Errorf("PbxAdminApi: error unmarshalling message: %v", err)
 		}
-		api.sendEvent(PbxAdminApiEvent{Type: PbxAdminApiGetAppLicsResult, GetAppLicensesResult: &msg, Connection: connection})
+		api.Publish(PbxAdminApiEvent{Type: PbxAdminApiGetAppLicsResult, GetAppLicensesResult: &msg, Connection: connection})
 	default:
-		log.Warn("unknown message received: %s", msg.Mt)
-	}
-}
-
-func (api *PbxAdminApi) AddReceiver() chan PbxAdminApiEvent {
-	api.mu.Lock()
-	defer api.mu.Unlock()
-	ch := make(chan PbxAdminApiEvent)
-	api.receivers = append(api.receivers, ch)
-	return ch
-}
-
-func (api *PbxAdminApi) RemoveReceiver(ch chan PbxAdminApiEvent) {
-	api.mu.Lock()
-	defer api.mu.Unlock()
-
-	for i, c := range api.receivers {
-		if c == ch {
-			// Remove the channel from the slice
-			api.receivers = append(api.receivers[:i], api.receivers[i+1:]...)
-			// Close the channel to signal the receiver that it should stop listening
-			close(ch)
-			return
-		}
-	}
-}
-
-func (api *PbxAdminApi) sendEvent(event PbxAdminApiEvent) {
-	api.mu.Lock()
-	defer api.mu.Unlock()
-
-	// Send the event to all registered receivers
-	for _, ch := range api.receivers {
-		ch <- event
+		log.Warnf("unknown message received: %s", msg.Mt)
 	}
 }
@@ -0,0 +1,17 @@
+package pbxadminapi
+
+import (
+	"context"
+
+	"github.com/ricoschulte/go-myapps/service"
+)
+
+// returns the app licenses of the PBX
+func CallGetAppLics(ctx context.Context, connection *service.AppServicePbxConnection) (*GetAppLicensesResult, error) {
+	return service.CallFor[GetAppLicensesResult](ctx, connection, "PbxAdminApi", NewGetAppLics(""))
+}
+
+// returns the licenses of the PBX
+func CallGetPbxLicenses(ctx context.Context, connection *service.AppServicePbxConnection) (*GetPbxLicensesResult, error) {
+	return service.CallFor[GetPbxLicensesResult](ctx, connection, "PbxAdminApi", NewGetPbxLicenses(""))
+}
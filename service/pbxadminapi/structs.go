@@ -103,3 +103,11 @@ const PbxAdminApiEventDisconnect = -20
 const PbxAdminApiEventConnect = -10
 const PbxAdminApiGetAppLicsResult = 10
 const PbxAdminApiGetPbxLicensesResult = 20
+
+func (event PbxAdminApiEvent) GetType() int {
+	return event.Type
+}
+
+func (event PbxAdminApiEvent) GetConnection() *service.AppServicePbxConnection {
+	return event.Connection
+}
@@ -0,0 +1,42 @@
+package pbxapi
+
+import (
+	"context"
+
+	"github.com/ricoschulte/go-myapps/service"
+)
+
+// subscribes the presence of the user with the sip name and waits for the result
+func CallSubscribePresenceWithSip(ctx context.Context, connection *service.AppServicePbxConnection, sip string) (*SubscribePresenceResult, error) {
+	return service.CallFor[SubscribePresenceResult](ctx, connection, "PbxApi", NewSubscribePresenceWithSip(sip, ""))
+}
+
+// subscribes the presence of the user with the number and waits for the result
+func CallSubscribePresenceWithNum(ctx context.Context, connection *service.AppServicePbxConnection, num string) (*SubscribePresenceResult, error) {
+	return service.CallFor[SubscribePresenceResult](ctx, connection, "PbxApi", NewSubscribePresenceWithNum(num, ""))
+}
+
+// sets the presence of a contact of the user with the sip name and waits for the result
+func CallSetPresenceWithSip(ctx context.Context, connection *service.AppServicePbxConnection, sip, contact, activity, note string) (*SetPresenceResult, error) {
+	return service.CallFor[SetPresenceResult](ctx, connection, "PbxApi", NewSetPresenceWithSip(sip, contact, activity, note, ""))
+}
+
+// sets the presence of a contact of the user with the guid and waits for the result
+func CallSetPresenceWithGuid(ctx context.Context, connection *service.AppServicePbxConnection, guid, contact, activity, note string) (*SetPresenceResult, error) {
+	return service.CallFor[SetPresenceResult](ctx, connection, "PbxApi", NewSetPresenceWithGuid(guid, contact, activity, note, ""))
+}
+
+// returns the information about the node of the PBX
+func CallGetNodeInfo(ctx context.Context, connection *service.AppServicePbxConnection) (*GetNodeInfoResult, error) {
+	return service.CallFor[GetNodeInfoResult](ctx, connection, "PbxApi", NewGetNodeInfo(""))
+}
+
+// adds a call to the PBX and returns the result with its id
+func CallAddAlienCall(ctx context.Context, connection *service.AppServicePbxConnection) (*AddAlienCallResult, error) {
+	return service.CallFor[AddAlienCallResult](ctx, connection, "PbxApi", NewAddAlienCall(""))
+}
+
+// deletes a call added with AddAlienCall
+func CallDelAlienCall(ctx context.Context, connection *service.AppServicePbxConnection, id int) (*DelAlienCallResult, error) {
+	return service.CallFor[DelAlienCallResult](ctx, connection, "PbxApi", NewDelAlienCall(id, ""))
+}
@@ -211,3 +211,11 @@ const PbxApiEventSetPresenceResult = 40
 const PbxApiEventGetNodeInfoResult = 50
 const PbxApiEventAddAlienCallResult = 60
 const PbxApiEventDelAlienCallResult = 65
+
+func (event PbxApiEvent) GetType() int {
+	return event.Type
+}
+
+func (event PbxApiEvent) GetConnection() *service.AppServicePbxConnection {
+	return event.Connection
+}
@@ -1,11 +1,44 @@
 package pbximpersonation
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
 	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/events"
+	"github.com/ricoschulte/go-myapps/service/pbxapi"
+	"github.com/ricoschulte/go-myapps/service/pbxmessages"
+	"github.com/ricoschulte/go-myapps/service/rcc"
 	log "github.com/sirupsen/logrus"
 )
 
+// a session opened on behalf of a user, the messages sent with it act as the user
+type Session struct {
+	Id         string
+	Sip        string
+	Guid       string
+	Opened     time.Time
+	Connection *service.AppServicePbxConnection
+
+	api *PbxImpersonation
+}
+
 type PbxImpersonation struct {
+	events.Publisher[PbxImpersonationEvent]
+
+	mu       sync.Mutex
+	sessions map[*service.AppServicePbxConnection]map[string]*Session
+}
+
+func NewPbxImpersonation() *PbxImpersonation {
+	return &PbxImpersonation{
+		sessions: map[*service.AppServicePbxConnection]map[string]*Session{},
+	}
 }
 
 func (api *PbxImpersonation) GetApiName() string {
@@ -13,13 +46,225 @@ func (api *PbxImpersonation) GetApiName() string {
 }
 
 func (api *PbxImpersonation) OnConnect(connection *service.AppServicePbxConnection) {
-	log.WithField("api", api.GetApiName()).Warn("OnConnect not implemented ")
+	api.Publish(PbxImpersonationEvent{Type: PbxImpersonationEventConnect, Connection: connection})
 }
 
+// the sessions of the connection are closed by the PBX with the connection
 func (api *PbxImpersonation) OnDisconnect(connection *service.AppServicePbxConnection) {
-	log.WithField("api", api.GetApiName()).Warn("OnDisconnect not implemented ")
+	api.mu.Lock()
+	sessions := api.sessions[connection]
+	delete(api.sessions, connection)
+	api.mu.Unlock()
+	for _, session := range sortSessions(sessions) {
+		api.Publish(PbxImpersonationEvent{Type: PbxImpersonationEventClosed, Session: session, Connection: connection})
+	}
+	api.Publish(PbxImpersonationEvent{Type: PbxImpersonationEventDisconnect, Connection: connection})
 }
 
 func (api *PbxImpersonation) HandleMessage(connection *service.AppServicePbxConnection, msg *service.BaseMessage, message []byte) {
-	log.WithField("api", api.GetApiName()).Warn("HandleMessage not implemented ")
+	switch msg.Mt {
+	case "Closed":
+		msg := Closed{}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Errorf("PbxImpersonation: error unmarshalling message: %v", err)
+			return
+		}
+		if session := api.removeSession(connection, msg.Session); session != nil {
+			api.Publish(PbxImpersonationEvent{Type: PbxImpersonationEventClosed, Session: session, Connection: connection})
+		}
+	case "Received":
+		msg := Received{}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Errorf("PbxImpersonation: error unmarshalling message: %v", err)
+			return
+		}
+		session, ok := api.GetSession(connection, msg.Session)
+		if !ok {
+			log.Warnf("PbxImpersonation: message for unknown session %s", msg.Session)
+			return
+		}
+		api.Publish(PbxImpersonationEvent{Type: PbxImpersonationEventReceived, Session: session, Received: &msg, Connection: connection})
+	default:
+		// the results of Open, Close and Send do not get here
+		log.Warnf("unknown message received: %s", msg.Mt)
+	}
+}
+
+// opens a session on behalf of the user with the sip name
+func (api *PbxImpersonation) Open(ctx context.Context, connection *service.AppServicePbxConnection, sip string) (*Session, error) {
+	return api.open(ctx, connection, NewOpenWithSip(sip, ""))
+}
+
+// opens a session on behalf of the user with the guid
+func (api *PbxImpersonation) OpenWithGuid(ctx context.Context, connection *service.AppServicePbxConnection, guid string) (*Session, error) {
+	return api.open(ctx, connection, NewOpenWithGuid(guid, ""))
+}
+
+func (api *PbxImpersonation) open(ctx context.Context, connection *service.AppServicePbxConnection, msg *Open) (*Session, error) {
+	result, err := service.CallFor[OpenResult](ctx, connection, "PbxImpersonation", msg)
+	if err != nil {
+		return nil, err
+	}
+	if result.Session == "" {
+		return nil, fmt.Errorf("PbxImpersonation: OpenResult without session")
+	}
+	session := &Session{
+		Id:         result.Session,
+		Sip:        result.Sip,
+		Guid:       result.Guid,
+		Opened:     time.Now(),
+		Connection: connection,
+		api:        api,
+	}
+	if session.Sip == "" {
+		session.Sip = msg.Sip
+	}
+	if session.Guid == "" {
+		session.Guid = msg.Guid
+	}
+
+	api.mu.Lock()
+	if api.sessions == nil {
+		api.sessions = map[*service.AppServicePbxConnection]map[string]*Session{}
+	}
+	sessions, ok := api.sessions[connection]
+	if !ok {
+		sessions = map[string]*Session{}
+		api.sessions[connection] = sessions
+	}
+	sessions[session.Id] = session
+	api.mu.Unlock()
+
+	api.Publish(PbxImpersonationEvent{Type: PbxImpersonationEventOpened, Session: session, Connection: connection})
+	return session, nil
+}
+
+// removes the session and returns it, nil if it is unknown
+func (api *PbxImpersonation) removeSession(connection *service.AppServicePbxConnection, id string) *Session {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	session, ok := api.sessions[connection][id]
+	if !ok {
+		return nil
+	}
+	delete(api.sessions[connection], id)
+	return session
+}
+
+// returns the open session with the id
+func (api *PbxImpersonation) GetSession(connection *service.AppServicePbxConnection, id string) (*Session, bool) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	session, ok := api.sessions[connection][id]
+	return session, ok
+}
+
+// returns the open sessions of the connection, sorted by the time they were opened
+func (api *PbxImpersonation) GetSessions(connection *service.AppServicePbxConnection) []*Session {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return sortSessions(api.sessions[connection])
+}
+
+func sortSessions(sessions map[string]*Session) []*Session {
+	list := make([]*Session, 0, len(sessions))
+	for _, session := range sessions {
+		list = append(list, session)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Opened.Equal(list[j].Opened) {
+			return list[i].Id < list[j].Id
+		}
+		return list[i].Opened.Before(list[j].Opened)
+	})
+	return list
+}
+
+// closes all sessions of the connection and returns the first error
+func (api *PbxImpersonation) CloseAll(ctx context.Context, connection *service.AppServicePbxConnection) error {
+	var first error
+	for _, session := range api.GetSessions(connection) {
+		if err := session.Close(ctx); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+/*
+closes the session.
+
+The session is removed when the PBX answers, even with an error, as it can not be used anymore then,
+or when the connection is closed. After a timeout or a failed write the session is kept, so Close can be retried.
+*/
+func (session *Session) Close(ctx context.Context) error {
+	result, err := service.CallFor[CloseResult](ctx, session.Connection, "PbxImpersonation", NewClose(session.Id, ""))
+	if result == nil && !errors.Is(err, service.ErrConnectionClosed) {
+		return err
+	}
+	if removed := session.api.removeSession(session.Connection, session.Id); removed != nil {
+		session.api.Publish(PbxImpersonationEvent{Type: PbxImpersonationEventClosed, Session: removed, Connection: session.Connection})
+	}
+	return err
+}
+
+/*
+sends the message of the api as the user of the session and returns the result of the api.
+
+The src of msg is not used, the result is correlated by the Send message. If the result has
+an error code, it is returned together with a *service.CallError of the api.
+*/
+func (session *Session) Call(ctx context.Context, api string, msg interface{}) (json.RawMessage, error) {
+	request, err := service.CallRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+	request["api"] = api
+	delete(request, "src")
+
+	result, err := service.CallFor[SendResult](ctx, session.Connection, "PbxImpersonation", NewSend(session.Id, request, ""))
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Msg) == 0 {
+		return nil, fmt.Errorf("PbxImpersonation: SendResult without the result of %s", api)
+	}
+	return result.Msg, service.ResultError(api, result.Msg)
+}
+
+// sends msg with the Call of the session and unmarshals the result into a T
+func SessionCallFor[T any](ctx context.Context, session *Session, api string, msg interface{}) (*T, error) {
+	message, err := session.Call(ctx, api, msg)
+	return service.ResultFor[T](api, message, err)
+}
+
+// sets the presence of the contact of the user, like "tel:" or "im:"
+func (session *Session) SetPresence(ctx context.Context, contact, activity, note string) (*pbxapi.SetPresenceResult, error) {
+	return SessionCallFor[pbxapi.SetPresenceResult](ctx, session, "PbxApi", pbxapi.NewSetPresenceWithSip(session.Sip, contact, activity, note, ""))
+}
+
+// sends a message from the user to the sip name
+func (session *Session) SendMessage(ctx context.Context, to, text string) (*pbxmessages.SendMessageResult, error) {
+	return SessionCallFor[pbxmessages.SendMessageResult](ctx, session, "PbxMessages", pbxmessages.NewSendMessageToSip(session.Sip, to, text, ""))
+}
+
+// starts the control of the calls of the user with the cn on the device hw, with RCC as the user of the session.
+// The id of the user in the result is used for UserCall and UserEnd.
+func (session *Session) UserInitialize(ctx context.Context, cn, hw string) (*rcc.UserInitializeResult, error) {
+	return SessionCallFor[rcc.UserInitializeResult](ctx, session, "RCC", rcc.NewUserInitialize(cn, hw, false, false, ""))
+}
+
+// dials the number, or the name if the number is empty, for the user of UserInitialize and returns the id of the call
+func (session *Session) UserCall(ctx context.Context, user int, e164, h323 string) (*rcc.UserCallResult, error) {
+	return SessionCallFor[rcc.UserCallResult](ctx, session, "RCC", rcc.NewUserCall(user, e164, h323, ""))
+}
+
+// disconnects the call
+func (session *Session) UserClear(ctx context.Context, call int) (*rcc.UserResult, error) {
+	return SessionCallFor[rcc.UserResult](ctx, session, "RCC", rcc.NewUserClear(call, ""))
+}
+
+// ends the control of the calls of the user of UserInitialize
+func (session *Session) UserEnd(ctx context.Context, user int) (*rcc.UserResult, error) {
+	return SessionCallFor[rcc.UserResult](ctx, session, "RCC", rcc.NewUserEnd(user, ""))
 }
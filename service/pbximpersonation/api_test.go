@@ -0,0 +1,205 @@
+package pbximpersonation_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/events"
+	"github.com/ricoschulte/go-myapps/service/pbximpersonation"
+	"github.com/ricoschulte/go-myapps/service/servicetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// a PBX that opens sessions for users and answers the messages sent with them
+func newPbx(t *testing.T, sent chan map[string]interface{}) *service.AppServicePbxConnection {
+	sessions := 0
+	users := map[string]string{} // the sip of the sessions
+	return servicetest.NewConnection(t, func(peer *websocket.Conn, message map[string]interface{}) {
+		result := map[string]interface{}{"api": "PbxImpersonation", "mt": message["mt"].(string) + "Result", "src": message["src"]}
+		switch message["mt"] {
+		case "Open":
+			if message["sip"] == "unknown" {
+				result["error"] = 1
+				result["errorText"] = "no such user"
+				break
+			}
+			sessions++
+			result["session"] = "s" + string(rune('0'+sessions))
+			result["sip"] = message["sip"]
+			users[result["session"].(string)] = message["sip"].(string)
+		case "Close":
+			switch users[message["session"].(string)] {
+			case "silent":
+				return
+			case "locked":
+				result["error"] = 3
+				result["errorText"] = "session locked"
+			}
+		case "Send":
+			inner := message["msg"].(map[string]interface{})
+			sent <- inner
+			answer := map[string]interface{}{"api": inner["api"], "mt": inner["mt"].(string) + "Result"}
+			if inner["activity"] == "invalid" {
+				answer["error"] = 2
+				answer["errorText"] = "invalid activity"
+			}
+			switch inner["mt"] {
+			case "SendMessage":
+				answer["id"] = "m1"
+			case "UserInitialize":
+				answer["user"] = 7
+			case "UserCall":
+				answer["call"] = 3
+			}
+			result["session"] = message["session"]
+			result["msg"] = answer
+		}
+		peer.WriteJSON(result)
+	})
+}
+
+func TestPbxImpersonation_Sessions(t *testing.T) {
+	sent := make(chan map[string]interface{}, 10)
+	connection := newPbx(t, sent)
+	connection.Authenticated = true
+	go connection.Loop()
+
+	api := pbximpersonation.NewPbxImpersonation()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sessionEvents := api.Subscribe(ctx, events.ForTypes[pbximpersonation.PbxImpersonationEvent](
+		pbximpersonation.PbxImpersonationEventOpened, pbximpersonation.PbxImpersonationEventClosed,
+	))
+
+	_, err := api.Open(ctx, connection, "unknown")
+	callErr := &service.CallError{}
+	assert.ErrorAs(t, err, &callErr)
+	assert.Empty(t, api.GetSessions(connection))
+
+	alice, err := api.Open(ctx, connection, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, "s1", alice.Id)
+	assert.Equal(t, "alice", alice.Sip)
+	event := <-sessionEvents.C
+	assert.Equal(t, pbximpersonation.PbxImpersonationEventOpened, event.Type)
+	assert.Equal(t, alice, event.Session)
+
+	result, err := alice.SetPresence(ctx, "tel:", "away", "in a meeting")
+	assert.NoError(t, err)
+	assert.Equal(t, "SetPresenceResult", result.Mt)
+	inner := <-sent
+	assert.Equal(t, "PbxApi", inner["api"])
+	assert.Equal(t, "alice", inner["sip"])
+	assert.Equal(t, "away", inner["activity"])
+	assert.Nil(t, inner["src"])
+
+	_, err = alice.SetPresence(ctx, "tel:", "invalid", "")
+	assert.ErrorAs(t, err, &callErr)
+	assert.Equal(t, "PbxApi", callErr.Api)
+	assert.Equal(t, 2, callErr.Code)
+	<-sent
+
+	message, err := alice.SendMessage(ctx, "bob", "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "m1", message.Id)
+	inner = <-sent
+	assert.Equal(t, "PbxMessages", inner["api"])
+	assert.Equal(t, "hello", inner["text"])
+
+	_, err = alice.Call(ctx, "RCC", map[string]interface{}{"user": 1})
+	assert.Error(t, err)
+
+	user, err := alice.UserInitialize(ctx, "Alice", "phone-1")
+	assert.NoError(t, err)
+	assert.Equal(t, 7, user.User)
+	inner = <-sent
+	assert.Equal(t, "RCC", inner["api"])
+	assert.Equal(t, "Alice", inner["cn"])
+	call, err := alice.UserCall(ctx, user.User, "", "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, call.Call)
+	assert.Equal(t, "bob", (<-sent)["h323"])
+	_, err = alice.UserClear(ctx, call.Call)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(3), (<-sent)["call"])
+	_, err = alice.UserEnd(ctx, user.User)
+	assert.NoError(t, err)
+	assert.Equal(t, "UserEnd", (<-sent)["mt"])
+
+	bob, err := api.Open(ctx, connection, "bob")
+	assert.NoError(t, err)
+	<-sessionEvents.C
+	assert.Equal(t, []*pbximpersonation.Session{alice, bob}, api.GetSessions(connection))
+
+	// closed by the PBX
+	api.HandleMessage(connection, &service.BaseMessage{Api: "PbxImpersonation", Mt: "Closed"}, []byte(`{"api":"PbxImpersonation","mt":"Closed","session":"s2"}`))
+	event = <-sessionEvents.C
+	assert.Equal(t, pbximpersonation.PbxImpersonationEventClosed, event.Type)
+	assert.Equal(t, bob, event.Session)
+	_, ok := api.GetSession(connection, "s2")
+	assert.False(t, ok)
+
+	assert.NoError(t, api.CloseAll(ctx, connection))
+	event = <-sessionEvents.C
+	assert.Equal(t, alice, event.Session)
+	assert.Empty(t, api.GetSessions(connection))
+}
+
+func TestPbxImpersonation_Disconnect(t *testing.T) {
+	connection := newPbx(t, make(chan map[string]interface{}, 10))
+	connection.Authenticated = true
+	go connection.Loop()
+
+	api := pbximpersonation.NewPbxImpersonation()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	closed := api.Subscribe(ctx, events.ForTypes[pbximpersonation.PbxImpersonationEvent](pbximpersonation.PbxImpersonationEventClosed))
+	received := api.Subscribe(ctx, events.ForTypes[pbximpersonation.PbxImpersonationEvent](pbximpersonation.PbxImpersonationEventReceived))
+
+	session, err := api.Open(ctx, connection, "alice")
+	assert.NoError(t, err)
+
+	api.HandleMessage(connection, &service.BaseMessage{Api: "PbxImpersonation", Mt: "Received"},
+		[]byte(`{"api":"PbxImpersonation","mt":"Received","session":"s1","msg":{"api":"PbxApi","mt":"PresenceUpdate"}}`))
+	event := <-received.C
+	assert.Equal(t, session, event.Session)
+	assert.JSONEq(t, `{"api":"PbxApi","mt":"PresenceUpdate"}`, string(event.Received.Msg))
+
+	api.OnDisconnect(connection)
+	event = <-closed.C
+	assert.Equal(t, session, event.Session)
+	assert.Empty(t, api.GetSessions(connection))
+}
+
+func TestPbxImpersonation_CloseFails(t *testing.T) {
+	connection := newPbx(t, make(chan map[string]interface{}, 10))
+	connection.Authenticated = true
+	go connection.Loop()
+
+	api := pbximpersonation.NewPbxImpersonation()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	closed := api.Subscribe(ctx, events.ForTypes[pbximpersonation.PbxImpersonationEvent](pbximpersonation.PbxImpersonationEventClosed))
+
+	// a session is kept if the PBX does not answer
+	silent, err := api.Open(ctx, connection, "silent")
+	assert.NoError(t, err)
+	timeout, cancelTimeout := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelTimeout()
+	assert.ErrorIs(t, silent.Close(timeout), context.DeadlineExceeded)
+	_, ok := api.GetSession(connection, silent.Id)
+	assert.True(t, ok)
+
+	// a session is removed if the PBX answers with an error
+	locked, err := api.Open(ctx, connection, "locked")
+	assert.NoError(t, err)
+	callErr := &service.CallError{}
+	assert.ErrorAs(t, locked.Close(ctx), &callErr)
+	assert.Equal(t, 3, callErr.Code)
+	event := <-closed.C
+	assert.Equal(t, locked, event.Session)
+	assert.Equal(t, []*pbximpersonation.Session{silent}, api.GetSessions(connection))
+}
@@ -0,0 +1,103 @@
+package pbximpersonation
+
+import (
+	"encoding/json"
+
+	"github.com/ricoschulte/go-myapps/service"
+)
+
+func newBaseMessage(mt, src string) service.BaseMessage {
+	return service.BaseMessage{
+		Api: "PbxImpersonation",
+		Mt:  mt,
+		Src: src,
+	}
+}
+
+// opens a session on behalf of the user with the sip name or guid
+type Open struct {
+	service.BaseMessage
+	Sip  string `json:"sip,omitempty"`
+	Guid string `json:"guid,omitempty"`
+}
+
+func NewOpenWithSip(sip, src string) *Open {
+	return &Open{BaseMessage: newBaseMessage("Open", src), Sip: sip}
+}
+
+func NewOpenWithGuid(guid, src string) *Open {
+	return &Open{BaseMessage: newBaseMessage("Open", src), Guid: guid}
+}
+
+type OpenResult struct {
+	service.BaseMessage
+	Session string `json:"session"`
+	Sip     string `json:"sip,omitempty"` // the user of the session
+	Guid    string `json:"guid,omitempty"`
+}
+
+// closes the session
+type Close struct {
+	service.BaseMessage
+	Session string `json:"session"`
+}
+
+func NewClose(session, src string) *Close {
+	return &Close{BaseMessage: newBaseMessage("Close", src), Session: session}
+}
+
+type CloseResult struct {
+	service.BaseMessage
+}
+
+// sends the message of an api, like PbxApi or RCC, as the user of the session
+type Send struct {
+	service.BaseMessage
+	Session string      `json:"session"`
+	Msg     interface{} `json:"msg"`
+}
+
+func NewSend(session string, msg interface{}, src string) *Send {
+	return &Send{BaseMessage: newBaseMessage("Send", src), Session: session, Msg: msg}
+}
+
+// the result of Send, with the result of the message of the api
+type SendResult struct {
+	service.BaseMessage
+	Session string          `json:"session"`
+	Msg     json.RawMessage `json:"msg"`
+}
+
+// sent by the PBX when a session is closed, e.g. because the user was deleted
+type Closed struct {
+	service.BaseMessage
+	Session string `json:"session"`
+}
+
+// a message of an api sent by the PBX to the user of the session, like a PresenceUpdate
+type Received struct {
+	service.BaseMessage
+	Session string          `json:"session"`
+	Msg     json.RawMessage `json:"msg"`
+}
+
+type PbxImpersonationEvent struct {
+	Type       int
+	Connection *service.AppServicePbxConnection
+	Session    *Session
+	Received   *Received // of PbxImpersonationEventReceived
+}
+
+const PbxImpersonationEventDisconnect = -20
+const PbxImpersonationEventConnect = -10
+const PbxImpersonationEventOpened = 10
+const PbxImpersonationEventClosed = 20
+const PbxImpersonationEventReceived = 30
+
+func (event PbxImpersonationEvent) GetType() int {
+	return event.Type
+}
+
+func (event PbxImpersonationEvent) GetConnection() *service.AppServicePbxConnection {
+	return event.Connection
+}
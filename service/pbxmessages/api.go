@@ -1,11 +1,22 @@
 package pbxmessages
 
 import (
+	"encoding/json"
+	"sync"
+
 	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/events"
 	log "github.com/sirupsen/logrus"
 )
 
 type PbxMessages struct {
+	events.Publisher[PbxMessagesEvent]
+
+	mu sync.Mutex
+}
+
+func NewPbxMessages() *PbxMessages {
+	return &PbxMessages{}
 }
 
 func (api *PbxMessages) GetApiName() string {
@@ -13,13 +24,37 @@ func (api *PbxMessages) GetApiName() string {
 }
 
 func (api *PbxMessages) OnConnect(connection *service.AppServicePbxConnection) {
-	log.WithField("api", api.GetApiName()).Warn("OnConnect not implemented ")
+	api.Publish(PbxMessagesEvent{Type: PbxMessagesEventConnect, Connection: connection})
 }
 
 func (api *PbxMessages) OnDisconnect(connection *service.AppServicePbxConnection) {
-	log.WithField("api", api.GetApiName()).Warn("OnDisconnect not implemented ")
+	api.Publish(PbxMessagesEvent{Type: PbxMessagesEventDisconnect, Connection: connection})
 }
 
 func (api *PbxMessages) HandleMessage(connection *service.AppServicePbxConnection, msg *service.BaseMessage, message []byte) {
-	log.WithField("api", api.GetApiName()).Warn("HandleMessage not implemented ")
+	switch msg.Mt {
+	case "MessageReceived":
+		msg := MessageReceived{}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Errorf("PbxMessages: error unmarshalling message: %v", err)
+			return
+		}
+		api.Publish(PbxMessagesEvent{Type: PbxMessagesEventMessage, Message: &msg.Message, Connection: connection})
+	case "MessageDelivered", "MessageRead":
+		status := MessageStatus{}
+		if err := json.Unmarshal(message, &status); err != nil {
+			log.Errorf("PbxMessages: error unmarshalling message: %v", err)
+			return
+		}
+		eventType := PbxMessagesEventDelivered
+		if msg.Mt == "MessageRead" {
+			eventType = PbxMessagesEventRead
+		}
+		api.Publish(PbxMessagesEvent{Type: eventType, Status: &status, Connection: connection})
+	case "SendMessageResult", "MarkReadResult", "GetHistoryResult":
+		// the results of requests not sent with Call
+		log.Tracef("PbxMessages: %s received: %s", msg.Mt, string(message))
+	default:
+		log.Warnf("unknown message received: %s", msg.Mt)
+	}
 }
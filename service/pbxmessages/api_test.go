@@ -0,0 +1,86 @@
+package pbxmessages_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/events"
+	"github.com/ricoschulte/go-myapps/service/pbxmessages"
+	"github.com/ricoschulte/go-myapps/service/servicetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPbxMessages(t *testing.T) {
+	requests := make(chan map[string]interface{}, 10)
+	connection := servicetest.NewConnection(t, func(peer *websocket.Conn, message map[string]interface{}) {
+		requests <- message
+		result := map[string]interface{}{"api": "PbxMessages", "mt": message["mt"].(string) + "Result", "src": message["src"]}
+		switch message["mt"] {
+		case "SendMessage":
+			result["id"] = "m1"
+		case "GetHistory":
+			result["messages"] = []map[string]interface{}{{"id": "m1", "from": map[string]string{"sip": "alerts"}, "to": map[string]string{"sip": "alice"}, "text": "disk full", "time": 1700000000000, "read": true}}
+			result["more"] = true
+		}
+		peer.WriteJSON(result)
+	})
+	api := pbxmessages.NewPbxMessages()
+	s := &service.AppService{}
+	s.RegisterHandler(api)
+	connection.AppService = s
+	connection.Authenticated = true
+	go connection.Loop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	subscription := api.Subscribe(ctx)
+
+	sent, err := pbxmessages.CallSendMessageToSip(ctx, connection, "alerts", "alice", "disk full")
+	assert.NoError(t, err)
+	assert.Equal(t, "m1", sent.Id)
+	request := <-requests
+	assert.Equal(t, map[string]interface{}{"sip": "alice"}, request["to"])
+	assert.Equal(t, "disk full", request["text"])
+
+	_, err = pbxmessages.CallSendMessageToNum(ctx, connection, "alerts", "+49301234", "disk full")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"num": "+49301234"}, (<-requests)["to"])
+
+	history, err := pbxmessages.CallGetHistory(ctx, connection, "alice", pbxmessages.Address{Sip: "alerts"}, 10, time.UnixMilli(1800000000000))
+	assert.NoError(t, err)
+	request = <-requests
+	assert.Equal(t, float64(10), request["max"])
+	assert.Equal(t, float64(1800000000000), request["before"])
+	if assert.Len(t, history.Messages, 1) {
+		assert.True(t, history.Messages[0].Read)
+		assert.Equal(t, int64(1700000000000), history.Messages[0].GetTime().UnixMilli())
+	}
+	assert.True(t, history.More)
+
+	_, err = pbxmessages.CallMarkRead(ctx, connection, "alice", "m2")
+	assert.NoError(t, err)
+	assert.Equal(t, "m2", (<-requests)["id"])
+
+	// the messages and notifications of the PBX
+	handle := func(mt, message string) {
+		api.HandleMessage(connection, &service.BaseMessage{Api: "PbxMessages", Mt: mt}, []byte(message))
+	}
+	handle("MessageReceived", `{"api":"PbxMessages","mt":"MessageReceived","message":{"id":"m2","from":{"sip":"alice"},"to":{"sip":"alerts"},"text":"ack"}}`)
+	handle("MessageDelivered", `{"api":"PbxMessages","mt":"MessageDelivered","id":"m1"}`)
+	handle("MessageRead", `{"api":"PbxMessages","mt":"MessageRead","id":"m1"}`)
+
+	event := <-subscription.C
+	assert.Equal(t, pbxmessages.PbxMessagesEventMessage, event.Type)
+	assert.Equal(t, "ack", event.Message.Text)
+	event = <-subscription.C
+	assert.Equal(t, pbxmessages.PbxMessagesEventDelivered, event.Type)
+	assert.Equal(t, "m1", event.Status.Id)
+	event = <-subscription.C
+	assert.Equal(t, pbxmessages.PbxMessagesEventRead, event.Type)
+
+	read := api.Subscribe(ctx, events.ForTypes[pbxmessages.PbxMessagesEvent](pbxmessages.PbxMessagesEventRead))
+	handle("MessageRead", `{"api":"PbxMessages","mt":"MessageRead","id":"m3"}`)
+	assert.Equal(t, "m3", (<-read.C).Status.Id)
+}
@@ -0,0 +1,28 @@
+package pbxmessages
+
+import (
+	"context"
+	"time"
+
+	"github.com/ricoschulte/go-myapps/service"
+)
+
+// sends the text from the user to the user with the sip name and returns the id of the message
+func CallSendMessageToSip(ctx context.Context, connection *service.AppServicePbxConnection, from, to, text string) (*SendMessageResult, error) {
+	return service.CallFor[SendMessageResult](ctx, connection, "PbxMessages", NewSendMessageToSip(from, to, text, ""))
+}
+
+// sends the text from the user to the number and returns the id of the message
+func CallSendMessageToNum(ctx context.Context, connection *service.AppServicePbxConnection, from, to, text string) (*SendMessageResult, error) {
+	return service.CallFor[SendMessageResult](ctx, connection, "PbxMessages", NewSendMessageToNum(from, to, text, ""))
+}
+
+// marks the message received by the user as read
+func CallMarkRead(ctx context.Context, connection *service.AppServicePbxConnection, sip, id string) (*MarkReadResult, error) {
+	return service.CallFor[MarkReadResult](ctx, connection, "PbxMessages", NewMarkRead(sip, id, ""))
+}
+
+// returns up to max messages of the user with the peer before the time, the newest first. All messages if before is zero.
+func CallGetHistory(ctx context.Context, connection *service.AppServicePbxConnection, sip string, peer Address, max int, before time.Time) (*GetHistoryResult, error) {
+	return service.CallFor[GetHistoryResult](ctx, connection, "PbxMessages", NewGetHistory(sip, peer, max, before, ""))
+}
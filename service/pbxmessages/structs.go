@@ -0,0 +1,158 @@
+package pbxmessages
+
+import (
+	"time"
+
+	"github.com/ricoschulte/go-myapps/service"
+)
+
+func newBaseMessage(mt, src string) service.BaseMessage {
+	return service.BaseMessage{
+		Api: "PbxMessages",
+		Mt:  mt,
+		Src: src,
+	}
+}
+
+// the sender or recipient of a message, a user by sip name or a number
+type Address struct {
+	Sip string `json:"sip,omitempty"`
+	Num string `json:"num,omitempty"`
+	Dn  string `json:"dn,omitempty"`
+}
+
+// a chat message
+type Message struct {
+	Id        string  `json:"id,omitempty"`
+	From      Address `json:"from"`
+	To        Address `json:"to"`
+	Text      string  `json:"text"`
+	Time      int64   `json:"time,omitempty"` // unix time in milliseconds
+	Delivered bool    `json:"delivered,omitempty"`
+	Read      bool    `json:"read,omitempty"`
+}
+
+// returns the Time of the message
+func (m *Message) GetTime() time.Time {
+	return time.UnixMilli(m.Time)
+}
+
+// sends the text from the user with the sip name to the recipient, the result has the id of the message
+type SendMessage struct {
+	service.BaseMessage
+	From Address `json:"from"`
+	To   Address `json:"to"`
+	Text string  `json:"text"`
+}
+
+// sends the text to the user with the sip name
+func NewSendMessageToSip(from, to, text, src string) *SendMessage {
+	return &SendMessage{
+		BaseMessage: newBaseMessage("SendMessage", src),
+		From:        Address{Sip: from},
+		To:          Address{Sip: to},
+		Text:        text,
+	}
+}
+
+// sends the text to the number
+func NewSendMessageToNum(from, to, text, src string) *SendMessage {
+	return &SendMessage{
+		BaseMessage: newBaseMessage("SendMessage", src),
+		From:        Address{Sip: from},
+		To:          Address{Num: to},
+		Text:        text,
+	}
+}
+
+type SendMessageResult struct {
+	service.BaseMessage
+	Id        string `json:"id"`
+	Error     int    `json:"error,omitempty"`
+	Errortext string `json:"errorText,omitempty"`
+}
+
+// a message received for a user
+type MessageReceived struct {
+	service.BaseMessage
+	Message Message `json:"message"`
+}
+
+// the message with the id was delivered to, or read by, the recipient
+type MessageStatus struct {
+	service.BaseMessage
+	Id   string `json:"id"`
+	Time int64  `json:"time,omitempty"`
+}
+
+// marks the received message as read, the sender gets a MessageRead
+type MarkRead struct {
+	service.BaseMessage
+	Sip string `json:"sip"` // the recipient
+	Id  string `json:"id"`
+}
+
+func NewMarkRead(sip, id, src string) *MarkRead {
+	return &MarkRead{
+		BaseMessage: newBaseMessage("MarkRead", src),
+		Sip:         sip,
+		Id:          id,
+	}
+}
+
+type MarkReadResult struct {
+	service.BaseMessage
+	Error     int    `json:"error,omitempty"`
+	Errortext string `json:"errorText,omitempty"`
+}
+
+// queries the messages of the user with the peer, the newest first
+type GetHistory struct {
+	service.BaseMessage
+	Sip    string  `json:"sip"`
+	Peer   Address `json:"peer"`
+	Max    int     `json:"max,omitempty"`    // the maximum number of messages
+	Before int64   `json:"before,omitempty"` // only messages before this unix time in milliseconds
+}
+
+func NewGetHistory(sip string, peer Address, max int, before time.Time, src string) *GetHistory {
+	msg := &GetHistory{
+		BaseMessage: newBaseMessage("GetHistory", src),
+		Sip:         sip,
+		Peer:        peer,
+		Max:         max,
+	}
+	if !before.IsZero() {
+		msg.Before = before.UnixMilli()
+	}
+	return msg
+}
+
+type GetHistoryResult struct {
+	service.BaseMessage
+	Messages  []Message `json:"messages"`
+	More      bool      `json:"more,omitempty"` // there are older messages
+	Error     int       `json:"error,omitempty"`
+	Errortext string    `json:"errorText,omitempty"`
+}
+
+type PbxMessagesEvent struct {
+	Type       int
+	Connection *service.AppServicePbxConnection
+	Message    *Message       // of PbxMessagesEventMessage
+	Status     *MessageStatus // of PbxMessagesEventDelivered and PbxMessagesEventRead
+}
+
+const PbxMessagesEventDisconnect = -20
+const PbxMessagesEventConnect = -10
+const PbxMessagesEventMessage = 10
+const PbxMessagesEventDelivered = 20
+const PbxMessagesEventRead = 30
+
+func (event PbxMessagesEvent) GetType() int {
+	return event.Type
+}
+
+func (event PbxMessagesEvent) GetConnection() *service.AppServicePbxConnection {
+	return event.Connection
+}
@@ -1,20 +1,47 @@
 package pbxtableusers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/events"
+	"github.com/ricoschulte/go-myapps/service/store"
 	log "github.com/sirupsen/logrus"
 )
 
+/*
+replicates the users of the PBXs connected to the app service.
+
+The objects of all PBXs are kept in ReplicatedObjects, the initial sync and the DisconnectPolicy
+of a PBX only apply to its own objects. PBXs with overlapping guids need PbxTableUsersReplicas.
+*/
 type PbxTableUsers struct {
+	events.Publisher[PbxTableUsersEvent]
+
 	ReplicatedObjects      map[string]ReplicatedObject // synced objects
-	ReplicatedObjectsMutex sync.Mutex
+	ReplicatedObjectsMutex sync.RWMutex
+	indexes                indexes           // the secondary indexes of ReplicatedObjects
+	sources                map[string]string // the PbxKey of the PBX each object was received from, guarded by ReplicatedObjectsMutex
 	mu                     sync.Mutex
-	receivers              []chan PbxTableUsersEvent
+
+	// persists the replicated objects, so the initial sync after a restart or reconnect
+	// sends only the changes since the cached state instead of a PbxTableUsersEventInitial per object
+	Cache Cache
+	syncs map[*service.AppServicePbxConnection]map[string]bool // the guids received by the running initial syncs
+
+	// sends a ReplicateStart with the options on connect, if not nil
+	Replicate *ReplicateOptions
+	// what happens with the objects of a PBX when its last connection is closed, DisconnectKeep by default
+	DisconnectPolicy DisconnectPolicy
+	connections      map[*service.AppServicePbxConnection]bool
+	stale            map[string]bool // the PbxKey of the PBXs disconnected with DisconnectFlag
 }
 
 func NewPbxTableUsers() *PbxTableUsers {
@@ -23,27 +50,131 @@ func NewPbxTableUsers() *PbxTableUsers {
 	}
 }
 
+// returns a PbxTableUsers with the objects of the cache
+func NewPbxTableUsersWithCache(ctx context.Context, cache Cache) (*PbxTableUsers, error) {
+	api := NewPbxTableUsers()
+	api.Cache = cache
+	if err := api.LoadCache(ctx); err != nil {
+		return nil, err
+	}
+	return api, nil
+}
+
+// replaces the replicated objects with the objects of the Cache
+func (api *PbxTableUsers) LoadCache(ctx context.Context) error {
+	if api.Cache == nil {
+		return nil
+	}
+	objects, err := api.Cache.List(ctx)
+	if err != nil {
+		return fmt.Errorf("loading the cache failed: %v", err)
+	}
+	api.ReplicatedObjectsMutex.Lock()
+	defer api.ReplicatedObjectsMutex.Unlock()
+	api.ReplicatedObjects = make(map[string]ReplicatedObject, len(objects))
+	api.sources = map[string]string{}
+	for guid, object := range objects {
+		api.ReplicatedObjects[guid] = object.ReplicatedObject
+		if object.Pbx != "" {
+			api.sources[guid] = object.Pbx
+		}
+	}
+	api.rebuildIndexes()
+	return nil
+}
+
 func (api *PbxTableUsers) GetApiName() string {
 	return "PbxTableUsers"
 }
 
 func (api *PbxTableUsers) OnConnect(connection *service.AppServicePbxConnection) {
-	api.sendEvent(PbxTableUsersEvent{Type: PbxTableUsersEventConnect, Connection: connection})
+	api.mu.Lock()
+	if api.connections == nil {
+		api.connections = map[*service.AppServicePbxConnection]bool{}
+	}
+	api.connections[connection] = true
+	api.mu.Unlock()
+	api.Publish(PbxTableUsersEvent{Type: PbxTableUsersEventConnect, Connection: connection})
+
+	if api.Replicate != nil {
+		start := NewReplicateStart(api.Replicate.Add, api.Replicate.Del, api.Replicate.Columns, api.Replicate.Pseudo, "src_"+strconv.FormatInt(time.Now().UnixNano(), 10))
+		mbytes, _ := json.Marshal(start)
+		connection.WriteMessage(mbytes)
+	}
 }
 
 func (api *PbxTableUsers) OnDisconnect(connection *service.AppServicePbxConnection) {
-	api.sendEvent(PbxTableUsersEvent{Type: PbxTableUsersEventDisconnect, Connection: connection})
+	pbx := PbxKey(connection)
+	// an incomplete sync does not tell which objects were deleted
+	api.mu.Lock()
+	delete(api.syncs, connection)
+	delete(api.connections, connection)
+	lastOfPbx := true
+	for other := range api.connections {
+		if PbxKey(other) == pbx {
+			lastOfPbx = false
+		}
+	}
+	if lastOfPbx && api.DisconnectPolicy == DisconnectFlag {
+		if api.stale == nil {
+			api.stale = map[string]bool{}
+		}
+		api.stale[pbx] = true
+	}
+	api.mu.Unlock()
+	api.Publish(PbxTableUsersEvent{Type: PbxTableUsersEventDisconnect, Connection: connection})
+
+	if lastOfPbx && api.DisconnectPolicy == DisconnectPurge {
+		for _, object := range api.purge(pbx) {
+			object := object
+			api.Publish(PbxTableUsersEvent{Type: PbxTableUsersEventDelete, Object: &object, Connection: connection})
+		}
+	}
 }
 
-func (api *PbxTableUsers) HandleMessage(connection *service.AppServicePbxConnection, msg *service.BaseMessage, message []byte) {
+// returns true if objects may be outdated, because a PBX was disconnected with DisconnectFlag.
+// The flag of a PBX is cleared when its next initial sync is done.
+func (api *PbxTableUsers) IsStale() bool {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return len(api.stale) > 0
+}
+
+// returns true if the objects of the PBX with the PbxKey may be outdated, see IsStale
+func (api *PbxTableUsers) IsStalePbx(pbx string) bool {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	return api.stale[pbx]
+}
+
+// removes the objects of the PBX, also from the cache, and returns them
+func (api *PbxTableUsers) purge(pbx string) []ReplicatedObject {
 	api.ReplicatedObjectsMutex.Lock()
-	defer api.ReplicatedObjectsMutex.Unlock()
+	purged := []ReplicatedObject{}
+	for guid, object := range api.ReplicatedObjects {
+		if api.sources[guid] == pbx {
+			purged = append(purged, object)
+		}
+	}
+	for _, object := range purged {
+		api.removeObject(object.Guid)
+	}
+	api.ReplicatedObjectsMutex.Unlock()
+
+	for _, object := range purged {
+		api.deleteCache(object.Guid)
+	}
+	return purged
+}
+
+func (api *PbxTableUsers) HandleMessage(connection *service.AppServicePbxConnection, msg *service.BaseMessage, message []byte) {
 	switch msg.Mt {
 	case "ReplicateStartResult":
 		msg := ReplicateStartResult{}
 		if err := json.Unmarshal(message, &msg); err != nil {
 			log.Errorf("PbxApi: error unmarshalling message: %v", err)
 		}
+		api.startSync(connection)
 		mbytes, _ := json.Marshal(NewReplicateNext("src_" + strconv.FormatInt(time.Now().UnixNano(), 10)))
 		connection.WriteMessage(mbytes)
 	case "ReplicateNextResult":
@@ -52,79 +183,219 @@ func (api *PbxTableUsers) HandleMessage(connection *service.AppServicePbxConnect
 			log.Errorf("PbxApi: error unmarshalling message: %v", err)
 		}
 		if len(msg.ReplicatedObject.Guid) > 0 {
-			api.ReplicatedObjects[msg.ReplicatedObject.Guid] = msg.ReplicatedObject
-			api.sendEvent(PbxTableUsersEvent{Type: PbxTableUsersEventInitial, Object: &msg.ReplicatedObject, Connection: connection})
+			if eventType, ok := api.syncObject(connection, msg.ReplicatedObject); ok {
+				if eventType != PbxTableUsersEventInitial {
+					api.putCache(connection, &msg.ReplicatedObject)
+				}
+				api.Publish(PbxTableUsersEvent{Type: eventType, Object: &msg.ReplicatedObject, Connection: connection})
+			}
 
 			mbytes, _ := json.Marshal(NewReplicateNext("src_" + strconv.FormatInt(time.Now().UnixNano(), 10)))
 			connection.WriteMessage(mbytes)
 		} else {
-			api.sendEvent(PbxTableUsersEvent{Type: PbxTableUsersEventInitialDone, Connection: connection})
+			api.mu.Lock()
+			delete(api.stale, PbxKey(connection))
+			api.mu.Unlock()
+			for _, object := range api.finishSync(connection) {
+				object := object
+				api.Publish(PbxTableUsersEvent{Type: PbxTableUsersEventDelete, Object: &object, Connection: connection})
+			}
+			api.Publish(PbxTableUsersEvent{Type: PbxTableUsersEventInitialDone, Connection: connection})
 		}
 	case "ReplicateAdd":
 		msg := ReplicateAdd{}
 		if err := json.Unmarshal(message, &msg); err != nil {
 			log.Errorf("PbxApi: error unmarshalling message: %v", err)
 		}
-		api.ReplicatedObjects[msg.ReplicatedObject.Guid] = msg.ReplicatedObject
-		api.sendEvent(PbxTableUsersEvent{Type: PbxTableUsersEventAdd, Object: &msg.ReplicatedObject, Connection: connection})
+		api.setReplicatedObject(connection, msg.ReplicatedObject)
+		api.putCache(connection, &msg.ReplicatedObject)
+		api.Publish(PbxTableUsersEvent{Type: PbxTableUsersEventAdd, Object: &msg.ReplicatedObject, Connection: connection})
 	case "ReplicateUpdate":
 		msg := ReplicateUpdate{}
 		if err := json.Unmarshal(message, &msg); err != nil {
 			log.Errorf("PbxApi: error unmarshalling message: %v", err)
 		}
-		api.ReplicatedObjects[msg.ReplicatedObject.Guid] = msg.ReplicatedObject
-		api.sendEvent(PbxTableUsersEvent{Type: PbxTableUsersEventUpdate, Object: &msg.ReplicatedObject, Connection: connection})
+		api.setReplicatedObject(connection, msg.ReplicatedObject)
+		api.putCache(connection, &msg.ReplicatedObject)
+		api.Publish(PbxTableUsersEvent{Type: PbxTableUsersEventUpdate, Object: &msg.ReplicatedObject, Connection: connection})
 	case "ReplicateDel":
 		msg := ReplicateDel{}
 		if err := json.Unmarshal(message, &msg); err != nil {
 			log.Errorf("PbxApi: error unmarshalling message: %v", err)
 		}
-		delete(api.ReplicatedObjects, msg.ReplicatedObject.Guid)
-		api.sendEvent(PbxTableUsersEvent{Type: PbxTableUsersEventDelete, Object: &msg.ReplicatedObject, Connection: connection})
+		api.deleteReplicatedObject(msg.ReplicatedObject.Guid)
+		api.deleteCache(msg.ReplicatedObject.Guid)
+		api.Publish(PbxTableUsersEvent{Type: PbxTableUsersEventDelete, Object: &msg.ReplicatedObject, Connection: connection})
+	case "ReplicateAddResult", "ReplicateUpdateResult", "ReplicateDelResult":
+		// the results of writes not sent with Call
+		log.Tracef("PbxTableUsers: %s received: %s", msg.Mt, string(message))
 
 	default:
-		log.Warn("unknown message received: %s", string(message))
+		log.Warnf("unknown message received: %s", string(message))
 	}
 }
 
-func (api *PbxTableUsers) AddReceiver() chan PbxTableUsersEvent {
+// the events are published after the mutex is released, so receivers can call GetReplicatedObjects
+func (api *PbxTableUsers) setReplicatedObject(connection *service.AppServicePbxConnection, object ReplicatedObject) {
+	api.ReplicatedObjectsMutex.Lock()
+	defer api.ReplicatedObjectsMutex.Unlock()
+	api.putObject(object)
+	api.setSource(object.Guid, connection)
+}
+
+func (api *PbxTableUsers) deleteReplicatedObject(guid string) {
+	api.ReplicatedObjectsMutex.Lock()
+	defer api.ReplicatedObjectsMutex.Unlock()
+	api.removeObject(guid)
+}
+
+// starts the diff of the initial sync against the cached objects, only with a Cache
+func (api *PbxTableUsers) startSync(connection *service.AppServicePbxConnection) {
+	if api.Cache == nil {
+		return
+	}
 	api.mu.Lock()
 	defer api.mu.Unlock()
-	ch := make(chan PbxTableUsersEvent)
-	api.receivers = append(api.receivers, ch)
-	return ch
+	if api.syncs == nil {
+		api.syncs = map[*service.AppServicePbxConnection]map[string]bool{}
+	}
+	api.syncs[connection] = map[string]bool{}
 }
 
-func (api *PbxTableUsers) RemoveReceiver(ch chan PbxTableUsersEvent) {
+/*
+stores an object of the initial sync and returns the type of the event to send for it.
+
+Without a running diff this is PbxTableUsersEventInitial. Otherwise it is PbxTableUsersEventAdd
+for an object not in the cache, PbxTableUsersEventUpdate for a changed object and no event
+for an unchanged one.
+*/
+func (api *PbxTableUsers) syncObject(connection *service.AppServicePbxConnection, object ReplicatedObject) (int, bool) {
 	api.mu.Lock()
-	defer api.mu.Unlock()
+	seen, syncing := api.syncs[connection]
+	if syncing {
+		seen[object.Guid] = true
+	}
+	api.mu.Unlock()
 
-	for i, c := range api.receivers {
-		if c == ch {
-			// Remove the channel from the slice
-			api.receivers = append(api.receivers[:i], api.receivers[i+1:]...)
-			// Close the channel to signal the receiver that it should stop listening
-			close(ch)
-			return
+	api.ReplicatedObjectsMutex.Lock()
+	defer api.ReplicatedObjectsMutex.Unlock()
+	cached, found := api.ReplicatedObjects[object.Guid]
+	api.putObject(object)
+	api.setSource(object.Guid, connection)
+	switch {
+	case !syncing:
+		return PbxTableUsersEventInitial, true
+	case !found:
+		return PbxTableUsersEventAdd, true
+	case !sameObject(cached, object):
+		return PbxTableUsersEventUpdate, true
+	}
+	return 0, false
+}
+
+/*
+ends the diff of the initial sync and removes the objects that were not received, they are returned.
+
+Only the objects of the PBX of the connection are removed, the objects of other PBXs and the objects
+cached without a PbxKey are kept.
+*/
+func (api *PbxTableUsers) finishSync(connection *service.AppServicePbxConnection) []ReplicatedObject {
+	api.mu.Lock()
+	seen, syncing := api.syncs[connection]
+	delete(api.syncs, connection)
+	api.mu.Unlock()
+	if !syncing {
+		return nil
+	}
+
+	pbx := PbxKey(connection)
+	api.ReplicatedObjectsMutex.Lock()
+	deleted := []ReplicatedObject{}
+	for guid, object := range api.ReplicatedObjects {
+		if api.sources[guid] == pbx && !seen[guid] {
+			deleted = append(deleted, object)
+			api.removeObject(guid)
 		}
 	}
+	objects := map[string]*CachedObject{}
+	for guid, object := range api.ReplicatedObjects {
+		objects[guid] = &CachedObject{ReplicatedObject: object, Pbx: api.sources[guid]}
+	}
+	api.ReplicatedObjectsMutex.Unlock()
+
+	if replacer, ok := api.Cache.(CacheReplacer); ok {
+		if err := replacer.Replace(context.Background(), objects); err != nil {
+			log.Errorf("PbxTableUsers: writing the cache failed: %v", err)
+		}
+	} else {
+		for _, object := range deleted {
+			api.deleteCache(object.Guid)
+		}
+	}
+	return deleted
+}
+
+// returns the PbxKey of the PBX the object was received from, empty for an object cached without it that no sync has received yet
+func (api *PbxTableUsers) GetPbxOfObject(guid string) string {
+	api.ReplicatedObjectsMutex.RLock()
+	defer api.ReplicatedObjectsMutex.RUnlock()
+	return api.sources[guid]
+}
+
+// records the PBX the object was received from, the ReplicatedObjectsMutex has to be locked
+func (api *PbxTableUsers) setSource(guid string, connection *service.AppServicePbxConnection) {
+	if api.sources == nil {
+		api.sources = map[string]string{}
+	}
+	api.sources[guid] = PbxKey(connection)
+}
+
+func sameObject(a, b ReplicatedObject) bool {
+	ja, erra := json.Marshal(a)
+	jb, errb := json.Marshal(b)
+	return erra == nil && errb == nil && bytes.Equal(ja, jb)
 }
 
-func (api *PbxTableUsers) sendEvent(event PbxTableUsersEvent) {
+func (api *PbxTableUsers) putCache(connection *service.AppServicePbxConnection, object *ReplicatedObject) {
+	if api.Cache == nil {
+		return
+	}
+	pbx := PbxKey(connection)
+	// a replacer writes all objects at the end of the initial sync of the PBX
+	if _, ok := api.Cache.(CacheReplacer); ok && api.isSyncing(pbx) {
+		return
+	}
+	if err := api.Cache.Put(context.Background(), object.Guid, &CachedObject{ReplicatedObject: *object, Pbx: pbx}); err != nil {
+		log.Errorf("PbxTableUsers: writing %s to the cache failed: %v", object.Guid, err)
+	}
+}
+
+func (api *PbxTableUsers) deleteCache(guid string) {
+	if api.Cache == nil {
+		return
+	}
+	if err := api.Cache.Delete(context.Background(), guid); err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Errorf("PbxTableUsers: deleting %s from the cache failed: %v", guid, err)
+	}
+}
+
+// returns true if an initial sync of the PBX with the PbxKey is running
+func (api *PbxTableUsers) isSyncing(pbx string) bool {
 	api.mu.Lock()
 	defer api.mu.Unlock()
-
-	// Send the event to all registered receivers
-	for _, ch := range api.receivers {
-		ch <- event
+	for connection := range api.syncs {
+		if PbxKey(connection) == pbx {
+			return true
+		}
 	}
+	return false
 }
 
 // returns the current replicated objects in a go routine save way
 func (api *PbxTableUsers) GetReplicatedObjects() map[string]ReplicatedObject {
 	// lock the map to prevent concurrent writes/reads
-	api.ReplicatedObjectsMutex.Lock()
-	defer api.ReplicatedObjectsMutex.Unlock()
+	api.ReplicatedObjectsMutex.RLock()
+	defer api.ReplicatedObjectsMutex.RUnlock()
 	listcopy := make(map[string]ReplicatedObject)
 	for key, value := range api.ReplicatedObjects {
 		listcopy[key] = value
@@ -1,10 +1,19 @@
 package pbxtableusers_test
 
 import (
+	"context"
 	"encoding/json"
+	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/events"
 	"github.com/ricoschulte/go-myapps/service/pbxtableusers"
+	"github.com/ricoschulte/go-myapps/service/servicetest"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestPbxTableUsers_ReplicateUpdate(t *testing.T) {
@@ -41,3 +50,329 @@ func TestPbxTableUsers_ReplicateUpdate(t *testing.T) {
 		})
 	}
 }
+
+func TestPbxTableUsers_Receivers(t *testing.T) {
+	api := pbxtableusers.NewPbxTableUsers()
+	// a receiver that is never read delays the handling of messages at most ReceiverTimeout
+	api.ReceiverTimeout = time.Millisecond
+	api.AddReceiver()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	subscription := api.Subscribe(ctx, events.ForTypes[pbxtableusers.PbxTableUsersEvent](pbxtableusers.PbxTableUsersEventAdd))
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < events.DefaultBuffer+10; i++ {
+			message := []byte(`{"mt":"ReplicateAdd","api":"PbxTableUsers","columns":{"guid":"` + strconv.Itoa(i) + `","h323":"user"}}`)
+			api.HandleMessage(nil, &service.BaseMessage{Api: "PbxTableUsers", Mt: "ReplicateAdd"}, message)
+		}
+		close(done)
+	}()
+
+	// the objects can be read while an event is handled
+	event := <-subscription.C
+	assert.Equal(t, pbxtableusers.PbxTableUsersEventAdd, event.Type)
+	assert.Contains(t, api.GetReplicatedObjects(), event.Object.Guid)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handling messages is blocked by a receiver")
+	}
+	assert.Len(t, api.GetReplicatedObjects(), events.DefaultBuffer+10)
+}
+
+func replicate(api *pbxtableusers.PbxTableUsers, connection *service.AppServicePbxConnection, objects ...string) {
+	api.HandleMessage(connection, &service.BaseMessage{Api: "PbxTableUsers", Mt: "ReplicateStartResult"}, []byte(`{"mt":"ReplicateStartResult","api":"PbxTableUsers"}`))
+	for _, object := range append(objects, `{}`) {
+		message := []byte(`{"mt":"ReplicateNextResult","api":"PbxTableUsers","columns":` + object + `}`)
+		api.HandleMessage(connection, &service.BaseMessage{Api: "PbxTableUsers", Mt: "ReplicateNextResult"}, message)
+	}
+}
+
+func TestPbxTableUsers_Cache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+
+	tests := []struct {
+		name    string
+		objects []string
+		events  map[string]int
+	}{
+		{
+			name:    "empty cache",
+			objects: []string{`{"guid":"1","h323":"alice"}`, `{"guid":"2","h323":"bob"}`, `{"guid":"3","h323":"carol"}`},
+			events:  map[string]int{"1": pbxtableusers.PbxTableUsersEventAdd, "2": pbxtableusers.PbxTableUsersEventAdd, "3": pbxtableusers.PbxTableUsersEventAdd},
+		},
+		{
+			name:    "unchanged",
+			objects: []string{`{"guid":"1","h323":"alice"}`, `{"guid":"2","h323":"bob"}`, `{"guid":"3","h323":"carol"}`},
+			events:  map[string]int{},
+		},
+		{
+			name:    "changes",
+			objects: []string{`{"guid":"1","h323":"alice"}`, `{"guid":"2","h323":"bobby"}`, `{"guid":"4","h323":"dave"}`},
+			events:  map[string]int{"2": pbxtableusers.PbxTableUsersEventUpdate, "3": pbxtableusers.PbxTableUsersEventDelete, "4": pbxtableusers.PbxTableUsersEventAdd},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// a new api on every run, like after a restart
+			api, err := pbxtableusers.NewPbxTableUsersWithCache(context.Background(), pbxtableusers.NewFileCache(path))
+			if !assert.NoError(t, err) {
+				return
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			subscription := api.Subscribe(ctx)
+
+			replicate(api, servicetest.NewConnection(t, nil), test.objects...)
+
+			received := map[string]int{}
+			for event := range subscription.C {
+				if event.Type == pbxtableusers.PbxTableUsersEventInitialDone {
+					break
+				}
+				received[event.Object.Guid] = event.Type
+			}
+			assert.Equal(t, test.events, received)
+			assert.Len(t, api.GetReplicatedObjects(), len(test.objects))
+
+			cached, err := pbxtableusers.NewFileCache(path).List(context.Background())
+			assert.NoError(t, err)
+			assert.Len(t, cached, len(test.objects))
+		})
+	}
+}
+
+func TestPbxTableUsers_CacheDisconnectDuringSync(t *testing.T) {
+	cache := pbxtableusers.NewFileCache(filepath.Join(t.TempDir(), "users.json"))
+	assert.NoError(t, cache.Put(context.Background(), "1", &pbxtableusers.CachedObject{ReplicatedObject: pbxtableusers.ReplicatedObject{Guid: "1", H323: "alice"}}))
+	api, err := pbxtableusers.NewPbxTableUsersWithCache(context.Background(), cache)
+	assert.NoError(t, err)
+
+	connection := servicetest.NewConnection(t, nil)
+	api.HandleMessage(connection, &service.BaseMessage{Api: "PbxTableUsers", Mt: "ReplicateStartResult"}, []byte(`{"mt":"ReplicateStartResult","api":"PbxTableUsers"}`))
+	api.HandleMessage(connection, &service.BaseMessage{Api: "PbxTableUsers", Mt: "ReplicateNextResult"}, []byte(`{"mt":"ReplicateNextResult","api":"PbxTableUsers","columns":{"guid":"2","h323":"bob"}}`))
+	api.OnDisconnect(connection)
+
+	// the incomplete sync deletes nothing
+	assert.Len(t, api.GetReplicatedObjects(), 2)
+	cached, err := cache.List(context.Background())
+	assert.NoError(t, err)
+	assert.Contains(t, cached, "1")
+}
+
+func TestPbxTableUsers_CacheTwoConnections(t *testing.T) {
+	cache := pbxtableusers.NewFileCache(filepath.Join(t.TempDir(), "users.json"))
+	api, err := pbxtableusers.NewPbxTableUsersWithCache(context.Background(), cache)
+	assert.NoError(t, err)
+
+	pbx1 := servicetest.NewConnection(t, nil)
+	pbx1.PbxInfo.Pbx = "pbx1"
+	pbx2 := servicetest.NewConnection(t, nil)
+	pbx2.PbxInfo.Pbx = "pbx2"
+	replicate(api, pbx1, `{"guid":"1","h323":"alice"}`, `{"guid":"2","h323":"bob"}`)
+	replicate(api, pbx2, `{"guid":"3","h323":"carol"}`)
+	assert.Len(t, api.GetReplicatedObjects(), 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	subscription := api.Subscribe(ctx, events.ForTypes[pbxtableusers.PbxTableUsersEvent](pbxtableusers.PbxTableUsersEventDelete))
+
+	// the sync of a PBX removes only its own objects that were not received
+	replicate(api, pbx1, `{"guid":"1","h323":"alice"}`)
+	event := <-subscription.C
+	assert.Equal(t, "2", event.Object.Guid)
+	assert.Same(t, pbx1, event.Connection)
+
+	objects := api.GetReplicatedObjects()
+	assert.Len(t, objects, 2)
+	assert.Contains(t, objects, "1")
+	assert.Contains(t, objects, "3")
+	cached, err := cache.List(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, cached, 2)
+	assert.Contains(t, cached, "3")
+
+	replicate(api, pbx2)
+	assert.Equal(t, "3", (<-subscription.C).Object.Guid)
+	assert.Len(t, api.GetReplicatedObjects(), 1)
+}
+
+func TestPbxTableUsers_CacheRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	pbx1 := servicetest.NewConnection(t, nil)
+	pbx1.PbxInfo.Pbx = "pbx1"
+	pbx2 := servicetest.NewConnection(t, nil)
+	pbx2.PbxInfo.Pbx = "pbx2"
+
+	api, err := pbxtableusers.NewPbxTableUsersWithCache(context.Background(), pbxtableusers.NewFileCache(path))
+	assert.NoError(t, err)
+	replicate(api, pbx1, `{"guid":"1","h323":"alice"}`)
+	replicate(api, pbx2, `{"guid":"2","h323":"bob"}`)
+
+	// after a restart the objects keep their PBX
+	api, err = pbxtableusers.NewPbxTableUsersWithCache(context.Background(), pbxtableusers.NewFileCache(path))
+	assert.NoError(t, err)
+	assert.Equal(t, pbxtableusers.PbxKey(pbx1), api.GetPbxOfObject("1"))
+	assert.Equal(t, pbxtableusers.PbxKey(pbx2), api.GetPbxOfObject("2"))
+
+	// the first sync removes only the objects of its PBX
+	replicate(api, pbx2)
+	objects := api.GetReplicatedObjects()
+	assert.Len(t, objects, 1)
+	assert.Contains(t, objects, "1")
+
+	// the last disconnect purges only the objects of its PBX
+	api.DisconnectPolicy = pbxtableusers.DisconnectPurge
+	api.OnConnect(pbx2)
+	api.HandleMessage(pbx2, &service.BaseMessage{Api: "PbxTableUsers", Mt: "ReplicateAdd"}, []byte(`{"mt":"ReplicateAdd","api":"PbxTableUsers","columns":{"guid":"3","h323":"carol"}}`))
+	api.OnDisconnect(pbx2)
+	objects = api.GetReplicatedObjects()
+	assert.Len(t, objects, 1)
+	assert.Contains(t, objects, "1")
+}
+
+func TestPbxTableUsers_Write(t *testing.T) {
+	requests := make(chan map[string]interface{}, 10)
+	connection := servicetest.NewConnection(t, func(peer *websocket.Conn, message map[string]interface{}) {
+		requests <- message
+		result := map[string]interface{}{"api": "PbxTableUsers", "mt": message["mt"].(string) + "Result", "src": message["src"]}
+		if columns, ok := message["columns"].(map[string]interface{}); ok {
+			result["guid"] = columns["guid"]
+			if message["mt"] == "ReplicateAdd" {
+				result["guid"] = "new-guid"
+			}
+			if columns["h323"] == "exists" {
+				result["error"] = 1
+				result["errorText"] = "object exists"
+			}
+		}
+		peer.WriteJSON(result)
+	})
+	connection.Authenticated = true
+	go connection.Loop()
+	ctx := context.Background()
+
+	user := &pbxtableusers.ReplicatedObject{H323: "jdoe", Cn: "John Doe", E164: "123"}
+	user.AddEmail("john.doe@example.com")
+	user.AddEmail("John.Doe@example.com")
+	user.AddGrp(pbxtableusers.Grp{Name: "Sales", Mode: "active"})
+	user.SetForks(pbxtableusers.Fork{E164: "0170123", Delay: 10})
+	user.SetCd(pbxtableusers.Cd{Type: "cfnr", E164: "456"})
+	assert.Len(t, user.Emails, 1)
+
+	added, err := pbxtableusers.CallReplicateAdd(ctx, connection, user)
+	assert.NoError(t, err)
+	assert.Equal(t, "new-guid", added.Guid)
+	request := <-requests
+	assert.Equal(t, "ReplicateAdd", request["mt"])
+	columns := request["columns"].(map[string]interface{})
+	assert.Equal(t, "jdoe", columns["h323"])
+	for _, unset := range []string{"guid", "pwd", "hide", "fax", "devices", "wakeups"} {
+		assert.NotContains(t, columns, unset, "only the columns that are set are added")
+	}
+	assert.Equal(t, []interface{}{map[string]interface{}{"email": "john.doe@example.com"}}, columns["emails"])
+	assert.Equal(t, "Sales", columns["grps"].([]interface{})[0].(map[string]interface{})["name"])
+	assert.Equal(t, float64(10), columns["forks"].([]interface{})[0].(map[string]interface{})["delay"])
+
+	user.Guid = added.Guid
+	current := &pbxtableusers.ReplicatedObject{}
+	data, _ := json.Marshal(user)
+	assert.NoError(t, json.Unmarshal(data, current))
+	user.RemoveGrp("Sales")
+	user.SetCd(pbxtableusers.Cd{Type: "cfnr", E164: "789"})
+	assert.Len(t, user.Cds, 1)
+	updated, err := pbxtableusers.CallReplicateUpdateChanges(ctx, connection, current, user)
+	assert.NoError(t, err)
+	assert.Equal(t, "new-guid", updated.Guid)
+	request = <-requests
+	assert.Equal(t, "ReplicateUpdate", request["mt"])
+	columns = request["columns"].(map[string]interface{})
+	assert.ElementsMatch(t, []string{"guid", "grps", "cds"}, keys(columns), "only the changed columns are updated")
+	assert.Equal(t, []interface{}{}, columns["grps"])
+
+	// named columns are sent even if they are not set
+	_, err = pbxtableusers.CallReplicateUpdate(ctx, connection, &pbxtableusers.ReplicatedObject{Guid: "new-guid", Cn: "John"}, "cn", "hide")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"guid": "new-guid", "cn": "John", "hide": false}, (<-requests)["columns"])
+	_, err = pbxtableusers.CallReplicateAdd(ctx, connection, &pbxtableusers.ReplicatedObject{H323: "john"}, "fax", "grps")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"h323": "john", "fax": false, "grps": []interface{}{}}, (<-requests)["columns"])
+
+	deleted, err := pbxtableusers.CallReplicateDel(ctx, connection, "new-guid")
+	assert.NoError(t, err)
+	assert.Equal(t, "new-guid", deleted.Guid)
+	assert.Equal(t, "ReplicateDel", (<-requests)["mt"])
+
+	_, err = pbxtableusers.CallReplicateAdd(ctx, connection, &pbxtableusers.ReplicatedObject{H323: "exists"})
+	callErr := &service.CallError{}
+	assert.ErrorAs(t, err, &callErr)
+	assert.Equal(t, "object exists", callErr.Text)
+}
+
+func TestPbxTableUsers_Query(t *testing.T) {
+	api := pbxtableusers.NewPbxTableUsers()
+	handle := func(mt string, columns string) {
+		api.HandleMessage(nil, &service.BaseMessage{Api: "PbxTableUsers", Mt: mt}, []byte(`{"mt":"`+mt+`","api":"PbxTableUsers","columns":`+columns+`}`))
+	}
+	handle("ReplicateAdd", `{"guid":"1","h323":"Alice","e164":"10","cn":"Alice A","node":"master","loc":"pbx","emails":[{"email":"Alice@example.com"}],"grps":[{"name":"Sales"}],"devices":[{"hw":"0090333a0001"}]}`)
+	handle("ReplicateAdd", `{"guid":"2","h323":"bob","e164":"10","cn":"Bob B","node":"branch","loc":"pbx","grps":[{"name":"Sales"},{"name":"Support"}]}`)
+	handle("ReplicateAdd", `{"guid":"3","h323":"conf","e164":"30","pseudo":"<pseudo type=\"conference\"/>"}`)
+
+	tests := []struct {
+		name  string
+		index string
+		value string
+		guids []string
+	}{
+		{name: "h323 case insensitive", index: pbxtableusers.IndexH323, value: "ALICE", guids: []string{"1"}},
+		{name: "e164", index: pbxtableusers.IndexE164, value: "10", guids: []string{"1", "2"}},
+		{name: "cn", index: pbxtableusers.IndexCn, value: "Bob B", guids: []string{"2"}},
+		{name: "email", index: pbxtableusers.IndexEmail, value: "alice@EXAMPLE.com", guids: []string{"1"}},
+		{name: "grp", index: pbxtableusers.IndexGrp, value: "Sales", guids: []string{"1", "2"}},
+		{name: "hw", index: pbxtableusers.IndexHw, value: "0090333a0001", guids: []string{"1"}},
+		{name: "node", index: pbxtableusers.IndexNode, value: "branch", guids: []string{"2"}},
+		{name: "loc", index: pbxtableusers.IndexLoc, value: "pbx", guids: []string{"1", "2"}},
+		{name: "pseudo user", index: pbxtableusers.IndexPseudoType, value: pbxtableusers.PseudoTypeUser, guids: []string{"1", "2"}},
+		{name: "pseudo conference", index: pbxtableusers.IndexPseudoType, value: pbxtableusers.PseudoTypeConference, guids: []string{"3"}},
+		{name: "unknown", index: pbxtableusers.IndexE164, value: "99", guids: []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			guids := []string{}
+			for _, object := range api.Query(test.index, test.value) {
+				guids = append(guids, object.Guid)
+			}
+			assert.Equal(t, test.guids, guids)
+		})
+	}
+
+	// the indexes follow updates and deletes
+	handle("ReplicateUpdate", `{"guid":"2","h323":"bob","e164":"20","node":"branch","grps":[{"name":"Support"}]}`)
+	handle("ReplicateDel", `{"guid":"1"}`)
+	assert.Empty(t, api.GetByGrp("Sales"))
+	assert.Empty(t, api.GetByE164("10"))
+	_, ok := api.GetByH323("alice")
+	assert.False(t, ok)
+	object, ok := api.GetByE164OnNode("20", "branch")
+	assert.True(t, ok)
+	assert.Equal(t, "2", object.Guid)
+	assert.Len(t, api.GetByGrp("Support"), 1)
+
+	// a snapshot is not changed by later updates
+	snapshot := api.GetByPseudoType(pbxtableusers.PseudoTypeConference)
+	handle("ReplicateUpdate", `{"guid":"3","h323":"conf2","e164":"30","pseudo":"<pseudo type=\"conference\"/>"}`)
+	assert.Equal(t, "conf", snapshot[0].H323)
+	object, _ = api.GetByH323("conf2")
+	assert.Equal(t, "3", object.Guid)
+}
+
+func keys(m map[string]interface{}) []string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,208 @@
+package pbxtableusers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ricoschulte/go-myapps/service/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// a replicated object in the Cache with the PBX it was received from
+type CachedObject struct {
+	ReplicatedObject
+	Pbx string `json:"pbx,omitempty"` // the PbxKey of the PBX, empty if the object was cached without it
+}
+
+// persists the replicated objects by guid, implemented by FileCache and store.Repository[CachedObject]
+type Cache interface {
+	List(ctx context.Context) (map[string]*CachedObject, error)
+	Put(ctx context.Context, guid string, object *CachedObject) error
+	Delete(ctx context.Context, guid string) error
+}
+
+// implemented by caches that can store all objects at once, used after the initial sync
+type CacheReplacer interface {
+	Replace(ctx context.Context, objects map[string]*CachedObject) error
+}
+
+var _ Cache = (*store.Repository[CachedObject])(nil)
+
+// returns a cache in the table of the store of the app instance
+func NewStoreCache(ctx context.Context, db *store.Store, name string) (*store.Repository[CachedObject], error) {
+	return store.NewRepository[CachedObject](ctx, db, name)
+}
+
+// the time a FileCache collects changes before the file is written, if its FlushDelay is 0
+var DefaultFlushDelay = time.Second
+
+/*
+stores the replicated objects in a json file.
+
+Put and Delete change the objects in memory, the file is written once for all changes of the FlushDelay.
+Flush writes the pending changes at once, e.g. before the service stops. Changes that are lost,
+because the service stopped before, are sent again by the next initial sync.
+*/
+type FileCache struct {
+	Path       string
+	FlushDelay time.Duration // the time changes are collected before the file is written, DefaultFlushDelay if 0
+
+	mu      sync.Mutex
+	objects map[string]*CachedObject // the content of the file, nil until read
+	dirty   bool                     // objects has changes that are not written
+	timer   *time.Timer              // writes the changes, nil if no write is scheduled
+}
+
+func NewFileCache(path string) *FileCache {
+	return &FileCache{Path: path}
+}
+
+// the mutex has to be locked
+func (c *FileCache) read() (map[string]*CachedObject, error) {
+	if c.objects != nil {
+		return c.objects, nil
+	}
+	objects := map[string]*CachedObject{}
+	data, err := os.ReadFile(c.Path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &objects); err != nil {
+			return nil, fmt.Errorf("invalid cache file '%s': %v", c.Path, err)
+		}
+	}
+	c.objects = objects
+	return objects, nil
+}
+
+// the mutex has to be locked
+func (c *FileCache) write(objects map[string]*CachedObject) error {
+	data, err := json.Marshal(objects)
+	if err != nil {
+		return err
+	}
+	// replace the file at once, so it is never written partly
+	tmp, err := os.CreateTemp(filepath.Dir(c.Path), filepath.Base(c.Path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.Path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	c.objects = objects
+	c.dirty = false
+	return nil
+}
+
+// schedules the write of the changes, the mutex has to be locked
+func (c *FileCache) changed() {
+	c.dirty = true
+	if c.timer != nil {
+		return
+	}
+	delay := c.FlushDelay
+	if delay == 0 {
+		delay = DefaultFlushDelay
+	}
+	c.timer = time.AfterFunc(delay, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.timer = nil
+		if !c.dirty {
+			return
+		}
+		// the changes are written again with the next change or Flush
+		if err := c.write(c.objects); err != nil {
+			log.Errorf("PbxTableUsers: writing the cache file '%s' failed: %v", c.Path, err)
+		}
+	})
+}
+
+// stops a scheduled write, the mutex has to be locked
+func (c *FileCache) stopTimer() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+func (c *FileCache) List(ctx context.Context) (map[string]*CachedObject, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	objects, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	listcopy := make(map[string]*CachedObject, len(objects))
+	for guid, object := range objects {
+		listcopy[guid] = object
+	}
+	return listcopy, nil
+}
+
+func (c *FileCache) Put(ctx context.Context, guid string, object *CachedObject) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	objects, err := c.read()
+	if err != nil {
+		return err
+	}
+	objects[guid] = object
+	c.changed()
+	return nil
+}
+
+func (c *FileCache) Delete(ctx context.Context, guid string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	objects, err := c.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := objects[guid]; !ok {
+		return nil
+	}
+	delete(objects, guid)
+	c.changed()
+	return nil
+}
+
+// writes all objects at once, pending changes of Put and Delete are replaced
+func (c *FileCache) Replace(ctx context.Context, objects map[string]*CachedObject) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopTimer()
+	replaced := make(map[string]*CachedObject, len(objects))
+	for guid, object := range objects {
+		replaced[guid] = object
+	}
+	return c.write(replaced)
+}
+
+// writes the pending changes of Put and Delete
+func (c *FileCache) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopTimer()
+	if !c.dirty {
+		return nil
+	}
+	return c.write(c.objects)
+}
@@ -0,0 +1,49 @@
+package pbxtableusers_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ricoschulte/go-myapps/service/pbxtableusers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	ctx := context.Background()
+	cache := pbxtableusers.NewFileCache(path)
+	cache.FlushDelay = time.Hour
+
+	// the changes are collected until Flush
+	assert.NoError(t, cache.Put(ctx, "1", &pbxtableusers.CachedObject{ReplicatedObject: pbxtableusers.ReplicatedObject{Guid: "1", H323: "alice"}, Pbx: "example.com/pbx1"}))
+	assert.NoError(t, cache.Put(ctx, "2", &pbxtableusers.CachedObject{ReplicatedObject: pbxtableusers.ReplicatedObject{Guid: "2", H323: "bob"}}))
+	assert.NoError(t, cache.Delete(ctx, "2"))
+	objects, err := cache.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, objects, 1)
+	assert.NoFileExists(t, path)
+
+	assert.NoError(t, cache.Flush(ctx))
+	objects, err = pbxtableusers.NewFileCache(path).List(ctx)
+	assert.NoError(t, err)
+	if assert.Contains(t, objects, "1") {
+		assert.Equal(t, "alice", objects["1"].H323)
+		assert.Equal(t, "example.com/pbx1", objects["1"].Pbx)
+	}
+
+	// the changes are written after the FlushDelay
+	cache.FlushDelay = time.Millisecond
+	stat, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.NoError(t, cache.Delete(ctx, "1"))
+	assert.Eventually(t, func() bool {
+		objects, err := pbxtableusers.NewFileCache(path).List(ctx)
+		return err == nil && len(objects) == 0
+	}, time.Second, time.Millisecond)
+	changed, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.False(t, os.SameFile(stat, changed), "the file is replaced")
+}
@@ -0,0 +1,28 @@
+package pbxtableusers
+
+import (
+	"context"
+
+	"github.com/ricoschulte/go-myapps/service"
+)
+
+// adds the object to the PBX and waits for the result with the guid of the new object,
+// only the columns that are set and the named columns are sent
+func CallReplicateAdd(ctx context.Context, connection *service.AppServicePbxConnection, object *ReplicatedObject, columns ...string) (*ReplicateAddResult, error) {
+	return service.CallFor[ReplicateAddResult](ctx, connection, "PbxTableUsers", NewReplicateAdd(object, "", columns...))
+}
+
+// updates the named columns of the object on the PBX, or the columns that are set if none are named, and waits for the result
+func CallReplicateUpdate(ctx context.Context, connection *service.AppServicePbxConnection, object *ReplicatedObject, columns ...string) (*ReplicateUpdateResult, error) {
+	return service.CallFor[ReplicateUpdateResult](ctx, connection, "PbxTableUsers", NewReplicateUpdate(object, "", columns...))
+}
+
+// updates the columns of object that differ from current on the PBX and waits for the result
+func CallReplicateUpdateChanges(ctx context.Context, connection *service.AppServicePbxConnection, current, object *ReplicatedObject) (*ReplicateUpdateResult, error) {
+	return service.CallFor[ReplicateUpdateResult](ctx, connection, "PbxTableUsers", NewReplicateUpdateChanges(current, object, ""))
+}
+
+// deletes the object with the guid from the PBX and waits for the result
+func CallReplicateDel(ctx context.Context, connection *service.AppServicePbxConnection, guid string) (*ReplicateDelResult, error) {
+	return service.CallFor[ReplicateDelResult](ctx, connection, "PbxTableUsers", NewReplicateDel(guid, ""))
+}
@@ -0,0 +1,113 @@
+package pbxtableusers
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// the columns of an object written to the PBX by their json names, the columns not in it are not changed
+type Columns map[string]interface{}
+
+// returns the names of the columns, sorted
+func (columns Columns) Names() []string {
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// returns the columns of the object by their json names
+func objectColumns(object *ReplicatedObject) (map[string]interface{}, error) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]interface{}{}
+	if err := json.Unmarshal(data, &columns); err != nil {
+		return nil, err
+	}
+	return columns, nil
+}
+
+// the json types of the columns and of the columns of the nested tables
+var columnTypes = func() map[string]interface{} {
+	types, _ := objectColumns(&ReplicatedObject{
+		Emails: []Email{{}}, Allows: []Allow{{}}, Tallows: []Allow{{}}, Grps: []Grp{{}},
+		Devices: []Device{{}}, Cds: []Cd{{}}, Forks: []Fork{{}}, Wakeups: []Wakeup{{}},
+	})
+	return types
+}()
+
+// returns the columns of the object that are set, the ones that are not the zero value, and the named columns,
+// so a named column is sent with false, 0 or empty too. The rows of the nested tables are sent as they are.
+func SetColumns(object *ReplicatedObject, names ...string) Columns {
+	all, _ := objectColumns(object)
+	columns := Columns{}
+	for name, value := range all {
+		if !isZeroColumn(value) {
+			columns[name] = value
+		}
+	}
+	for _, name := range names {
+		if value, ok := all[name]; ok {
+			columns[name] = emptyTable(name, value)
+		}
+	}
+	return columns
+}
+
+// returns the named columns of the object and the guid
+func SelectColumns(object *ReplicatedObject, names ...string) Columns {
+	all, _ := objectColumns(object)
+	columns := Columns{"guid": all["guid"]}
+	for _, name := range names {
+		if value, ok := all[name]; ok {
+			columns[name] = emptyTable(name, value)
+		}
+	}
+	return columns
+}
+
+// returns the columns of object that differ from current and the guid of object
+func ChangedColumns(current, object *ReplicatedObject) Columns {
+	before, _ := objectColumns(current)
+	after, _ := objectColumns(object)
+	columns := Columns{"guid": after["guid"]}
+	for name, value := range after {
+		if name == "guid" {
+			continue
+		}
+		value = emptyTable(name, value)
+		if !reflect.DeepEqual(emptyTable(name, before[name]), value) {
+			columns[name] = value
+		}
+	}
+	return columns
+}
+
+func isZeroColumn(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// returns an empty list for a nested table without rows, so the rows on the PBX are removed
+func emptyTable(name string, value interface{}) interface{} {
+	if _, isTable := columnTypes[name].([]interface{}); isTable && value == nil {
+		return []interface{}{}
+	}
+	return value
+}
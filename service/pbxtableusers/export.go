@@ -0,0 +1,268 @@
+package pbxtableusers
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+the columns written by ExportCSV if none are given.
+
+A column is a column of TableUsers, like "h323", or a column of a nested table, like "grps.name".
+The values of a nested table are joined with ListSeparator. The name of a nested table alone,
+like "forks", writes the table as json.
+*/
+var DefaultCSVColumns = []string{"guid", "h323", "cn", "dn", "e164", "node", "loc", "emails.email", "grps.name"}
+
+// separates the values of a nested table in a CSV column
+var ListSeparator = ";"
+
+// the columns with secrets, like the password of a user. They are exported only with WithSecrets.
+var SecretColumns = []string{"pwd"}
+
+var ErrSecretColumn = errors.New("the column contains secrets, it is exported only with WithSecrets")
+
+type exportOptions struct {
+	secrets bool
+}
+
+type ExportOption func(options *exportOptions)
+
+// exports the SecretColumns too, e.g. for a backup that is stored safely
+func WithSecrets() ExportOption {
+	return func(options *exportOptions) {
+		options.secrets = true
+	}
+}
+
+func newExportOptions(options []ExportOption) *exportOptions {
+	o := &exportOptions{}
+	for _, option := range options {
+		option(o)
+	}
+	return o
+}
+
+func isSecretColumn(column string) bool {
+	table, _, _ := strings.Cut(column, ".")
+	for _, secret := range SecretColumns {
+		if table == secret {
+			return true
+		}
+	}
+	return false
+}
+
+// returns the objects sorted by guid, for a stable export
+func SortedObjects(objects map[string]ReplicatedObject) []ReplicatedObject {
+	sorted := make([]ReplicatedObject, 0, len(objects))
+	for _, object := range objects {
+		sorted = append(sorted, object)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Guid < sorted[j].Guid })
+	return sorted
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// returns the value of the column for CSV
+func csvValue(columns map[string]interface{}, column string) string {
+	table, field, nested := strings.Cut(column, ".")
+	if !nested {
+		return formatValue(columns[column])
+	}
+	rows, _ := columns[table].([]interface{})
+	values := []string{}
+	for _, row := range rows {
+		if row, ok := row.(map[string]interface{}); ok {
+			values = append(values, formatValue(row[field]))
+		}
+	}
+	return strings.Join(values, ListSeparator)
+}
+
+// writes the objects as CSV with a header line, see DefaultCSVColumns for the columns.
+// One of the SecretColumns fails with ErrSecretColumn, unless WithSecrets is given.
+func ExportCSV(w io.Writer, objects []ReplicatedObject, columns []string, options ...ExportOption) error {
+	if len(columns) == 0 {
+		columns = DefaultCSVColumns
+	}
+	if !newExportOptions(options).secrets {
+		for _, column := range columns {
+			if isSecretColumn(column) {
+				return fmt.Errorf("%w: %s", ErrSecretColumn, column)
+			}
+		}
+	}
+	writer := csv.NewWriter(w)
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	for i := range objects {
+		values, err := objectColumns(&objects[i])
+		if err != nil {
+			return err
+		}
+		record := make([]string, len(columns))
+		for c, column := range columns {
+			record[c] = csvValue(values, column)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writes every object as json on its own line. The SecretColumns are left out, unless WithSecrets is given.
+func ExportJSONL(w io.Writer, objects []ReplicatedObject, options ...ExportOption) error {
+	secrets := newExportOptions(options).secrets
+	encoder := json.NewEncoder(w)
+	for i := range objects {
+		if secrets {
+			if err := encoder.Encode(&objects[i]); err != nil {
+				return err
+			}
+			continue
+		}
+		columns, err := objectColumns(&objects[i])
+		if err != nil {
+			return err
+		}
+		for _, secret := range SecretColumns {
+			delete(columns, secret)
+		}
+		if err := encoder.Encode(columns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+writes the objects as inetOrgPerson entries in LDIF, below the baseDN, e.g. "ou=users,dc=example,dc=com".
+
+The dn of an entry is made of the cn, or of the h323 name for objects without cn.
+*/
+func ExportLDIF(w io.Writer, objects []ReplicatedObject, baseDN string) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintln(out, "version: 1")
+	for _, object := range objects {
+		rdn := "cn=" + escapeDNValue(object.Cn)
+		if object.Cn == "" {
+			rdn = "uid=" + escapeDNValue(object.H323)
+		}
+		dn := rdn
+		if baseDN != "" {
+			dn += "," + baseDN
+		}
+		fmt.Fprintln(out)
+		writeLDIFAttr(out, "dn", dn)
+		for _, class := range []string{"top", "person", "organizationalPerson", "inetOrgPerson"} {
+			writeLDIFAttr(out, "objectClass", class)
+		}
+		cn := object.Cn
+		if cn == "" {
+			cn = object.H323
+		}
+		writeLDIFAttr(out, "cn", cn)
+		// sn is required by person
+		writeLDIFAttr(out, "sn", surname(&object))
+		writeLDIFAttr(out, "uid", object.H323)
+		writeLDIFAttr(out, "displayName", object.Dn)
+		writeLDIFAttr(out, "telephoneNumber", object.E164)
+		for _, email := range object.Emails {
+			writeLDIFAttr(out, "mail", email.Email)
+		}
+		for _, grp := range object.Grps {
+			writeLDIFAttr(out, "ou", grp.Name)
+		}
+		writeLDIFAttr(out, "l", object.Loc)
+		writeLDIFAttr(out, "description", object.Guid)
+	}
+	return out.Flush()
+}
+
+// returns the surname of the display name "Surname, Givenname", or the cn
+func surname(object *ReplicatedObject) string {
+	if name, _, ok := strings.Cut(object.Dn, ","); ok && strings.TrimSpace(name) != "" {
+		return strings.TrimSpace(name)
+	}
+	if object.Cn != "" {
+		return object.Cn
+	}
+	return object.H323
+}
+
+// writes the attribute, base64 encoded if the value is not a safe string. Empty values are left out.
+func writeLDIFAttr(out *bufio.Writer, name, value string) {
+	if value == "" {
+		return
+	}
+	if ldifSafe(value) {
+		fmt.Fprintf(out, "%s: %s\n", name, value)
+	} else {
+		fmt.Fprintf(out, "%s:: %s\n", name, base64.StdEncoding.EncodeToString([]byte(value)))
+	}
+}
+
+// a SAFE-STRING of RFC 2849
+func ldifSafe(value string) bool {
+	if !utf8.ValidString(value) {
+		return false
+	}
+	switch value[0] {
+	case ' ', ':', '<':
+		return false
+	}
+	if value[len(value)-1] == ' ' {
+		return false
+	}
+	for _, r := range value {
+		if r == 0 || r == '\n' || r == '\r' || r > 127 {
+			return false
+		}
+	}
+	return true
+}
+
+// escapes the value of a relative distinguished name, RFC 4514
+func escapeDNValue(value string) string {
+	var b strings.Builder
+	for i, r := range value {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r):
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case (i == 0 && (r == ' ' || r == '#')) || (i == len(value)-1 && r == ' '):
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
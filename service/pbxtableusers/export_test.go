@@ -0,0 +1,194 @@
+package pbxtableusers_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/pbxtableusers"
+	"github.com/ricoschulte/go-myapps/service/servicetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func exportObjects() []pbxtableusers.ReplicatedObject {
+	return []pbxtableusers.ReplicatedObject{
+		{Guid: "1", H323: "alice", Cn: "Alice Smith", Dn: "Smith, Alice", E164: "10", Fax: true,
+			Emails: []pbxtableusers.Email{{Email: "alice@example.com"}, {Email: "a.smith@example.com"}},
+			Grps:   []pbxtableusers.Grp{{Name: "Sales"}, {Name: "Support", Mode: "active"}},
+			Forks:  []pbxtableusers.Fork{{E164: "0170", Delay: 10}}},
+		{Guid: "2", H323: "jürgen", Cn: "Jürgen, Jr.", E164: "20"},
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns []string
+		want    string
+	}{
+		{
+			name: "default columns",
+			want: "guid,h323,cn,dn,e164,node,loc,emails.email,grps.name\n" +
+				"1,alice,Alice Smith,\"Smith, Alice\",10,,,alice@example.com;a.smith@example.com,Sales;Support\n" +
+				"2,jürgen,\"Jürgen, Jr.\",,20,,,,\n",
+		},
+		{
+			name:    "nested tables",
+			columns: []string{"h323", "fax", "grps.mode", "forks"},
+			want: "h323,fax,grps.mode,forks\n" +
+				"alice,true,;active,\"[{\"\"app\"\":\"\"\"\",\"\"bool\"\":\"\"\"\",\"\"bool-not\"\":false,\"\"cw\"\":false,\"\"delay\"\":10,\"\"e164\"\":\"\"0170\"\",\"\"h323\"\":\"\"\"\",\"\"hw\"\":\"\"\"\",\"\"max\"\":0,\"\"min\"\":0,\"\"mobility\"\":\"\"\"\",\"\"off\"\":false}]\"\n" +
+				"jürgen,false,,\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			assert.NoError(t, pbxtableusers.ExportCSV(out, exportObjects(), test.columns))
+			assert.Equal(t, test.want, out.String())
+		})
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	csvOut := &bytes.Buffer{}
+	assert.NoError(t, pbxtableusers.ExportCSV(csvOut, exportObjects(), []string{"guid", "h323", "cn", "dn", "e164", "fax", "emails.email", "grps.name", "grps.mode", "forks"}))
+	records, err := pbxtableusers.ReadCSV(csvOut)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, []string{"guid", "h323", "cn", "dn", "e164", "fax", "emails", "grps", "forks"}, records[0].Columns)
+		assert.Equal(t, exportObjects()[0].Emails, records[0].Object.Emails)
+		assert.Equal(t, exportObjects()[0].Grps, records[0].Object.Grps)
+		assert.Equal(t, exportObjects()[0].Forks, records[0].Object.Forks)
+		assert.True(t, records[0].Object.Fax)
+	}
+
+	jsonOut := &bytes.Buffer{}
+	assert.NoError(t, pbxtableusers.ExportJSONL(jsonOut, exportObjects()))
+	assert.Equal(t, 2, strings.Count(jsonOut.String(), "\n"))
+	records, err = pbxtableusers.ReadJSONL(jsonOut)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, exportObjects()[1], records[1].Object)
+	}
+
+	_, err = pbxtableusers.ReadCSV(strings.NewReader("h323,unknown\nalice,x\n"))
+	assert.Error(t, err)
+}
+
+func TestExportSecrets(t *testing.T) {
+	objects := []pbxtableusers.ReplicatedObject{{Guid: "1", H323: "alice", Pwd: "s3cret"}}
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, pbxtableusers.ExportJSONL(out, objects))
+	assert.NotContains(t, out.String(), "pwd")
+	assert.Contains(t, out.String(), `"h323":"alice"`)
+
+	out.Reset()
+	assert.NoError(t, pbxtableusers.ExportJSONL(out, objects, pbxtableusers.WithSecrets()))
+	assert.Contains(t, out.String(), `"pwd":"s3cret"`)
+
+	out.Reset()
+	assert.ErrorIs(t, pbxtableusers.ExportCSV(out, objects, []string{"h323", "pwd"}), pbxtableusers.ErrSecretColumn)
+	assert.Empty(t, out.String())
+	assert.NoError(t, pbxtableusers.ExportCSV(out, objects, []string{"h323", "pwd"}, pbxtableusers.WithSecrets()))
+	assert.Equal(t, "h323,pwd\nalice,s3cret\n", out.String())
+}
+
+func TestExportLDIF(t *testing.T) {
+	out := &bytes.Buffer{}
+	assert.NoError(t, pbxtableusers.ExportLDIF(out, exportObjects(), "ou=users,dc=example,dc=com"))
+	ldif := out.String()
+	assert.True(t, strings.HasPrefix(ldif, "version: 1\n\ndn: cn=Alice Smith,ou=users,dc=example,dc=com\n"))
+	assert.Contains(t, ldif, "objectClass: inetOrgPerson\n")
+	assert.Contains(t, ldif, "sn: Smith\n")
+	assert.Contains(t, ldif, "mail: alice@example.com\nmail: a.smith@example.com\n")
+	assert.Contains(t, ldif, "telephoneNumber: 10\n")
+	// not ascii values are base64 encoded, the comma of the cn is escaped in the dn
+	assert.Contains(t, ldif, "dn:: Y249SsO8cmdlblwsIEpyLixvdT11c2VycyxkYz1leGFtcGxlLGRjPWNvbQ==\n")
+	assert.Contains(t, ldif, "uid:: asO8cmdlbg==\n")
+}
+
+func TestImporter(t *testing.T) {
+	requests := make(chan map[string]interface{}, 10)
+	connection := servicetest.NewConnection(t, func(peer *websocket.Conn, message map[string]interface{}) {
+		requests <- message
+		peer.WriteJSON(map[string]interface{}{"api": "PbxTableUsers", "mt": message["mt"].(string) + "Result", "src": message["src"], "guid": "new"})
+	})
+	connection.PbxInfo = service.PbxInfo{Domain: "example.com", Pbx: "pbx1"}
+	connection.Authenticated = true
+	go connection.Loop()
+	other := servicetest.NewConnection(t, nil)
+	other.PbxInfo = service.PbxInfo{Domain: "example.com", Pbx: "pbx2"}
+
+	api := pbxtableusers.NewPbxTableUsers()
+	add := func(connection *service.AppServicePbxConnection, columns string) {
+		api.HandleMessage(connection, &service.BaseMessage{Api: "PbxTableUsers", Mt: "ReplicateAdd"}, []byte(`{"mt":"ReplicateAdd","api":"PbxTableUsers","columns":`+columns+`}`))
+	}
+	for _, columns := range []string{`{"guid":"1","h323":"alice","cn":"Alice","e164":"10"}`, `{"guid":"2","h323":"bob","cn":"Bob","e164":"20"}`, `{"guid":"3","h323":"carol","cn":"Carol"}`, `{"guid":"4","h323":"waiting","pseudo":"<pseudo type=\"waiting\"/>"}`} {
+		add(connection, columns)
+	}
+	// the users of another PBX are neither matched nor deleted
+	add(other, `{"guid":"5","h323":"erin","cn":"Erin"}`)
+	add(other, `{"guid":"6","h323":"dave","cn":"Dave"}`)
+	records, err := pbxtableusers.ReadCSV(strings.NewReader("h323,e164\nalice,10\nbob,21\ndave,40\n"))
+	assert.NoError(t, err)
+
+	importer := pbxtableusers.NewImporter(api)
+	importer.Delete = true
+	importer.DryRun = true
+	_, err = importer.Plan(records)
+	assert.ErrorIs(t, err, pbxtableusers.ErrImportPbxMissing)
+
+	changes, err := importer.Import(context.Background(), connection, records)
+	assert.NoError(t, err)
+	actions := map[string]pbxtableusers.ImportAction{}
+	for _, change := range changes {
+		actions[change.Object.H323+change.Object.Guid] = change.Action
+	}
+	// the waiting queue is not a user, it is not deleted
+	assert.Equal(t, map[string]pbxtableusers.ImportAction{"bob2": pbxtableusers.ImportUpdate, "dave": pbxtableusers.ImportAdd, "3": pbxtableusers.ImportDelete}, actions)
+	assert.Empty(t, requests)
+
+	importer.Pbx = pbxtableusers.PbxKey(other)
+	otherChanges, err := importer.Plan(records)
+	assert.NoError(t, err)
+	actions = map[string]pbxtableusers.ImportAction{}
+	for _, change := range otherChanges {
+		actions[change.Object.H323+change.Object.Guid] = change.Action
+	}
+	assert.Equal(t, map[string]pbxtableusers.ImportAction{"alice": pbxtableusers.ImportAdd, "bob": pbxtableusers.ImportAdd, "dave6": pbxtableusers.ImportUpdate, "5": pbxtableusers.ImportDelete}, actions)
+	importer.Pbx = ""
+
+	// the update keeps the columns not in the record
+	for _, change := range changes {
+		if change.Action == pbxtableusers.ImportUpdate {
+			assert.Equal(t, "Bob", change.Object.Cn)
+			assert.Equal(t, "21", change.Object.E164)
+		}
+	}
+
+	importer.DryRun = false
+	changes, err = importer.Import(context.Background(), connection, records)
+	assert.NoError(t, err)
+	mts := []string{}
+	for range changes {
+		request := <-requests
+		mts = append(mts, request["mt"].(string))
+		switch request["mt"] {
+		case "ReplicateUpdate":
+			assert.Equal(t, map[string]interface{}{"guid": "2", "e164": "21"}, request["columns"], "only the changed columns are updated")
+		case "ReplicateAdd":
+			assert.Equal(t, map[string]interface{}{"h323": "dave", "e164": "40"}, request["columns"])
+		}
+	}
+	assert.ElementsMatch(t, []string{"ReplicateUpdate", "ReplicateAdd", "ReplicateDel"}, mts)
+	for _, change := range changes {
+		assert.NoError(t, change.Err)
+		if change.Action == pbxtableusers.ImportAdd {
+			assert.Equal(t, "new", change.Object.Guid)
+		}
+	}
+}
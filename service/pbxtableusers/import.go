@@ -0,0 +1,333 @@
+package pbxtableusers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ricoschulte/go-myapps/service"
+)
+
+// an object read by ReadCSV or ReadJSONL, with the columns it contains
+type ImportRecord struct {
+	Object  ReplicatedObject
+	Columns []string // the columns of TableUsers and the nested tables that are set by the record
+}
+
+// the columns of the record by their json names
+func (record *ImportRecord) values() (map[string]interface{}, error) {
+	all, err := objectColumns(&record.Object)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]interface{}{}
+	for _, column := range record.Columns {
+		values[column] = all[column]
+	}
+	return values, nil
+}
+
+func parseValue(kind interface{}, value string) (interface{}, error) {
+	switch kind.(type) {
+	case bool:
+		if value == "" {
+			return false, nil
+		}
+		return strconv.ParseBool(value)
+	case float64:
+		if value == "" {
+			return float64(0), nil
+		}
+		return strconv.ParseFloat(value, 64)
+	}
+	return value, nil
+}
+
+// reads objects written by ExportCSV, the first line has the columns
+func ReadCSV(r io.Reader) ([]ImportRecord, error) {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading the header failed: %v", err)
+	}
+	records := []ImportRecord{}
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		values := map[string]interface{}{}
+		columns := []string{}
+		for c, column := range header {
+			table, field, nested := strings.Cut(column, ".")
+			if _, ok := columnTypes[table]; !ok {
+				return nil, fmt.Errorf("unknown column '%s'", column)
+			}
+			if _, ok := values[table]; !ok {
+				columns = append(columns, table)
+			}
+			value, err := parseCSVColumn(table, field, nested, row[c], values[table])
+			if err != nil {
+				return nil, fmt.Errorf("line %d, column '%s': %v", line, column, err)
+			}
+			values[table] = value
+		}
+		record := ImportRecord{Columns: columns}
+		data, _ := json.Marshal(values)
+		if err := json.Unmarshal(data, &record.Object); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, record)
+	}
+}
+
+// returns the value of the column of TableUsers or the rows of the nested table with the field set
+func parseCSVColumn(table, field string, nested bool, value string, current interface{}) (interface{}, error) {
+	kind := columnTypes[table]
+	rows, isTable := kind.([]interface{})
+	if !isTable {
+		return parseValue(kind, value)
+	}
+	if !nested {
+		parsed := []interface{}{}
+		if value != "" {
+			if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+				return nil, err
+			}
+		}
+		return parsed, nil
+	}
+	fieldKind, ok := rows[0].(map[string]interface{})[field]
+	if !ok {
+		return nil, fmt.Errorf("unknown field '%s'", field)
+	}
+	result, _ := current.([]interface{})
+	if value == "" {
+		if result == nil {
+			result = []interface{}{}
+		}
+		return result, nil
+	}
+	for i, item := range strings.Split(value, ListSeparator) {
+		parsed, err := parseValue(fieldKind, item)
+		if err != nil {
+			return nil, err
+		}
+		if i >= len(result) {
+			result = append(result, map[string]interface{}{})
+		}
+		result[i].(map[string]interface{})[field] = parsed
+	}
+	return result, nil
+}
+
+// reads objects written by ExportJSONL, the columns of a record are the ones in its line
+func ReadJSONL(r io.Reader) ([]ImportRecord, error) {
+	records := []ImportRecord{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := scanner.Bytes()
+		if strings.TrimSpace(string(data)) == "" {
+			continue
+		}
+		values := map[string]json.RawMessage{}
+		if err := json.Unmarshal(data, &values); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		record := ImportRecord{}
+		for column := range values {
+			if _, ok := columnTypes[column]; !ok {
+				return nil, fmt.Errorf("line %d: unknown column '%s'", line, column)
+			}
+			record.Columns = append(record.Columns, column)
+		}
+		if err := json.Unmarshal(data, &record.Object); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+type ImportAction string
+
+const (
+	ImportAdd    ImportAction = "add"
+	ImportUpdate ImportAction = "update"
+	ImportDelete ImportAction = "delete"
+)
+
+// a change of an import
+type ImportChange struct {
+	Action  ImportAction
+	Object  *ReplicatedObject // the object written to the PBX
+	Current *ReplicatedObject // the replicated object, nil for ImportAdd
+	Err     error             // the error of the write, set by Apply
+}
+
+// computes the changes of imported objects against a replica and writes them to the PBX
+type Importer struct {
+	Api    *PbxTableUsers
+	Key    string // the column the records and the replicated objects are matched with: "guid", IndexH323 (default), IndexE164 or IndexCn
+	Delete bool   // deletes the users, objects of PseudoTypeUser, of the Pbx that are not in the records
+	DryRun bool   // Import returns the changes without writing them
+	// the PbxKey of the PBX the records are imported to, only its objects are matched and deleted.
+	// Import uses the PBX of the connection if empty, Plan matches the objects of all PBXs then and does not delete.
+	Pbx string
+}
+
+var ErrImportPbxMissing = errors.New("deleting needs the Pbx of the import")
+
+func NewImporter(api *PbxTableUsers) *Importer {
+	return &Importer{Api: api, Key: IndexH323}
+}
+
+// returns true if the object belongs to the PBX of the import
+func (im *Importer) inPbx(pbx string, object *ReplicatedObject) bool {
+	return pbx == "" || im.Api.GetPbxOfObject(object.Guid) == pbx
+}
+
+// returns the replicated object of the PBX with the key of the record
+func (im *Importer) match(record *ImportRecord, pbx string) (*ReplicatedObject, error) {
+	key := im.Key
+	if key == "" {
+		key = IndexH323
+	}
+	values, err := objectColumns(&record.Object)
+	if err != nil {
+		return nil, err
+	}
+	value := formatValue(values[key])
+	if value == "" {
+		return nil, fmt.Errorf("the record has no %s", key)
+	}
+	switch key {
+	case "guid":
+		if object, ok := im.Api.GetByGuid(value); ok && im.inPbx(pbx, &object) {
+			return &object, nil
+		}
+		return nil, nil
+	case IndexH323, IndexE164, IndexCn:
+		objects := []ReplicatedObject{}
+		for _, object := range im.Api.Query(key, value) {
+			if im.inPbx(pbx, &object) {
+				objects = append(objects, object)
+			}
+		}
+		if len(objects) > 1 {
+			return nil, fmt.Errorf("%d objects with %s '%s'", len(objects), key, value)
+		}
+		if len(objects) == 1 {
+			return &objects[0], nil
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("the key '%s' is not supported", key)
+}
+
+// returns the changes to make the objects of the Pbx match the records
+func (im *Importer) Plan(records []ImportRecord) ([]ImportChange, error) {
+	return im.plan(records, im.Pbx)
+}
+
+func (im *Importer) plan(records []ImportRecord, pbx string) ([]ImportChange, error) {
+	if im.Delete && pbx == "" {
+		return nil, ErrImportPbxMissing
+	}
+	changes := []ImportChange{}
+	matched := map[string]bool{}
+	for i := range records {
+		record := &records[i]
+		current, err := im.match(record, pbx)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %v", i+1, err)
+		}
+		if current == nil {
+			object := record.Object
+			changes = append(changes, ImportChange{Action: ImportAdd, Object: &object})
+			continue
+		}
+		matched[current.Guid] = true
+
+		// the columns of the record replace the columns of the replicated object
+		merged, err := objectColumns(current)
+		if err != nil {
+			return nil, err
+		}
+		values, err := record.values()
+		if err != nil {
+			return nil, err
+		}
+		for column, value := range values {
+			merged[column] = value
+		}
+		merged["guid"] = current.Guid
+		data, _ := json.Marshal(merged)
+		object := ReplicatedObject{}
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, fmt.Errorf("record %d: %v", i+1, err)
+		}
+		if !sameObject(*current, object) {
+			changes = append(changes, ImportChange{Action: ImportUpdate, Object: &object, Current: current})
+		}
+	}
+	if im.Delete {
+		for _, current := range im.Api.GetByPseudoType(PseudoTypeUser) {
+			if !matched[current.Guid] && im.inPbx(pbx, &current) {
+				current := current
+				changes = append(changes, ImportChange{Action: ImportDelete, Object: &ReplicatedObject{Guid: current.Guid}, Current: &current})
+			}
+		}
+	}
+	return changes, nil
+}
+
+// writes the changes with the write api, the error of every change is set to its Err
+func (im *Importer) Apply(ctx context.Context, connection *service.AppServicePbxConnection, changes []ImportChange) error {
+	failed := 0
+	for i := range changes {
+		change := &changes[i]
+		switch change.Action {
+		case ImportAdd:
+			var result *ReplicateAddResult
+			result, change.Err = CallReplicateAdd(ctx, connection, change.Object)
+			if change.Err == nil && result.Guid != "" {
+				change.Object.Guid = result.Guid
+			}
+		case ImportUpdate:
+			_, change.Err = CallReplicateUpdateChanges(ctx, connection, change.Current, change.Object)
+		case ImportDelete:
+			_, change.Err = CallReplicateDel(ctx, connection, change.Object.Guid)
+		}
+		if change.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d changes failed", failed, len(changes))
+	}
+	return nil
+}
+
+// computes the changes of the records and writes them to the PBX of the connection, unless DryRun is set
+func (im *Importer) Import(ctx context.Context, connection *service.AppServicePbxConnection, records []ImportRecord) ([]ImportChange, error) {
+	pbx := im.Pbx
+	if pbx == "" {
+		pbx = PbxKey(connection)
+	}
+	changes, err := im.plan(records, pbx)
+	if err != nil || im.DryRun {
+		return changes, err
+	}
+	return changes, im.Apply(ctx, connection, changes)
+}
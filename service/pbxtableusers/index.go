@@ -0,0 +1,239 @@
+package pbxtableusers
+
+import (
+	"sort"
+	"strings"
+)
+
+// the secondary indexes of the replicated objects
+const (
+	IndexH323       = "h323" // case insensitive
+	IndexE164       = "e164"
+	IndexCn         = "cn"
+	IndexEmail      = "email" // case insensitive
+	IndexGrp        = "grp"   // the names of the groups
+	IndexHw         = "hw"    // the hardware ids of the devices
+	IndexNode       = "node"
+	IndexLoc        = "loc"
+	IndexPseudoType = "pseudo" // the type of GetPseudoType, PseudoTypeUser for users
+)
+
+// the indexes maintained for Query
+var Indexes = []string{IndexH323, IndexE164, IndexCn, IndexEmail, IndexGrp, IndexHw, IndexNode, IndexLoc, IndexPseudoType}
+
+// the guids of the objects by index and key
+type indexes map[string]map[string]map[string]bool
+
+// returns the keys of the object in the index
+func indexKeys(index string, object *ReplicatedObject) []string {
+	switch index {
+	case IndexH323:
+		return []string{strings.ToLower(object.H323)}
+	case IndexE164:
+		return []string{object.E164}
+	case IndexCn:
+		return []string{object.Cn}
+	case IndexEmail:
+		keys := []string{}
+		for _, email := range object.Emails {
+			keys = append(keys, strings.ToLower(email.Email))
+		}
+		return keys
+	case IndexGrp:
+		keys := []string{}
+		for _, grp := range object.Grps {
+			keys = append(keys, grp.Name)
+		}
+		return keys
+	case IndexHw:
+		keys := []string{}
+		for _, device := range object.Devices {
+			keys = append(keys, device.Hw)
+		}
+		return keys
+	case IndexNode:
+		return []string{object.Node}
+	case IndexLoc:
+		return []string{object.Loc}
+	case IndexPseudoType:
+		pseudoType, err := object.GetPseudoType()
+		if err != nil {
+			return nil
+		}
+		return []string{pseudoType}
+	}
+	return nil
+}
+
+// returns the key of the value in the index, like the keys of indexKeys
+func indexKey(index, value string) string {
+	switch index {
+	case IndexH323, IndexEmail:
+		return strings.ToLower(value)
+	}
+	return value
+}
+
+func (idx indexes) add(object *ReplicatedObject) {
+	for _, index := range Indexes {
+		for _, key := range indexKeys(index, object) {
+			if key == "" {
+				continue
+			}
+			if idx[index] == nil {
+				idx[index] = map[string]map[string]bool{}
+			}
+			if idx[index][key] == nil {
+				idx[index][key] = map[string]bool{}
+			}
+			idx[index][key][object.Guid] = true
+		}
+	}
+}
+
+func (idx indexes) remove(object *ReplicatedObject) {
+	for _, index := range Indexes {
+		for _, key := range indexKeys(index, object) {
+			guids := idx[index][key]
+			delete(guids, object.Guid)
+			if len(guids) == 0 {
+				delete(idx[index], key)
+			}
+		}
+	}
+}
+
+// the ReplicatedObjectsMutex has to be locked
+func (api *PbxTableUsers) putObject(object ReplicatedObject) {
+	if api.indexes == nil {
+		api.rebuildIndexes()
+	}
+	if current, ok := api.ReplicatedObjects[object.Guid]; ok {
+		api.indexes.remove(&current)
+	}
+	api.ReplicatedObjects[object.Guid] = object
+	api.indexes.add(&object)
+}
+
+// the ReplicatedObjectsMutex has to be locked
+func (api *PbxTableUsers) removeObject(guid string) {
+	if api.indexes == nil {
+		api.rebuildIndexes()
+	}
+	if current, ok := api.ReplicatedObjects[guid]; ok {
+		api.indexes.remove(&current)
+		delete(api.ReplicatedObjects, guid)
+	}
+	delete(api.sources, guid)
+}
+
+// the ReplicatedObjectsMutex has to be locked
+func (api *PbxTableUsers) rebuildIndexes() {
+	api.indexes = indexes{}
+	for _, object := range api.ReplicatedObjects {
+		object := object
+		api.indexes.add(&object)
+	}
+}
+
+/*
+returns the objects with the value in the index, sorted by guid. The objects are copies.
+
+The indexes are maintained for the changes received from the PBX. After changing
+ReplicatedObjects directly, RebuildIndexes has to be called.
+*/
+func (api *PbxTableUsers) Query(index, value string) []ReplicatedObject {
+	api.ReplicatedObjectsMutex.RLock()
+	if api.indexes != nil {
+		defer api.ReplicatedObjectsMutex.RUnlock()
+	} else {
+		api.ReplicatedObjectsMutex.RUnlock()
+		api.ReplicatedObjectsMutex.Lock()
+		defer api.ReplicatedObjectsMutex.Unlock()
+		if api.indexes == nil {
+			api.rebuildIndexes()
+		}
+	}
+	guids := api.indexes[index][indexKey(index, value)]
+	objects := make([]ReplicatedObject, 0, len(guids))
+	for guid := range guids {
+		objects = append(objects, api.ReplicatedObjects[guid])
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Guid < objects[j].Guid })
+	return objects
+}
+
+// builds the indexes from ReplicatedObjects
+func (api *PbxTableUsers) RebuildIndexes() {
+	api.ReplicatedObjectsMutex.Lock()
+	defer api.ReplicatedObjectsMutex.Unlock()
+	api.rebuildIndexes()
+}
+
+func (api *PbxTableUsers) first(index, value string) (ReplicatedObject, bool) {
+	objects := api.Query(index, value)
+	if len(objects) == 0 {
+		return ReplicatedObject{}, false
+	}
+	return objects[0], true
+}
+
+// returns the object with the guid
+func (api *PbxTableUsers) GetByGuid(guid string) (ReplicatedObject, bool) {
+	api.ReplicatedObjectsMutex.RLock()
+	defer api.ReplicatedObjectsMutex.RUnlock()
+	object, ok := api.ReplicatedObjects[guid]
+	return object, ok
+}
+
+// returns the object with the username, case insensitive
+func (api *PbxTableUsers) GetByH323(h323 string) (ReplicatedObject, bool) {
+	return api.first(IndexH323, h323)
+}
+
+// returns the object with the email, case insensitive
+func (api *PbxTableUsers) GetByEmail(email string) (ReplicatedObject, bool) {
+	return api.first(IndexEmail, email)
+}
+
+// returns the objects with the phone number, the number is unique per node only
+func (api *PbxTableUsers) GetByE164(e164 string) []ReplicatedObject {
+	return api.Query(IndexE164, e164)
+}
+
+// returns the object with the phone number on the node
+func (api *PbxTableUsers) GetByE164OnNode(e164, node string) (ReplicatedObject, bool) {
+	for _, object := range api.Query(IndexE164, e164) {
+		if object.Node == node {
+			return object, true
+		}
+	}
+	return ReplicatedObject{}, false
+}
+
+func (api *PbxTableUsers) GetByCn(cn string) []ReplicatedObject {
+	return api.Query(IndexCn, cn)
+}
+
+// returns the members of the group
+func (api *PbxTableUsers) GetByGrp(name string) []ReplicatedObject {
+	return api.Query(IndexGrp, name)
+}
+
+// returns the objects with a device with the hardware id
+func (api *PbxTableUsers) GetByHw(hw string) []ReplicatedObject {
+	return api.Query(IndexHw, hw)
+}
+
+func (api *PbxTableUsers) GetByNode(node string) []ReplicatedObject {
+	return api.Query(IndexNode, node)
+}
+
+func (api *PbxTableUsers) GetByLoc(loc string) []ReplicatedObject {
+	return api.Query(IndexLoc, loc)
+}
+
+// returns the objects of the pseudo type, PseudoTypeUser for the users
+func (api *PbxTableUsers) GetByPseudoType(pseudoType string) []ReplicatedObject {
+	return api.Query(IndexPseudoType, pseudoType)
+}
@@ -0,0 +1,604 @@
+package pbxtableusers
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// an element of pseudo XML, for the elements that are not modelled by a struct
+type PseudoElement struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Content  string          `xml:",chardata"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// returns the value of the attribute
+func (e *PseudoElement) Attr(name string) string {
+	return getAttr(e.Attrs, name)
+}
+
+// sets the value of the attribute, it is added if it does not exist
+func (e *PseudoElement) SetAttr(name, value string) {
+	e.Attrs = setAttr(e.Attrs, name, value)
+}
+
+// returns the first child element with the name
+func (e *PseudoElement) Element(name string) *PseudoElement {
+	return findElement(e.Elements, name)
+}
+
+/*
+the pseudo element of an object, with the type and the content not modelled by the typed struct.
+
+The attributes and elements are kept, so an object unmarshalled and marshalled again
+has the same content.
+*/
+type PseudoBase struct {
+	XMLName  xml.Name        `xml:"pseudo"`
+	Type     string          `xml:"type,attr"`
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// the typed pseudo information of an object, see ReplicatedObject.PseudoObject
+type PseudoObject interface {
+	GetType() string
+	Base() *PseudoBase
+}
+
+func (p *PseudoBase) GetType() string {
+	return p.Type
+}
+
+func (p *PseudoBase) Base() *PseudoBase {
+	return p
+}
+
+// returns the value of the attribute of the pseudo element
+func (p *PseudoBase) Attr(name string) string {
+	return getAttr(p.Attrs, name)
+}
+
+// sets the value of the attribute of the pseudo element, it is added if it does not exist
+func (p *PseudoBase) SetAttr(name, value string) {
+	p.Attrs = setAttr(p.Attrs, name, value)
+}
+
+// returns the first child element with the name
+func (p *PseudoBase) Element(name string) *PseudoElement {
+	return findElement(p.Elements, name)
+}
+
+func getAttr(attrs []xml.Attr, name string) string {
+	for _, attr := range attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func setAttr(attrs []xml.Attr, name, value string) []xml.Attr {
+	for i := range attrs {
+		if attrs[i].Name.Local == name {
+			attrs[i].Value = value
+			return attrs
+		}
+	}
+	return append(attrs, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+}
+
+func findElement(elements []PseudoElement, name string) *PseudoElement {
+	for i := range elements {
+		if elements[i].XMLName.Local == name {
+			return &elements[i]
+		}
+	}
+	return nil
+}
+
+// an app object
+type PseudoApp struct {
+	PseudoBase
+	App *PseudoTypeXmlApp `xml:"app"`
+}
+
+// an access point of a DECT system
+type PseudoAp struct {
+	PseudoBase
+	Ap *PseudoApSettings `xml:"ap"`
+}
+
+type PseudoApSettings struct {
+	Dect     string          `xml:"dect,attr,omitempty"` // the h323 name of the DECT system
+	Hw       string          `xml:"hw,attr,omitempty"`   // the hardware id of the access point
+	Attrs    []xml.Attr      `xml:",any,attr"`           // the attributes not modelled above
+	Elements []PseudoElement `xml:",any"`                // the elements not modelled above
+}
+
+// a broadcast conference, the members of the group are called into a conference
+type PseudoBcConference struct {
+	PseudoBase
+	BcConference *PseudoBcConferenceSettings `xml:"bc_conf"`
+}
+
+type PseudoBcConferenceSettings struct {
+	Group    string          `xml:"group,attr,omitempty"`    // the group of the members
+	Pin      string          `xml:"pin,attr,omitempty"`      // the pin to join the conference
+	Channels int             `xml:"channels,attr,omitempty"` // the maximum number of participants
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a broadcast group, a call is offered to all members of the group
+type PseudoBroadcast struct {
+	PseudoBase
+	Broadcast *PseudoBroadcastSettings `xml:"broadcast"`
+}
+
+type PseudoBroadcastSettings struct {
+	Group    string          `xml:"group,attr,omitempty"`   // the group of the members
+	Timeout  int             `xml:"timeout,attr,omitempty"` // the seconds the call is offered
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a conference
+type PseudoConference struct {
+	PseudoBase
+	Conference *PseudoConferenceSettings `xml:"conference"`
+}
+
+type PseudoConferenceSettings struct {
+	Channels int             `xml:"channels,attr,omitempty"` // the maximum number of participants
+	Pin      string          `xml:"pin,attr,omitempty"`      // the pin to join the conference
+	Ann      string          `xml:"ann,attr,omitempty"`      // the url of the announcement
+	Moh      string          `xml:"moh,attr,omitempty"`      // the url of the music on hold while alone in the conference
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a config template, its settings are the columns of the object like grps and apps, the pseudo element has the type only
+type PseudoConfig struct {
+	PseudoBase
+}
+
+// a DECT system
+type PseudoDect struct {
+	PseudoBase
+	Dect *PseudoDectSettings `xml:"dect"`
+}
+
+type PseudoDectSettings struct {
+	Park     string          `xml:"park,attr,omitempty"` // the PARK of the system
+	Ari      string          `xml:"ari,attr,omitempty"`  // the ARI of the system
+	Ac       string          `xml:"ac,attr,omitempty"`   // the authentication code for the subscription of handsets
+	Subs     bool            `xml:"subs,attr,omitempty"` // handsets can subscribe
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a directory search in an LDAP object
+type PseudoDirsearch struct {
+	PseudoBase
+	Dirsearch *PseudoDirsearchSettings `xml:"dirsearch"`
+}
+
+type PseudoDirsearchSettings struct {
+	Ldap     string          `xml:"ldap,attr,omitempty"`   // the h323 name of the LDAP object
+	Base     string          `xml:"base,attr,omitempty"`   // the search base
+	Filter   string          `xml:"filter,attr,omitempty"` // the search filter
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// the DTMF feature codes
+type PseudoDtmfCtrl struct {
+	PseudoBase
+	DtmfCtrl *PseudoDtmfCtrlSettings `xml:"dtmf-ctrl"`
+}
+
+type PseudoDtmfCtrlSettings struct {
+	Cfu      string          `xml:"cfu,attr,omitempty"`    // the code of the call forward unconditional
+	Cfb      string          `xml:"cfb,attr,omitempty"`    // the code of the call forward busy
+	Cfnr     string          `xml:"cfnr,attr,omitempty"`   // the code of the call forward no response
+	Pickup   string          `xml:"pickup,attr,omitempty"` // the code of the call pickup
+	Park     string          `xml:"park,attr,omitempty"`   // the code of the call park
+	Dnd      string          `xml:"dnd,attr,omitempty"`    // the code of do not disturb
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// an external UC interface
+type PseudoUc struct {
+	PseudoBase
+	Uc *PseudoUcSettings `xml:"uc"`
+}
+
+type PseudoUcSettings struct {
+	Url      string          `xml:"url,attr,omitempty"`  // the url of the interface
+	User     string          `xml:"user,attr,omitempty"` // the user of the login
+	Pwd      string          `xml:"pwd,attr,omitempty"`  // the password of the login
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a fax
+type PseudoFax struct {
+	PseudoBase
+	Fax *PseudoFaxSettings `xml:"fax"`
+}
+
+type PseudoFaxSettings struct {
+	Email    string          `xml:"email,attr,omitempty"` // the address received faxes are sent to
+	Pdf      bool            `xml:"pdf,attr,omitempty"`   // received faxes are sent as pdf
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// an ICP, a connection to another PBX
+type PseudoIcp struct {
+	PseudoBase
+	Icp *PseudoIcpSettings `xml:"icp"`
+}
+
+type PseudoIcpSettings struct {
+	Addr     string          `xml:"addr,attr,omitempty"`   // the address of the other PBX
+	Prefix   string          `xml:"prefix,attr,omitempty"` // the prefix of the numbers of the other PBX
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// an LDAP directory
+type PseudoLdap struct {
+	PseudoBase
+	Ldap *PseudoLdapSettings `xml:"ldap"`
+}
+
+type PseudoLdapSettings struct {
+	Server   string          `xml:"server,attr,omitempty"` // the address of the LDAP server
+	User     string          `xml:"user,attr,omitempty"`   // the user of the bind
+	Pwd      string          `xml:"pwd,attr,omitempty"`    // the password of the bind
+	Base     string          `xml:"base,attr,omitempty"`   // the search base
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a multicast announcement
+type PseudoMCastAnnounce struct {
+	PseudoBase
+	MCastAnnounce *PseudoMCastAnnounceSettings `xml:"multicast"`
+}
+
+type PseudoMCastAnnounceSettings struct {
+	Addr     string          `xml:"addr,attr,omitempty"` // the multicast address
+	Port     int             `xml:"port,attr,omitempty"` // the multicast port
+	Url      string          `xml:"url,attr,omitempty"`  // the url of the announcement
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a message waiting indication
+type PseudoMessageWaiting struct {
+	PseudoBase
+	MessageWaiting *PseudoMessageWaitingSettings `xml:"mwi"`
+}
+
+type PseudoMessageWaitingSettings struct {
+	Vm       string          `xml:"vm,attr,omitempty"` // the h323 name of the voicemail of the indication
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a messages object
+type PseudoMessages struct {
+	PseudoBase
+	Messages *PseudoMessagesSettings `xml:"messages"`
+}
+
+type PseudoMessagesSettings struct {
+	Url      string          `xml:"url,attr,omitempty"` // the url of the messages service
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a mobility object, calls to mobile phones of users
+type PseudoMobility struct {
+	PseudoBase
+	Mobility *PseudoMobilitySettings `xml:"mobility"`
+}
+
+type PseudoMobilitySettings struct {
+	Prefix   string          `xml:"prefix,attr,omitempty"` // the prefix of the calls to the mobile phones
+	Gw       string          `xml:"gw,attr,omitempty"`     // the h323 name of the gateway of the calls
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a node of the number tree
+type PseudoNode struct {
+	PseudoBase
+	Node *PseudoNodeSettings `xml:"node"`
+}
+
+type PseudoNodeSettings struct {
+	Prefix   string          `xml:"prefix,attr,omitempty"` // the prefix of the node
+	Parent   string          `xml:"parent,attr,omitempty"` // the h323 name of the parent node
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a number map, calls to the object are sent to the destination
+type PseudoNumberMap struct {
+	PseudoBase
+	NumberMap *PseudoNumberMapSettings `xml:"map"`
+}
+
+type PseudoNumberMapSettings struct {
+	E164     string          `xml:"e164,attr,omitempty"` // the number of the destination
+	H323     string          `xml:"h323,attr,omitempty"` // the name of the destination
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a push object, for the push notifications of the apps
+type PseudoPush struct {
+	PseudoBase
+	Push *PseudoPushSettings `xml:"push"`
+}
+
+type PseudoPushSettings struct {
+	Url      string          `xml:"url,attr,omitempty"` // the url of the push service
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a quickdial, short numbers for external numbers
+type PseudoQuickdial struct {
+	PseudoBase
+	Quickdial *PseudoQuickdialSettings `xml:"qdial"`
+}
+
+type PseudoQuickdialSettings struct {
+	Entries  []PseudoQuickdialEntry `xml:"entry"`
+	Attrs    []xml.Attr             `xml:",any,attr"`
+	Elements []PseudoElement        `xml:",any"`
+}
+
+// a short number of a quickdial
+type PseudoQuickdialEntry struct {
+	Num   string     `xml:"num,attr"`            // the short number
+	E164  string     `xml:"e164,attr,omitempty"` // the number that is called
+	Name  string     `xml:"name,attr,omitempty"` // the name shown for the number
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+// a session border controller
+type PseudoSessionBorder struct {
+	PseudoBase
+	SessionBorder *PseudoSessionBorderSettings `xml:"sbc"`
+}
+
+type PseudoSessionBorderSettings struct {
+	Addr     string          `xml:"addr,attr,omitempty"` // the address of the session border controller
+	Port     int             `xml:"port,attr,omitempty"` // the port of the session border controller
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a settings object, for the settings app of the users
+type PseudoSettings struct {
+	PseudoBase
+	Settings *PseudoSettingsSettings `xml:"settings"`
+}
+
+type PseudoSettingsSettings struct {
+	Url      string          `xml:"url,attr,omitempty"` // the url of the settings app
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a voicemail
+type PseudoVoicemail struct {
+	PseudoBase
+	Voicemail *PseudoVoicemailSettings `xml:"vm"`
+}
+
+type PseudoVoicemailSettings struct {
+	Url      string          `xml:"url,attr,omitempty"`   // the url of the voicemail script
+	Email    string          `xml:"email,attr,omitempty"` // the address the messages are sent to
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a gateway
+type PseudoGw struct {
+	PseudoBase
+	Gw *PseudoGwSettings `xml:"gw"`
+}
+
+type PseudoGwSettings struct {
+	Prefix   string          `xml:"prefix,attr,omitempty"` // the prefix of the calls to the gateway
+	Reg      bool            `xml:"reg,attr,omitempty"`    // the gateway registers as endpoint
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a PBX location
+type PseudoPbx struct {
+	PseudoBase
+	Pbx *PseudoPbxSettings `xml:"loc"`
+}
+
+type PseudoPbxSettings struct {
+	Dns      string          `xml:"dns,attr,omitempty"`    // the dns name of the PBX
+	Addr     string          `xml:"addr,attr,omitempty"`   // the address of the PBX
+	Master   string          `xml:"master,attr,omitempty"` // the location of the master of the PBX
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a waiting queue
+type PseudoWaitingQueue struct {
+	PseudoBase
+	WaitingQueue *PseudoWaitingQueueSettings `xml:"waiting"`
+}
+
+type PseudoWaitingQueueSettings struct {
+	Group    string          `xml:"group,attr,omitempty"`   // the group of the operators
+	Max      int             `xml:"max,attr,omitempty"`     // the maximum number of waiting calls
+	Timeout  int             `xml:"timeout,attr,omitempty"` // the seconds a call waits before it is forwarded
+	Cfnr     string          `xml:"cfnr,attr,omitempty"`    // the number calls are forwarded to after the timeout or if the queue is full
+	Ann      string          `xml:"ann,attr,omitempty"`     // the url of the announcement
+	Moh      string          `xml:"moh,attr,omitempty"`     // the url of the music on hold
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// an executive with secretaries, calls to the executive are sent to the secretaries
+type PseudoExecutive struct {
+	PseudoBase
+	Executive *PseudoExecutiveSettings `xml:"executive"`
+}
+
+type PseudoExecutiveSettings struct {
+	Exec        string                     `xml:"exec,attr,omitempty"` // the h323 name of the executive
+	Secretaries []PseudoExecutiveSecretary `xml:"sec"`
+	Attrs       []xml.Attr                 `xml:",any,attr"`
+	Elements    []PseudoElement            `xml:",any"`
+}
+
+// a secretary of an executive
+type PseudoExecutiveSecretary struct {
+	H323  string     `xml:"h323,attr"` // the h323 name of the secretary
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+// a boolean object, e.g. to switch call diversions
+type PseudoBoolean struct {
+	PseudoBase
+	Boolean *PseudoBooleanSettings `xml:"bool"`
+}
+
+type PseudoBooleanSettings struct {
+	On       bool            `xml:"on,attr,omitempty"` // the value of the boolean
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// a trunk line
+type PseudoTrunk struct {
+	PseudoBase
+	Trunk *PseudoTrunkSettings `xml:"trunk"`
+}
+
+type PseudoTrunkSettings struct {
+	Intl     string          `xml:"intl,attr,omitempty"` // the prefix of international numbers
+	Ntl      string          `xml:"ntl,attr,omitempty"`  // the prefix of national numbers
+	Subs     string          `xml:"subs,attr,omitempty"` // the prefix of subscriber numbers
+	Country  string          `xml:"cc,attr,omitempty"`   // the country code of the trunk
+	Area     string          `xml:"ac,attr,omitempty"`   // the area code of the trunk
+	Attrs    []xml.Attr      `xml:",any,attr"`
+	Elements []PseudoElement `xml:",any"`
+}
+
+// creates the typed struct for a pseudo type
+var pseudoObjects = map[string]func() PseudoObject{
+	PseudoTypeApp:            func() PseudoObject { return &PseudoApp{} },
+	PseudoTypeAp:             func() PseudoObject { return &PseudoAp{} },
+	PseudoTypeBcConference:   func() PseudoObject { return &PseudoBcConference{} },
+	PseudoTypeBroadcast:      func() PseudoObject { return &PseudoBroadcast{} },
+	PseudoTypeConference:     func() PseudoObject { return &PseudoConference{} },
+	PseudoTypeConfig:         func() PseudoObject { return &PseudoConfig{} },
+	PseudoTypeDect:           func() PseudoObject { return &PseudoDect{} },
+	PseudoTypeDirsearch:      func() PseudoObject { return &PseudoDirsearch{} },
+	PseudoTypeDtmfCtrl:       func() PseudoObject { return &PseudoDtmfCtrl{} },
+	PseudoTypeUc:             func() PseudoObject { return &PseudoUc{} },
+	PseudoTypeFax:            func() PseudoObject { return &PseudoFax{} },
+	PseudoTypeIcp:            func() PseudoObject { return &PseudoIcp{} },
+	PseudoTypeLdap:           func() PseudoObject { return &PseudoLdap{} },
+	PseudoTypeMCastAnnounce:  func() PseudoObject { return &PseudoMCastAnnounce{} },
+	PseudoTypeMessageWaiting: func() PseudoObject { return &PseudoMessageWaiting{} },
+	PseudoTypeMessages:       func() PseudoObject { return &PseudoMessages{} },
+	PseudoTypeMobility:       func() PseudoObject { return &PseudoMobility{} },
+	PseudoTypeNode:           func() PseudoObject { return &PseudoNode{} },
+	PseudoTypeNumberMap:      func() PseudoObject { return &PseudoNumberMap{} },
+	PseudoTypePush:           func() PseudoObject { return &PseudoPush{} },
+	PseudoTypeQuickdial:      func() PseudoObject { return &PseudoQuickdial{} },
+	PseudoTypeSessionBorder:  func() PseudoObject { return &PseudoSessionBorder{} },
+	PseudoTypeSettings:       func() PseudoObject { return &PseudoSettings{} },
+	PseudoTypeVoicemail:      func() PseudoObject { return &PseudoVoicemail{} },
+	PseudoTypeGw:             func() PseudoObject { return &PseudoGw{} },
+	PseudoTypePbx:            func() PseudoObject { return &PseudoPbx{} },
+	PseudoTypeWaitingQueue:   func() PseudoObject { return &PseudoWaitingQueue{} },
+	PseudoTypeExecutive:      func() PseudoObject { return &PseudoExecutive{} },
+	PseudoTypeBoolean:        func() PseudoObject { return &PseudoBoolean{} },
+	PseudoTypeTrunk:          func() PseudoObject { return &PseudoTrunk{} },
+}
+
+// returns a new typed struct for the pseudo type, e.g. to create an object with the write api
+func NewPseudoObject(pseudoType string) (PseudoObject, error) {
+	create, ok := pseudoObjects[pseudoType]
+	if !ok {
+		return nil, fmt.Errorf("unknown pseudo type '%s'", pseudoType)
+	}
+	object := create()
+	object.Base().Type = pseudoType
+	return object, nil
+}
+
+// returns the typed struct of the pseudo XML
+func UnmarshalPseudo(data string) (PseudoObject, error) {
+	var base PseudoBase
+	if err := xml.Unmarshal([]byte(data), &base); err != nil {
+		return nil, fmt.Errorf("Failed to parse PseudoType XML: %s", err)
+	}
+	create, ok := pseudoObjects[base.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown pseudo type '%s'", base.Type)
+	}
+	object := create()
+	if err := xml.Unmarshal([]byte(data), object); err != nil {
+		return nil, fmt.Errorf("Failed to parse PseudoType XML: %s", err)
+	}
+	return object, nil
+}
+
+// returns the pseudo XML of the typed struct
+func MarshalPseudo(object PseudoObject) (string, error) {
+	data, err := xml.Marshal(object)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+/*
+returns the typed struct of the pseudo information, like *PseudoWaitingQueue for PseudoTypeWaitingQueue.
+
+User objects have no pseudo information, nil is returned for them.
+*/
+func (obj *ReplicatedObject) PseudoObject() (any, error) {
+	if obj.Pseudo == "" {
+		return nil, nil
+	}
+	return UnmarshalPseudo(obj.Pseudo)
+}
+
+// sets the pseudo information to the XML of the typed struct, nil for a user object
+func (obj *ReplicatedObject) SetPseudoObject(object PseudoObject) error {
+	if object == nil {
+		obj.Pseudo = ""
+		return nil
+	}
+	if _, ok := pseudoObjects[object.GetType()]; !ok {
+		return fmt.Errorf("unknown pseudo type '%s'", object.GetType())
+	}
+	data, err := MarshalPseudo(object)
+	if err != nil {
+		return err
+	}
+	obj.Pseudo = data
+	return nil
+}
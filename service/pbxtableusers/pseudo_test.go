@@ -0,0 +1,191 @@
+package pbxtableusers_test
+
+import (
+	"testing"
+
+	"github.com/ricoschulte/go-myapps/service/pbxtableusers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicatedObject_PseudoObject(t *testing.T) {
+	tests := []struct {
+		name   string
+		pseudo string
+		check  func(t *testing.T, pseudo interface{})
+	}{
+		{name: "app", pseudo: `<pseudo type="app"><app url="https://apps.example.com/example.com/contacts/contacts.htm" websocket="true" pbx="true" tableusers="true" admin="true"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			app := pseudo.(*pbxtableusers.PseudoApp).App
+			assert.Equal(t, "https://apps.example.com/example.com/contacts/contacts.htm", app.URL)
+			assert.True(t, app.TableUsers)
+		}},
+		{name: "ap", pseudo: `<pseudo type="ap"><ap dect="dect-main" hw="IP1202-4a-1b-2c"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, "dect-main", pseudo.(*pbxtableusers.PseudoAp).Ap.Dect)
+		}},
+		{name: "bc_conf", pseudo: `<pseudo type="bc_conf"><bc_conf group="Emergency" pin="4711" channels="20"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, 20, pseudo.(*pbxtableusers.PseudoBcConference).BcConference.Channels)
+		}},
+		{name: "broadcast", pseudo: `<pseudo type="broadcast"><broadcast group="Sales" timeout="30"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			broadcast := pseudo.(*pbxtableusers.PseudoBroadcast).Broadcast
+			assert.Equal(t, "Sales", broadcast.Group)
+			assert.Equal(t, 30, broadcast.Timeout)
+		}},
+		{name: "conference", pseudo: `<pseudo type="conference"><conference channels="8" pin="1234" moh="http://127.0.0.1/moh.g711a"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			conference := pseudo.(*pbxtableusers.PseudoConference).Conference
+			assert.Equal(t, 8, conference.Channels)
+			assert.Equal(t, "1234", conference.Pin)
+		}},
+		{name: "config", pseudo: `<pseudo type="config"/>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, pbxtableusers.PseudoTypeConfig, pseudo.(*pbxtableusers.PseudoConfig).Type)
+		}},
+		{name: "dect", pseudo: `<pseudo type="dect"><dect park="31100123456789" ari="1003F1A2B3" ac="0000" subs="true"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			dect := pseudo.(*pbxtableusers.PseudoDect).Dect
+			assert.Equal(t, "31100123456789", dect.Park)
+			assert.True(t, dect.Subs)
+		}},
+		{name: "dirsearch", pseudo: `<pseudo type="dirsearch"><dirsearch ldap="ldap-ad" base="ou=users,dc=example,dc=com" filter="(objectClass=person)"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, "ldap-ad", pseudo.(*pbxtableusers.PseudoDirsearch).Dirsearch.Ldap)
+		}},
+		{name: "dtmf-ctrl", pseudo: `<pseudo type="dtmf-ctrl"><dtmf-ctrl cfu="*21" cfb="*67" cfnr="*61" pickup="*8" dnd="*26"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, "*21", pseudo.(*pbxtableusers.PseudoDtmfCtrl).DtmfCtrl.Cfu)
+		}},
+		{name: "uc", pseudo: `<pseudo type="uc"><uc url="https://uc.example.com/api" user="pbx"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, "pbx", pseudo.(*pbxtableusers.PseudoUc).Uc.User)
+		}},
+		{name: "fax", pseudo: `<pseudo type="fax"><fax email="fax@example.com" pdf="true"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.True(t, pseudo.(*pbxtableusers.PseudoFax).Fax.Pdf)
+		}},
+		{name: "icp", pseudo: `<pseudo type="icp"><icp addr="192.168.0.10" prefix="8"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, "8", pseudo.(*pbxtableusers.PseudoIcp).Icp.Prefix)
+		}},
+		{name: "ldap", pseudo: `<pseudo type="ldap"><ldap server="ldap.example.com" user="cn=pbx,dc=example,dc=com" base="dc=example,dc=com"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, "ldap.example.com", pseudo.(*pbxtableusers.PseudoLdap).Ldap.Server)
+		}},
+		{name: "multicast", pseudo: `<pseudo type="multicast"><multicast addr="239.0.0.1" port="5004" url="http://127.0.0.1/gong.g711a"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, 5004, pseudo.(*pbxtableusers.PseudoMCastAnnounce).MCastAnnounce.Port)
+		}},
+		{name: "mwi", pseudo: `<pseudo type="mwi"><mwi vm="voicemail"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, "voicemail", pseudo.(*pbxtableusers.PseudoMessageWaiting).MessageWaiting.Vm)
+		}},
+		{name: "messages", pseudo: `<pseudo type="messages"><messages url="https://apps.example.com/example.com/messages/messages"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.NotEmpty(t, pseudo.(*pbxtableusers.PseudoMessages).Messages.Url)
+		}},
+		{name: "mobility", pseudo: `<pseudo type="mobility"><mobility prefix="0" gw="gw-sip"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, "gw-sip", pseudo.(*pbxtableusers.PseudoMobility).Mobility.Gw)
+		}},
+		{name: "node", pseudo: `<pseudo type="node"><node prefix="2" parent="root"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, "root", pseudo.(*pbxtableusers.PseudoNode).Node.Parent)
+		}},
+		{name: "map", pseudo: `<pseudo type="map"><map e164="200"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, "200", pseudo.(*pbxtableusers.PseudoNumberMap).NumberMap.E164)
+		}},
+		{name: "push", pseudo: `<pseudo type="push"><push url="https://push.example.com"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, "https://push.example.com", pseudo.(*pbxtableusers.PseudoPush).Push.Url)
+		}},
+		{name: "qdial", pseudo: `<pseudo type="qdial"><qdial><entry num="1" e164="004930123456" name="Berlin office"/><entry num="2" e164="004989654321"/></qdial></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			entries := pseudo.(*pbxtableusers.PseudoQuickdial).Quickdial.Entries
+			if assert.Len(t, entries, 2) {
+				assert.Equal(t, "Berlin office", entries[0].Name)
+				assert.Equal(t, "004989654321", entries[1].E164)
+			}
+		}},
+		{name: "sbc", pseudo: `<pseudo type="sbc"><sbc addr="sbc.example.com" port="5061"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, 5061, pseudo.(*pbxtableusers.PseudoSessionBorder).SessionBorder.Port)
+		}},
+		{name: "settings", pseudo: `<pseudo type="settings"><settings url="https://apps.example.com/example.com/settings/settings"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.NotEmpty(t, pseudo.(*pbxtableusers.PseudoSettings).Settings.Url)
+		}},
+		{name: "vm", pseudo: `<pseudo type="vm"><vm url="http://127.0.0.1/vm/vm.xml" email="voicemail@example.com"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, "voicemail@example.com", pseudo.(*pbxtableusers.PseudoVoicemail).Voicemail.Email)
+		}},
+		{name: "gw", pseudo: `<pseudo type="gw"><gw prefix="0" reg="true"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.True(t, pseudo.(*pbxtableusers.PseudoGw).Gw.Reg)
+		}},
+		{name: "loc", pseudo: `<pseudo type="loc"><loc dns="pbx-branch.example.com" master="master"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.Equal(t, "pbx-branch.example.com", pseudo.(*pbxtableusers.PseudoPbx).Pbx.Dns)
+		}},
+		{name: "waiting", pseudo: `<pseudo type="waiting"><waiting group="Hotline" max="10" timeout="120" cfnr="100" ann="http://127.0.0.1/ann.g711a" moh="http://127.0.0.1/moh.g711a"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			queue := pseudo.(*pbxtableusers.PseudoWaitingQueue).WaitingQueue
+			assert.Equal(t, "Hotline", queue.Group)
+			assert.Equal(t, 10, queue.Max)
+			assert.Equal(t, 120, queue.Timeout)
+		}},
+		{name: "executive", pseudo: `<pseudo type="executive"><executive exec="boss"><sec h323="assistant1"/><sec h323="assistant2"/></executive></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			executive := pseudo.(*pbxtableusers.PseudoExecutive).Executive
+			assert.Equal(t, "boss", executive.Exec)
+			assert.Len(t, executive.Secretaries, 2)
+		}},
+		{name: "bool", pseudo: `<pseudo type="bool"><bool on="true"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			assert.True(t, pseudo.(*pbxtableusers.PseudoBoolean).Boolean.On)
+		}},
+		{name: "trunk", pseudo: `<pseudo type="trunk"><trunk intl="00" ntl="0" cc="49" ac="30"/></pseudo>`, check: func(t *testing.T, pseudo interface{}) {
+			trunk := pseudo.(*pbxtableusers.PseudoTrunk).Trunk
+			assert.Equal(t, "49", trunk.Country)
+			assert.Equal(t, "30", trunk.Area)
+		}},
+	}
+	tested := map[string]bool{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			obj := &pbxtableusers.ReplicatedObject{Pseudo: test.pseudo}
+			pseudo, err := obj.PseudoObject()
+			if !assert.NoError(t, err) {
+				return
+			}
+			test.check(t, pseudo)
+			pseudoType, _ := obj.GetPseudoType()
+			assert.Equal(t, pseudoType, pseudo.(pbxtableusers.PseudoObject).GetType())
+			tested[pseudoType] = true
+
+			// marshalled again, the content is the same
+			roundtrip := &pbxtableusers.ReplicatedObject{}
+			assert.NoError(t, roundtrip.SetPseudoObject(pseudo.(pbxtableusers.PseudoObject)))
+			again, err := roundtrip.PseudoObject()
+			assert.NoError(t, err)
+			assert.Equal(t, pseudo, again)
+		})
+	}
+	for _, pseudoType := range pbxtableusers.PseudoTypes {
+		if pseudoType != "" {
+			assert.True(t, tested[pseudoType], "no test for %s", pseudoType)
+		}
+	}
+}
+
+func TestReplicatedObject_PseudoObjectModify(t *testing.T) {
+	obj := &pbxtableusers.ReplicatedObject{Pseudo: `<pseudo type="app"><app url="https://apps/app.htm" websocket="true" custom="1"/></pseudo>`}
+	pseudo, err := obj.PseudoObject()
+	assert.NoError(t, err)
+	app := pseudo.(*pbxtableusers.PseudoApp)
+	assert.Equal(t, "https://apps/app.htm", app.App.URL)
+	assert.True(t, app.App.WebSocket)
+	app.App.RCC = true
+	assert.NoError(t, obj.SetPseudoObject(app))
+	assert.Contains(t, obj.Pseudo, `rcc="true"`)
+	assert.Contains(t, obj.Pseudo, `custom="1"`)
+
+	// the content that is not modelled is kept
+	obj = &pbxtableusers.ReplicatedObject{Pseudo: `<pseudo type="waiting" custom="1"><waiting max="10" custom="2"><custom/></waiting></pseudo>`}
+	pseudo, _ = obj.PseudoObject()
+	queue := pseudo.(*pbxtableusers.PseudoWaitingQueue)
+	queue.WaitingQueue.Max = 20
+	assert.NoError(t, obj.SetPseudoObject(queue))
+	assert.Equal(t, `<pseudo type="waiting" custom="1"><waiting max="20" custom="2"><custom></custom></waiting></pseudo>`, obj.Pseudo)
+
+	// users have no pseudo information
+	pseudo, err = (&pbxtableusers.ReplicatedObject{}).PseudoObject()
+	assert.NoError(t, err)
+	assert.Nil(t, pseudo)
+
+	_, err = (&pbxtableusers.ReplicatedObject{Pseudo: `<pseudo type="unknown"/>`}).PseudoObject()
+	assert.Error(t, err)
+
+	created, err := pbxtableusers.NewPseudoObject(pbxtableusers.PseudoTypeTrunk)
+	assert.NoError(t, err)
+	created.(*pbxtableusers.PseudoTrunk).Trunk = &pbxtableusers.PseudoTrunkSettings{Intl: "00", Ntl: "0"}
+	obj = &pbxtableusers.ReplicatedObject{}
+	assert.NoError(t, obj.SetPseudoObject(created))
+	pseudoType, err := obj.GetPseudoType()
+	assert.NoError(t, err)
+	assert.Equal(t, pbxtableusers.PseudoTypeTrunk, pseudoType)
+	assert.Equal(t, `<pseudo type="trunk"><trunk intl="00" ntl="0"></trunk></pseudo>`, obj.Pseudo)
+}
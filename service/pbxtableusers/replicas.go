@@ -0,0 +1,139 @@
+package pbxtableusers
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/events"
+	log "github.com/sirupsen/logrus"
+)
+
+// returns the key of the PBX of the connection, the domain and the name of the PBX
+func PbxKey(connection *service.AppServicePbxConnection) string {
+	if connection == nil {
+		return ""
+	}
+	return connection.PbxInfo.Domain + "/" + connection.PbxInfo.Pbx
+}
+
+/*
+replicates the users of every PBX connected to the app service into its own PbxTableUsers,
+so the objects of PBXs with overlapping guids are kept apart.
+
+The replicas publish their events on the bus of PbxTableUsersReplicas, the Connection of an
+event tells the PBX, events.ForConnection selects the events of a PBX. A replica is kept when its PBX disconnects, it continues with the objects
+on the next connect.
+*/
+type PbxTableUsersReplicas struct {
+	events.Publisher[PbxTableUsersEvent]
+
+	// creates the replica for the PBX with the PbxKey, e.g. with its own Cache, Replicate and DisconnectPolicy.
+	// NewPbxTableUsers is used if nil.
+	NewReplica func(pbx string) (*PbxTableUsers, error)
+
+	mu          sync.Mutex
+	replicas    map[string]*PbxTableUsers
+	connections map[*service.AppServicePbxConnection]*PbxTableUsers
+}
+
+func NewPbxTableUsersReplicas(newReplica func(pbx string) (*PbxTableUsers, error)) *PbxTableUsersReplicas {
+	return &PbxTableUsersReplicas{
+		NewReplica:  newReplica,
+		replicas:    map[string]*PbxTableUsers{},
+		connections: map[*service.AppServicePbxConnection]*PbxTableUsers{},
+	}
+}
+
+func (api *PbxTableUsersReplicas) GetApiName() string {
+	return "PbxTableUsers"
+}
+
+func (api *PbxTableUsersReplicas) OnConnect(connection *service.AppServicePbxConnection) {
+	pbx := PbxKey(connection)
+	api.mu.Lock()
+	api.init()
+	replica, ok := api.replicas[pbx]
+	if !ok {
+		var err error
+		if api.NewReplica != nil {
+			replica, err = api.NewReplica(pbx)
+		} else {
+			replica = NewPbxTableUsers()
+		}
+		if err != nil {
+			api.mu.Unlock()
+			log.Errorf("PbxTableUsers: creating the replica of %s failed: %v", pbx, err)
+			return
+		}
+		replica.SetBus(api.Bus())
+		api.replicas[pbx] = replica
+	}
+	api.connections[connection] = replica
+	api.mu.Unlock()
+
+	replica.OnConnect(connection)
+}
+
+func (api *PbxTableUsersReplicas) OnDisconnect(connection *service.AppServicePbxConnection) {
+	api.mu.Lock()
+	replica, ok := api.connections[connection]
+	delete(api.connections, connection)
+	api.mu.Unlock()
+	if ok {
+		replica.OnDisconnect(connection)
+	}
+}
+
+func (api *PbxTableUsersReplicas) HandleMessage(connection *service.AppServicePbxConnection, msg *service.BaseMessage, message []byte) {
+	api.mu.Lock()
+	replica, ok := api.connections[connection]
+	api.mu.Unlock()
+	if !ok {
+		log.Warnf("PbxTableUsers: %s received from %s without a replica", msg.Mt, PbxKey(connection))
+		return
+	}
+	replica.HandleMessage(connection, msg, message)
+}
+
+// the mutex has to be locked
+func (api *PbxTableUsersReplicas) init() {
+	if api.replicas == nil {
+		api.replicas = map[string]*PbxTableUsers{}
+	}
+	if api.connections == nil {
+		api.connections = map[*service.AppServicePbxConnection]*PbxTableUsers{}
+	}
+}
+
+// returns the replica of the PBX with the PbxKey
+func (api *PbxTableUsersReplicas) GetReplica(pbx string) (*PbxTableUsers, bool) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	replica, ok := api.replicas[pbx]
+	return replica, ok
+}
+
+// returns the replica of the PBX of the connection
+func (api *PbxTableUsersReplicas) GetReplicaOfConnection(connection *service.AppServicePbxConnection) (*PbxTableUsers, bool) {
+	return api.GetReplica(PbxKey(connection))
+}
+
+// returns the PbxKey of the PBXs with a replica, sorted
+func (api *PbxTableUsersReplicas) GetPbxs() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	pbxs := make([]string, 0, len(api.replicas))
+	for pbx := range api.replicas {
+		pbxs = append(pbxs, pbx)
+	}
+	sort.Strings(pbxs)
+	return pbxs
+}
+
+// removes the replica of the PBX, e.g. for a PBX that is not used anymore
+func (api *PbxTableUsersReplicas) RemoveReplica(pbx string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	delete(api.replicas, pbx)
+}
@@ -0,0 +1,139 @@
+package pbxtableusers_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/events"
+	"github.com/ricoschulte/go-myapps/service/pbxtableusers"
+	"github.com/ricoschulte/go-myapps/service/servicetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPbxTableUsersReplicas(t *testing.T) {
+	starts := make(chan map[string]interface{}, 10)
+	answer := func(peer *websocket.Conn, message map[string]interface{}) {
+		if message["mt"] == "ReplicateStart" {
+			starts <- message
+		}
+	}
+	pbx1 := servicetest.NewConnection(t, answer)
+	pbx1.PbxInfo = service.PbxInfo{Domain: "example.com", Pbx: "pbx1"}
+	pbx2 := servicetest.NewConnection(t, answer)
+	pbx2.PbxInfo = service.PbxInfo{Domain: "example.com", Pbx: "pbx2"}
+
+	api := pbxtableusers.NewPbxTableUsersReplicas(func(pbx string) (*pbxtableusers.PbxTableUsers, error) {
+		replica := pbxtableusers.NewPbxTableUsers()
+		replica.Replicate = &pbxtableusers.ReplicateOptions{
+			Columns: map[string]pbxtableusers.Column{"guid": {}, "h323": {Udate: true}},
+			Pseudo:  []string{""},
+		}
+		if pbx == "example.com/pbx2" {
+			replica.DisconnectPolicy = pbxtableusers.DisconnectPurge
+		}
+		return replica, nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pbx2Events := api.Subscribe(ctx, events.ForConnection[pbxtableusers.PbxTableUsersEvent](pbx2), events.ForTypes[pbxtableusers.PbxTableUsersEvent](pbxtableusers.PbxTableUsersEventDelete))
+
+	api.OnConnect(pbx1)
+	api.OnConnect(pbx2)
+	for i := 0; i < 2; i++ {
+		select {
+		case start := <-starts:
+			assert.Equal(t, map[string]interface{}{"guid": map[string]interface{}{"update": false}, "h323": map[string]interface{}{"update": true}}, start["columns"])
+			assert.Equal(t, []interface{}{""}, start["pseudo"])
+		case <-time.After(5 * time.Second):
+			t.Fatal("no ReplicateStart sent")
+		}
+	}
+	assert.Equal(t, []string{"example.com/pbx1", "example.com/pbx2"}, api.GetPbxs())
+
+	// the same guid on both PBXs
+	add := func(connection *service.AppServicePbxConnection, columns string) {
+		api.HandleMessage(connection, &service.BaseMessage{Api: "PbxTableUsers", Mt: "ReplicateAdd"}, []byte(`{"mt":"ReplicateAdd","api":"PbxTableUsers","columns":`+columns+`}`))
+	}
+	add(pbx1, `{"guid":"1","h323":"alice"}`)
+	add(pbx2, `{"guid":"1","h323":"bob"}`)
+
+	replica1, ok := api.GetReplicaOfConnection(pbx1)
+	assert.True(t, ok)
+	replica2, _ := api.GetReplica("example.com/pbx2")
+	object, _ := replica1.GetByGuid("1")
+	assert.Equal(t, "alice", object.H323)
+	object, _ = replica2.GetByGuid("1")
+	assert.Equal(t, "bob", object.H323)
+
+	// the objects of pbx1 are kept, the objects of pbx2 are purged
+	api.OnDisconnect(pbx1)
+	api.OnDisconnect(pbx2)
+	assert.Len(t, replica1.GetReplicatedObjects(), 1)
+	assert.Empty(t, replica2.GetReplicatedObjects())
+	event := <-pbx2Events.C
+	assert.Equal(t, "1", event.Object.Guid)
+	assert.Equal(t, "bob", event.Object.H323)
+
+	// a reconnect uses the same replica
+	api.OnConnect(pbx1)
+	replica, _ := api.GetReplicaOfConnection(pbx1)
+	assert.Same(t, replica1, replica)
+}
+
+func TestPbxTableUsers_DisconnectFlag(t *testing.T) {
+	api := pbxtableusers.NewPbxTableUsers()
+	api.DisconnectPolicy = pbxtableusers.DisconnectFlag
+	connection := servicetest.NewConnection(t, nil)
+
+	api.OnConnect(connection)
+	replicate(api, connection, `{"guid":"1","h323":"alice"}`)
+	assert.False(t, api.IsStale())
+
+	api.OnDisconnect(connection)
+	assert.True(t, api.IsStale())
+	assert.Len(t, api.GetReplicatedObjects(), 1)
+
+	api.OnConnect(connection)
+	replicate(api, connection, `{"guid":"1","h323":"alice"}`)
+	assert.False(t, api.IsStale())
+}
+
+func TestPbxTableUsers_DisconnectPoliciesTwoPbxs(t *testing.T) {
+	pbx1 := servicetest.NewConnection(t, nil)
+	pbx1.PbxInfo = service.PbxInfo{Domain: "example.com", Pbx: "pbx1"}
+	pbx2 := servicetest.NewConnection(t, nil)
+	pbx2.PbxInfo = service.PbxInfo{Domain: "example.com", Pbx: "pbx2"}
+
+	api := pbxtableusers.NewPbxTableUsers()
+	api.DisconnectPolicy = pbxtableusers.DisconnectPurge
+	api.OnConnect(pbx1)
+	api.OnConnect(pbx2)
+	replicate(api, pbx1, `{"guid":"1","h323":"alice"}`)
+	replicate(api, pbx2, `{"guid":"2","h323":"bob"}`)
+
+	// the disconnect of a PBX purges only its objects
+	api.OnDisconnect(pbx1)
+	objects := api.GetReplicatedObjects()
+	assert.Len(t, objects, 1)
+	assert.Contains(t, objects, "2")
+	api.OnDisconnect(pbx2)
+	assert.Empty(t, api.GetReplicatedObjects())
+
+	api.DisconnectPolicy = pbxtableusers.DisconnectFlag
+	api.OnConnect(pbx1)
+	api.OnConnect(pbx2)
+	api.OnDisconnect(pbx1)
+	assert.True(t, api.IsStale())
+	assert.True(t, api.IsStalePbx(pbxtableusers.PbxKey(pbx1)))
+	assert.False(t, api.IsStalePbx(pbxtableusers.PbxKey(pbx2)))
+
+	// the sync of another PBX does not clear the flag
+	replicate(api, pbx2)
+	assert.True(t, api.IsStale())
+	api.OnConnect(pbx1)
+	replicate(api, pbx1)
+	assert.False(t, api.IsStale())
+}
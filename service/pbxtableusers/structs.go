@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/ricoschulte/go-myapps/service"
 )
@@ -34,6 +35,23 @@ func NewReplicateStart(add, del bool, columns map[string]Column, pseudo []string
 	}
 }
 
+// the options of the ReplicateStart sent by PbxTableUsers on connect
+type ReplicateOptions struct {
+	Add     bool              // the PBX sends ReplicateAdd and accepts ReplicateAdd of the app
+	Del     bool              // the PBX sends ReplicateDel and accepts ReplicateDel of the app
+	Columns map[string]Column // the columns to replicate, e.g. AllColumns
+	Pseudo  []string          // the pseudo types of the objects to replicate, see PseudoTypes. All objects if empty
+}
+
+// what happens with the replicated objects of a PBX when its last connection is closed
+type DisconnectPolicy int
+
+const (
+	DisconnectKeep  DisconnectPolicy = iota // the objects are kept
+	DisconnectPurge                         // the objects are removed, a PbxTableUsersEventDelete is sent for each
+	DisconnectFlag                          // the objects are kept, but IsStale returns true until the next initial sync of the PBX is done
+)
+
 type ReplicateNext struct {
 	service.BaseMessage
 }
@@ -74,104 +92,246 @@ type ReplicateDel struct {
 	ReplicatedObject ReplicatedObject `json:"columns"`
 }
 
+// a ReplicateAdd or ReplicateUpdate written to the PBX, with only the columns to write
+type ReplicateColumns struct {
+	service.BaseMessage
+	Columns Columns `json:"columns"`
+}
+
+// adds the object to the PBX, needs a replication started with add.
+// Only the columns that are set are sent and the named columns, also with false, 0 or empty, see SetColumns.
+func NewReplicateAdd(object *ReplicatedObject, src string, columns ...string) *ReplicateColumns {
+	return &ReplicateColumns{
+		BaseMessage: service.BaseMessage{
+			Api: "PbxTableUsers",
+			Mt:  "ReplicateAdd",
+			Src: src,
+		},
+		Columns: SetColumns(object, columns...),
+	}
+}
+
+// updates the columns of the object with the guid of the object, needs a replication started with update for the columns.
+// Only the named columns are sent, also with false, 0 or empty, or the columns that are set if none are named.
+// To clear a column it has to be named.
+func NewReplicateUpdate(object *ReplicatedObject, src string, columns ...string) *ReplicateColumns {
+	update := &ReplicateColumns{
+		BaseMessage: service.BaseMessage{
+			Api: "PbxTableUsers",
+			Mt:  "ReplicateUpdate",
+			Src: src,
+		},
+	}
+	if len(columns) > 0 {
+		update.Columns = SelectColumns(object, columns...)
+	} else {
+		update.Columns = SetColumns(object)
+	}
+	return update
+}
+
+// updates the columns of object that differ from current, e.g. the replicated object it is a changed copy of
+func NewReplicateUpdateChanges(current, object *ReplicatedObject, src string) *ReplicateColumns {
+	return &ReplicateColumns{
+		BaseMessage: service.BaseMessage{
+			Api: "PbxTableUsers",
+			Mt:  "ReplicateUpdate",
+			Src: src,
+		},
+		Columns: ChangedColumns(current, object),
+	}
+}
+
+// deletes the object with the guid from the PBX, needs a replication started with del
+func NewReplicateDel(guid string, src string) *ReplicateDel {
+	return &ReplicateDel{
+		BaseMessage: service.BaseMessage{
+			Api: "PbxTableUsers",
+			Mt:  "ReplicateDel",
+			Src: src,
+		},
+		ReplicatedObject: ReplicatedObject{Guid: guid},
+	}
+}
+
+type ReplicateAddResult struct {
+	service.BaseMessage
+	Guid string `json:"guid"` // the guid of the added object
+}
+
+type ReplicateUpdateResult struct {
+	service.BaseMessage
+	Guid string `json:"guid"`
+}
+
+type ReplicateDelResult struct {
+	service.BaseMessage
+	Guid string `json:"guid"`
+}
+
 type ReplicatedObject struct {
-	Guid      string `json:"guid"`       // ReplicationString	guid	Globally unique identifier
-	H323      string `json:"h323"`       // ReplicationString	h323	Username
-	Pwd       string `json:"pwd"`        // ReplicationString	pwd	Password
-	Cn        string `json:"cn"`         // ReplicationString	cn	Common name
-	Dn        string `json:"dn"`         // ReplicationString	dn	Display name
-	AppsMy    string `json:"apps-my"`    // ReplicationString	apps-my	List of the apps displayed on the home screen
-	Config    string `json:"config"`     // ReplicationString	config	Config template
-	Node      string `json:"node"`       // ReplicationString	node	Node
-	Loc       string `json:"loc"`        // ReplicationString	loc	Location
-	Hide      bool   `json:"hide"`       // ReplicationBool	hide	Hide from LDAP
-	E164      string `json:"e164"`       // ReplicationString	e164	Phone number
-	Cfpr      bool   `json:"cfpr"`       // ReplicationTristate	cfpr	Call forward based on Presence
-	Tcfpr     string `json:"t-cfpr"`     // ReplicationTristate	t-cfpr	Call forward based on Presence inherited from the config template
-	Pseudo    string `json:"pseudo"`     // ReplicationString	pseudo	XML Pseudo information of the object
-	H323email bool   `json:"h323-email"` // ReplicationBool	h323-email	If true, the email is the username
-	Apps      string `json:"apps"`       // ReplicationString	apps	List of the apps that the user has rights to access
-	Fax       bool   `json:"fax"`        // ReplicationBool	fax	If true, the user has a fax license
-	Emails    []struct {
-		Email string `json:"email"` // ReplicationString	email	Email
-	} `json:"emails"` // emails Table with the emails of the users
-	Allows []struct {
-		Name     string `json:"name"`     // ReplicationString	name	Filter name
-		Grp      bool   `json:"grp"`      // ReplicationString	grp	If true, the name is a group name
-		Visible  bool   `json:"visible"`  // ReplicationBool	visible	Visible
-		Online   bool   `json:"online"`   // ReplicationBool	online	Online
-		Presence bool   `json:"presence"` // ReplicationBool	presence	Presence
-		Otf      bool   `json:"otf"`      // ReplicationBool	otf	On the phone
-		Note     bool   `json:"note"`     // ReplicationBool	note	Presence note
-		Dialog   bool   `json:"dialog"`   // ReplicationBool	dialog	Calls
-		Ids      bool   `json:"ids"`      // ReplicationBool	ids	Calls with id
-	} `json:"allows"` // allows Table with the visibility filters defined for the user
-	Tallows []struct {
-		Name     string `json:"name"`     // ReplicationString	name	Filter name
-		Grp      bool   `json:"grp"`      // ReplicationString	grp	If true, the name is a group name
-		Visible  bool   `json:"visible"`  // ReplicationBool	visible	Visible
-		Online   bool   `json:"online"`   // ReplicationBool	online	Online
-		Presence bool   `json:"presence"` // ReplicationBool	presence	Presence
-		Otf      bool   `json:"otf"`      // ReplicationBool	otf	On the phone
-		Note     bool   `json:"note"`     // ReplicationBool	note	Presence note
-		Dialog   bool   `json:"dialog"`   // ReplicationBool	dialog	Calls
-		Ids      bool   `json:"ids"`      // ReplicationBool	ids	Calls with id
-	} `json:"t-allows"` // t-allows Table with the visibility filters defined on the config templates
-	Grps []struct {
-		Name string `json:"name"` // ReplicationString	name	Group name
-		Mode string `json:"mode"` // ReplicationString	mode	Mode
-		Dyn  string `json:"dyn"`  // ReplicationString	dyn	Dynamic
-	} `json:"grps"` // grps Table with the users groups
-	Devices []struct {
-		Hw       string `json:"hw"`        // ReplicationString	hw	Hardware ID
-		Text     string `json:"text"`      // ReplicationString	text	Name
-		App      string `json:"app"`       // ReplicationString	app	App
-		Admin    bool   `json:"admin"`     // ReplicationBool	admin	PBX Pwd
-		Nofilter bool   `json:"no-filter"` // ReplicationBool	no-filter	No IP Filter
-		Tls      bool   `json:"tls"`       // ReplicationBool	tls	TLS only
-		Nomob    bool   `json:"no-mob"`    // ReplicationBool	no-mob	No Mobility
-		Trusted  bool   `json:"trusted"`   // ReplicationBool	trusted	Reverse Proxy
-		Sreg     bool   `json:"sreg"`      // ReplicationBool	sreg	Single Reg.
-		Mr       bool   `json:"mr"`        // ReplicationBool	mr	Media Relay
-		Voip     string `json:"voip"`      // ReplicationString	voip	Config VOIP
-		Gkid     string `json:"gk-id"`     // ReplicationString	gk-id	Gatekeeper ID
-		Prim     string `json:"prim"`      // ReplicationString	prim	Primary gatekeeper
-	} `json:"devices"` // devices Table with the users devices
-	Cds []struct {
-		Type    string `json:"type"`     // ReplicationString	type	Diversion type (cfu` cfb or cfnr)
-		Bool    string `json:"bool"`     // ReplicationString	bool	Boolean object
-		Boolnot bool   `json:"bool-not"` // ReplicationBool		bool-not	Not flag (boolean object)
-		E164    string `json:"e164"`     // ReplicationString	e164	Phone number
-		H323    string `json:"h323"`     // ReplicationString	h323	Username
-		Src     string `json:"src"`      // ReplicationString	src	Filters data on XML format
-	} `json:"cds"` // cds Table with the users call diversions
-	Forks []struct {
-		E164     string `json:"e164"`     // ReplicationString	e164	Phone number
-		H323     string `json:"h323"`     // ReplicationString	h323	Username
-		Bool     string `json:"bool"`     // ReplicationString	bool	Boolean object
-		Boolnot  bool   `json:"bool-not"` // ReplicationBool	bool-not	Not flag (boolean object)
-		Mobility string `json:"mobility"` // ReplicationString	mobility	Mobility object
-		App      string `json:"app"`      // ReplicationString	app	App
-		Delay    int    `json:"delay"`    // ReplicationUnsigned	delay	Delay
-		Hw       string `json:"hw"`       // ReplicationString	hw	Device
-		Off      bool   `json:"off"`      // ReplicationBool	off	Disable
-		Cw       bool   `json:"cw"`       // ReplicationBool	cw	Call-Waiting
-		Min      int    `json:"min"`      // ReplicationUnsigned	min	Min-Alert
-		Max      int    `json:"max"`      // ReplicationUnsigned	max	Max-Alert
-	} `json:"forks"` // forks Table with the users forks
-	Wakeups []struct {
-		H        int    `json:"h"`        // ReplicationUnsigned	h	Hour
-		M        int    `json:"m"`        // ReplicationUnsigned	m	Minute
-		S        int    `json:"s"`        // ReplicationUnsigned	s	Second
-		Name     string `json:"name"`     // ReplicationString	name
-		Num      string `json:"num"`      // ReplicationString	num
-		Retry    int    `json:"retry"`    // ReplicationUnsigned	retry
-		Mult     bool   `json:"mult"`     // ReplicationBool	mult
-		To       int    `json:"to"`       // ReplicationUnsigned	to
-		Fallback string `json:"fallback"` // ReplicationString	fallback
-		Bool     string `json:"bool"`     // ReplicationString	bool	Boolean object
-		Boolnot  bool   `json:"bool-not"` // ReplicationBool	bool-not	Not flag (boolean object)
-	} `json:"wakeups"` // wakeups Table with the users wakeups
+	Guid      string   `json:"guid"`       // ReplicationString	guid	Globally unique identifier
+	H323      string   `json:"h323"`       // ReplicationString	h323	Username
+	Pwd       string   `json:"pwd"`        // ReplicationString	pwd	Password
+	Cn        string   `json:"cn"`         // ReplicationString	cn	Common name
+	Dn        string   `json:"dn"`         // ReplicationString	dn	Display name
+	AppsMy    string   `json:"apps-my"`    // ReplicationString	apps-my	List of the apps displayed on the home screen
+	Config    string   `json:"config"`     // ReplicationString	config	Config template
+	Node      string   `json:"node"`       // ReplicationString	node	Node
+	Loc       string   `json:"loc"`        // ReplicationString	loc	Location
+	Hide      bool     `json:"hide"`       // ReplicationBool	hide	Hide from LDAP
+	E164      string   `json:"e164"`       // ReplicationString	e164	Phone number
+	Cfpr      bool     `json:"cfpr"`       // ReplicationTristate	cfpr	Call forward based on Presence
+	Tcfpr     string   `json:"t-cfpr"`     // ReplicationTristate	t-cfpr	Call forward based on Presence inherited from the config template
+	Pseudo    string   `json:"pseudo"`     // ReplicationString	pseudo	XML Pseudo information of the object
+	H323email bool     `json:"h323-email"` // ReplicationBool	h323-email	If true, the email is the username
+	Apps      string   `json:"apps"`       // ReplicationString	apps	List of the apps that the user has rights to access
+	Fax       bool     `json:"fax"`        // ReplicationBool	fax	If true, the user has a fax license
+	Emails    []Email  `json:"emails"`     // emails Table with the emails of the users
+	Allows    []Allow  `json:"allows"`     // allows Table with the visibility filters defined for the user
+	Tallows   []Allow  `json:"t-allows"`   // t-allows Table with the visibility filters defined on the config templates
+	Grps      []Grp    `json:"grps"`       // grps Table with the users groups
+	Devices   []Device `json:"devices"`    // devices Table with the users devices
+	Cds       []Cd     `json:"cds"`        // cds Table with the users call diversions
+	Forks     []Fork   `json:"forks"`      // forks Table with the users forks
+	Wakeups   []Wakeup `json:"wakeups"`    // wakeups Table with the users wakeups
+}
+
+// an entry of the emails of a user
+type Email struct {
+	Email string `json:"email"` // ReplicationString	email	Email
+}
+
+// a visibility filter of a user, in allows and t-allows
+type Allow struct {
+	Name     string `json:"name"`     // ReplicationString	name	Filter name
+	Grp      bool   `json:"grp"`      // ReplicationString	grp	If true, the name is a group name
+	Visible  bool   `json:"visible"`  // ReplicationBool	visible	Visible
+	Online   bool   `json:"online"`   // ReplicationBool	online	Online
+	Presence bool   `json:"presence"` // ReplicationBool	presence	Presence
+	Otf      bool   `json:"otf"`      // ReplicationBool	otf	On the phone
+	Note     bool   `json:"note"`     // ReplicationBool	note	Presence note
+	Dialog   bool   `json:"dialog"`   // ReplicationBool	dialog	Calls
+	Ids      bool   `json:"ids"`      // ReplicationBool	ids	Calls with id
+}
+
+// a group membership of a user
+type Grp struct {
+	Name string `json:"name"` // ReplicationString	name	Group name
+	Mode string `json:"mode"` // ReplicationString	mode	Mode
+	Dyn  string `json:"dyn"`  // ReplicationString	dyn	Dynamic
+}
+
+// a device of a user
+type Device struct {
+	Hw       string `json:"hw"`        // ReplicationString	hw	Hardware ID
+	Text     string `json:"text"`      // ReplicationString	text	Name
+	App      string `json:"app"`       // ReplicationString	app	App
+	Admin    bool   `json:"admin"`     // ReplicationBool	admin	PBX Pwd
+	Nofilter bool   `json:"no-filter"` // ReplicationBool	no-filter	No IP Filter
+	Tls      bool   `json:"tls"`       // ReplicationBool	tls	TLS only
+	Nomob    bool   `json:"no-mob"`    // ReplicationBool	no-mob	No Mobility
+	Trusted  bool   `json:"trusted"`   // ReplicationBool	trusted	Reverse Proxy
+	Sreg     bool   `json:"sreg"`      // ReplicationBool	sreg	Single Reg.
+	Mr       bool   `json:"mr"`        // ReplicationBool	mr	Media Relay
+	Voip     string `json:"voip"`      // ReplicationString	voip	Config VOIP
+	Gkid     string `json:"gk-id"`     // ReplicationString	gk-id	Gatekeeper ID
+	Prim     string `json:"prim"`      // ReplicationString	prim	Primary gatekeeper
+}
+
+// a call diversion of a user
+type Cd struct {
+	Type    string `json:"type"`     // ReplicationString	type	Diversion type (cfu` cfb or cfnr)
+	Bool    string `json:"bool"`     // ReplicationString	bool	Boolean object
+	Boolnot bool   `json:"bool-not"` // ReplicationBool		bool-not	Not flag (boolean object)
+	E164    string `json:"e164"`     // ReplicationString	e164	Phone number
+	H323    string `json:"h323"`     // ReplicationString	h323	Username
+	Src     string `json:"src"`      // ReplicationString	src	Filters data on XML format
+}
+
+// a fork of a user
+type Fork struct {
+	E164     string `json:"e164"`     // ReplicationString	e164	Phone number
+	H323     string `json:"h323"`     // ReplicationString	h323	Username
+	Bool     string `json:"bool"`     // ReplicationString	bool	Boolean object
+	Boolnot  bool   `json:"bool-not"` // ReplicationBool	bool-not	Not flag (boolean object)
+	Mobility string `json:"mobility"` // ReplicationString	mobility	Mobility object
+	App      string `json:"app"`      // ReplicationString	app	App
+	Delay    int    `json:"delay"`    // ReplicationUnsigned	delay	Delay
+	Hw       string `json:"hw"`       // ReplicationString	hw	Device
+	Off      bool   `json:"off"`      // ReplicationBool	off	Disable
+	Cw       bool   `json:"cw"`       // ReplicationBool	cw	Call-Waiting
+	Min      int    `json:"min"`      // ReplicationUnsigned	min	Min-Alert
+	Max      int    `json:"max"`      // ReplicationUnsigned	max	Max-Alert
+}
+
+// a wakeup of a user
+type Wakeup struct {
+	H        int    `json:"h"`        // ReplicationUnsigned	h	Hour
+	M        int    `json:"m"`        // ReplicationUnsigned	m	Minute
+	S        int    `json:"s"`        // ReplicationUnsigned	s	Second
+	Name     string `json:"name"`     // ReplicationString	name
+	Num      string `json:"num"`      // ReplicationString	num
+	Retry    int    `json:"retry"`    // ReplicationUnsigned	retry
+	Mult     bool   `json:"mult"`     // ReplicationBool	mult
+	To       int    `json:"to"`       // ReplicationUnsigned	to
+	Fallback string `json:"fallback"` // ReplicationString	fallback
+	Bool     string `json:"bool"`     // ReplicationString	bool	Boolean object
+	Boolnot  bool   `json:"bool-not"` // ReplicationBool	bool-not	Not flag (boolean object)
+}
+
+// adds the email, if the user does not have it yet
+func (obj *ReplicatedObject) AddEmail(email string) {
+	for _, e := range obj.Emails {
+		if strings.EqualFold(e.Email, email) {
+			return
+		}
+	}
+	obj.Emails = append(obj.Emails, Email{Email: email})
+}
+
+// returns true if the user is a member of the group
+func (obj *ReplicatedObject) InGrp(name string) bool {
+	for _, grp := range obj.Grps {
+		if grp.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// adds the user to the group, if it is not a member yet
+func (obj *ReplicatedObject) AddGrp(grp Grp) {
+	if !obj.InGrp(grp.Name) {
+		obj.Grps = append(obj.Grps, grp)
+	}
+}
+
+// removes the user from the group
+func (obj *ReplicatedObject) RemoveGrp(name string) {
+	grps := []Grp{}
+	for _, grp := range obj.Grps {
+		if grp.Name != name {
+			grps = append(grps, grp)
+		}
+	}
+	obj.Grps = grps
+}
+
+// replaces the forks of the user
+func (obj *ReplicatedObject) SetForks(forks ...Fork) {
+	obj.Forks = append([]Fork{}, forks...)
+}
+
+// replaces the call diversion of the type (cfu, cfb or cfnr) or adds it
+func (obj *ReplicatedObject) SetCd(cd Cd) {
+	for i := range obj.Cds {
+		if obj.Cds[i].Type == cd.Type {
+			obj.Cds[i] = cd
+			return
+		}
+	}
+	obj.Cds = append(obj.Cds, cd)
 }
 
 // returns the Pseudo Type of the Object based on the Xml based Pseudo Data
@@ -206,17 +366,18 @@ type PseudoTypeXml struct {
 }
 
 type PseudoTypeXmlApp struct {
-	URL           string `xml:"url,attr"`
-	WebSocket     bool   `xml:"websocket,attr"`
-	PBX           bool   `xml:"pbx,attr"`
-	PBXSignal     bool   `xml:"pbxsignal,attr"`
-	EPSignal      bool   `xml:"epsignal,attr"`
-	Messages      bool   `xml:"messages,attr"`
-	TableUsers    bool   `xml:"tableusers,attr"`
-	Admin         bool   `xml:"admin,attr"`
-	Services      bool   `xml:"services,attr"`
-	RCC           bool   `xml:"rcc,attr"`
-	Impersonation bool   `xml:"impersonation,attr"`
+	URL           string     `xml:"url,attr,omitempty"`
+	WebSocket     bool       `xml:"websocket,attr,omitempty"`
+	PBX           bool       `xml:"pbx,attr,omitempty"`
+	PBXSignal     bool       `xml:"pbxsignal,attr,omitempty"`
+	EPSignal      bool       `xml:"epsignal,attr,omitempty"`
+	Messages      bool       `xml:"messages,attr,omitempty"`
+	TableUsers    bool       `xml:"tableusers,attr,omitempty"`
+	Admin         bool       `xml:"admin,attr,omitempty"`
+	Services      bool       `xml:"services,attr,omitempty"`
+	RCC           bool       `xml:"rcc,attr,omitempty"`
+	Impersonation bool       `xml:"impersonation,attr,omitempty"`
+	Attrs         []xml.Attr `xml:",any,attr"` // the attributes not modelled above
 }
 
 var (
@@ -348,3 +509,11 @@ const PbxTableUsersEventInitialDone = 5
 const PbxTableUsersEventAdd = 10
 const PbxTableUsersEventUpdate = 20
 const PbxTableUsersEventDelete = 30
+
+func (event PbxTableUsersEvent) GetType() int {
+	return event.Type
+}
+
+func (event PbxTableUsersEvent) GetConnection() *service.AppServicePbxConnection {
+	return event.Connection
+}
@@ -1,11 +1,39 @@
 package rcc
 
 import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/events"
 	log "github.com/sirupsen/logrus"
 )
 
+// the users and calls of a connection
+type connectionState struct {
+	users   map[string]UserInfo // by guid
+	calls   map[int]*CallState  // by call id
+	deleted map[int]time.Time   // the time of the del of the calls deleted within DeletedCallMemory, by call id
+}
+
 type RCC struct {
+	events.Publisher[RCCEvent]
+
+	// sends Initialize on connect, with calls of all users if InitializeCalls is set
+	InitializeOnConnect bool
+	InitializeCalls     bool
+
+	mu          sync.Mutex
+	connections map[*service.AppServicePbxConnection]*connectionState
+}
+
+func NewRCC() *RCC {
+	return &RCC{
+		connections: map[*service.AppServicePbxConnection]*connectionState{},
+	}
 }
 
 func (api *RCC) GetApiName() string {
@@ -13,13 +41,208 @@ func (api *RCC) GetApiName() string {
 }
 
 func (api *RCC) OnConnect(connection *service.AppServicePbxConnection) {
-	log.WithField("api", api.GetApiName()).Warn("OnConnect not implemented ")
+	api.mu.Lock()
+	api.state(connection)
+	api.mu.Unlock()
+	api.Publish(RCCEvent{Type: RCCEventConnect, Connection: connection})
+	if api.InitializeOnConnect {
+		if err := api.Initialize(connection, api.InitializeCalls); err != nil {
+			log.Errorf("RCC: sending Initialize failed: %v", err)
+		}
+	}
 }
 
 func (api *RCC) OnDisconnect(connection *service.AppServicePbxConnection) {
-	log.WithField("api", api.GetApiName()).Warn("OnDisconnect not implemented ")
+	api.mu.Lock()
+	delete(api.connections, connection)
+	api.mu.Unlock()
+	api.Publish(RCCEvent{Type: RCCEventDisconnect, Connection: connection})
 }
 
 func (api *RCC) HandleMessage(connection *service.AppServicePbxConnection, msg *service.BaseMessage, message []byte) {
-	log.WithField("api", api.GetApiName()).Warn("HandleMessage not implemented ")
+	switch msg.Mt {
+	case "InitializeResult":
+		msg := InitializeResult{}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Errorf("RCC: error unmarshalling message: %v", err)
+			return
+		}
+		api.Publish(RCCEvent{Type: RCCEventInitializeResult, InitializeResult: &msg, Connection: connection})
+	case "UserInfo":
+		msg := UserInfo{}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Errorf("RCC: error unmarshalling message: %v", err)
+			return
+		}
+		api.setUserInfo(connection, msg)
+		api.Publish(RCCEvent{Type: RCCEventUserInfo, UserInfo: &msg, Connection: connection})
+	case "CallInfo":
+		msg := CallInfo{}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Errorf("RCC: error unmarshalling message: %v", err)
+			return
+		}
+		call, ok := api.setCallInfo(connection, msg)
+		if !ok {
+			log.Debugf("RCC: %s of the deleted call %d ignored", msg.Msg, msg.Call)
+			return
+		}
+		api.Publish(RCCEvent{Type: RCCEventCallInfo, CallInfo: &msg, Call: &call, Connection: connection})
+	default:
+		// the results of commands sent with Call do not get here
+		log.Warnf("unknown message received: %s", msg.Mt)
+	}
+}
+
+// sends Initialize, the users are sent as UserInfo events followed by a InitializeResult event
+func (api *RCC) Initialize(connection *service.AppServicePbxConnection, calls bool) error {
+	mbytes, err := json.Marshal(NewInitialize(calls, ""))
+	if err != nil {
+		return err
+	}
+	return connection.WriteMessage(mbytes)
+}
+
+// the mutex has to be locked
+func (api *RCC) state(connection *service.AppServicePbxConnection) *connectionState {
+	if api.connections == nil {
+		api.connections = map[*service.AppServicePbxConnection]*connectionState{}
+	}
+	state, ok := api.connections[connection]
+	if !ok {
+		state = &connectionState{users: map[string]UserInfo{}, calls: map[int]*CallState{}, deleted: map[int]time.Time{}}
+		api.connections[connection] = state
+	}
+	return state
+}
+
+func (api *RCC) setUserInfo(connection *service.AppServicePbxConnection, user UserInfo) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	state := api.state(connection)
+	if user.Del {
+		delete(state.users, user.Guid)
+		return
+	}
+	state.users[user.Guid] = user
+}
+
+/*
+updates the state of the call and returns a copy of it.
+
+A CallInfo handled after the del of its call, like a late conn, does not create the call again,
+false is returned for it. Only a new setup with the id of a deleted call creates a call.
+*/
+func (api *RCC) setCallInfo(connection *service.AppServicePbxConnection, info CallInfo) (CallState, bool) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	state := api.state(connection)
+	// x- for changes by the user, r- for changes by the peer
+	by, change, found := strings.Cut(info.Msg, "-")
+	if !found {
+		change = info.Msg
+	}
+	call, ok := state.calls[info.Call]
+	if !ok {
+		if at, deleted := state.deleted[info.Call]; deleted && change != CallInfoSetup && time.Since(at) < DeletedCallMemory {
+			return CallState{}, false
+		}
+		delete(state.deleted, info.Call)
+		call = &CallState{User: info.User, Call: info.Call, Started: time.Now()}
+		state.calls[info.Call] = call
+	}
+	if !ok && change == CallInfoSetup {
+		call.Outgoing = by == "x"
+	}
+	call.Msg = info.Msg
+	if info.H323 != "" {
+		call.H323 = info.H323
+	}
+	if info.Peer != (Peer{}) {
+		call.Peer = info.Peer
+	}
+	switch change {
+	case CallInfoConn:
+		if !call.Connected {
+			call.Connected = true
+			call.ConnectedAt = time.Now()
+		}
+	case CallInfoHold:
+		call.Held = true
+	case CallInfoRetrieve:
+		call.Held = false
+	case CallInfoRel:
+		call.Released = true
+	case CallInfoDel:
+		call.Released = true
+		delete(state.calls, info.Call)
+		state.setDeleted(info.Call)
+	}
+	return *call, true
+}
+
+// remembers the deleted call and forgets the ones deleted before DeletedCallMemory
+func (state *connectionState) setDeleted(call int) {
+	now := time.Now()
+	for id, at := range state.deleted {
+		if now.Sub(at) >= DeletedCallMemory {
+			delete(state.deleted, id)
+		}
+	}
+	state.deleted[call] = now
+}
+
+// returns the users of the connection, sorted by h323
+func (api *RCC) GetUsers(connection *service.AppServicePbxConnection) []UserInfo {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	state, ok := api.connections[connection]
+	if !ok {
+		return []UserInfo{}
+	}
+	users := make([]UserInfo, 0, len(state.users))
+	for _, user := range state.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].H323 < users[j].H323 })
+	return users
+}
+
+// returns the calls of the connection, sorted by id
+func (api *RCC) GetCalls(connection *service.AppServicePbxConnection) []CallState {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	state, ok := api.connections[connection]
+	if !ok {
+		return []CallState{}
+	}
+	calls := make([]CallState, 0, len(state.calls))
+	for _, call := range state.calls {
+		calls = append(calls, *call)
+	}
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Call < calls[j].Call })
+	return calls
+}
+
+// returns the calls of the user with the id of UserInitializeResult
+func (api *RCC) GetCallsOfUser(connection *service.AppServicePbxConnection, user int) []CallState {
+	calls := []CallState{}
+	for _, call := range api.GetCalls(connection) {
+		if call.User == user {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// returns the call with the id
+func (api *RCC) GetCall(connection *service.AppServicePbxConnection, call int) (CallState, bool) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if state, ok := api.connections[connection]; ok {
+		if c, ok := state.calls[call]; ok {
+			return *c, true
+		}
+	}
+	return CallState{}, false
 }
@@ -0,0 +1,153 @@
+package rcc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/events"
+	"github.com/ricoschulte/go-myapps/service/rcc"
+	"github.com/ricoschulte/go-myapps/service/servicetest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRCC_Commands(t *testing.T) {
+	requests := make(chan map[string]interface{}, 20)
+	connection := servicetest.NewConnection(t, func(peer *websocket.Conn, message map[string]interface{}) {
+		requests <- message
+		result := map[string]interface{}{"api": "RCC", "mt": message["mt"].(string) + "Result", "src": message["src"]}
+		switch message["mt"] {
+		case "UserInitialize":
+			result["user"] = 7
+		case "UserCall":
+			result["call"] = 42
+		case "UserDTMF":
+			result["error"] = 1
+			result["errorText"] = "not connected"
+		}
+		peer.WriteJSON(result)
+	})
+	connection.Authenticated = true
+	go connection.Loop()
+	ctx := context.Background()
+
+	user, err := rcc.CallUserInitialize(ctx, connection, "Alice", "", true, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 7, user.User)
+	call, err := rcc.CallUserCall(ctx, connection, user.User, "123", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 42, call.Call)
+
+	tests := []struct {
+		name string
+		call func() error
+		want map[string]interface{}
+	}{
+		{"connect", func() error { _, err := rcc.CallUserConnect(ctx, connection, 42); return err }, map[string]interface{}{"mt": "UserConnect", "call": float64(42)}},
+		{"hold", func() error { _, err := rcc.CallUserHold(ctx, connection, 42); return err }, map[string]interface{}{"mt": "UserHold", "call": float64(42)}},
+		{"retrieve", func() error { _, err := rcc.CallUserRetrieve(ctx, connection, 42); return err }, map[string]interface{}{"mt": "UserRetrieve", "call": float64(42)}},
+		{"transfer", func() error { _, err := rcc.CallUserTransfer(ctx, connection, 42, 43); return err }, map[string]interface{}{"mt": "UserTransfer", "a": float64(42), "b": float64(43)}},
+		{"redirect", func() error { _, err := rcc.CallUserRedirect(ctx, connection, 42, "", "bob"); return err }, map[string]interface{}{"mt": "UserRedirect", "call": float64(42), "h323": "bob"}},
+		{"disc", func() error { _, err := rcc.CallUserClear(ctx, connection, 42); return err }, map[string]interface{}{"mt": "UserClear", "call": float64(42)}},
+		{"end", func() error { _, err := rcc.CallUserEnd(ctx, connection, 7); return err }, map[string]interface{}{"mt": "UserEnd", "user": float64(7)}},
+	}
+	<-requests
+	<-requests
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.NoError(t, test.call())
+			request := <-requests
+			assert.Equal(t, "RCC", request["api"])
+			for key, value := range test.want {
+				assert.Equal(t, value, request[key], key)
+			}
+		})
+	}
+
+	_, err = rcc.CallUserDTMF(ctx, connection, 42, "12#")
+	callErr := &service.CallError{}
+	assert.ErrorAs(t, err, &callErr)
+	assert.Equal(t, "12#", (<-requests)["digits"])
+}
+
+func TestRCC_CallState(t *testing.T) {
+	initialized := make(chan map[string]interface{}, 1)
+	connection := servicetest.NewConnection(t, func(peer *websocket.Conn, message map[string]interface{}) {
+		initialized <- message
+	})
+	api := rcc.NewRCC()
+	api.InitializeOnConnect = true
+	api.InitializeCalls = true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	callEvents := api.Subscribe(ctx, events.ForTypes[rcc.RCCEvent](rcc.RCCEventCallInfo))
+
+	api.OnConnect(connection)
+	request := <-initialized
+	assert.Equal(t, "Initialize", request["mt"])
+	assert.Equal(t, true, request["calls"])
+
+	handle := func(mt, message string) {
+		api.HandleMessage(connection, &service.BaseMessage{Api: "RCC", Mt: mt}, []byte(message))
+	}
+	handle("UserInfo", `{"api":"RCC","mt":"UserInfo","guid":"g1","h323":"alice","cn":"Alice","e164":"10"}`)
+	handle("UserInfo", `{"api":"RCC","mt":"UserInfo","guid":"g2","h323":"bob","cn":"Bob","e164":"20"}`)
+	handle("UserInfo", `{"api":"RCC","mt":"UserInfo","guid":"g2","del":true}`)
+	users := api.GetUsers(connection)
+	if assert.Len(t, users, 1) {
+		assert.Equal(t, "alice", users[0].H323)
+	}
+
+	tests := []struct {
+		msg   string
+		check func(t *testing.T, call rcc.CallState)
+	}{
+		{"r-setup", func(t *testing.T, call rcc.CallState) {
+			assert.False(t, call.Outgoing)
+			assert.Equal(t, "0301", call.Peer.E164)
+		}},
+		{"r-alert", func(t *testing.T, call rcc.CallState) { assert.False(t, call.Connected) }},
+		{"x-conn", func(t *testing.T, call rcc.CallState) {
+			assert.True(t, call.Connected)
+			assert.False(t, call.ConnectedAt.IsZero())
+		}},
+		{"x-hold", func(t *testing.T, call rcc.CallState) { assert.True(t, call.Held) }},
+		{"x-retrieve", func(t *testing.T, call rcc.CallState) { assert.False(t, call.Held) }},
+		{"r-rel", func(t *testing.T, call rcc.CallState) { assert.True(t, call.Released) }},
+	}
+	for _, test := range tests {
+		t.Run(test.msg, func(t *testing.T) {
+			handle("CallInfo", `{"api":"RCC","mt":"CallInfo","user":7,"call":1,"msg":"`+test.msg+`","peer":{"e164":"0301"}}`)
+			event := <-callEvents.C
+			assert.Equal(t, test.msg, event.CallInfo.Msg)
+			test.check(t, *event.Call)
+			call, ok := api.GetCall(connection, 1)
+			assert.True(t, ok)
+			assert.Equal(t, *event.Call, call)
+		})
+	}
+	assert.Len(t, api.GetCallsOfUser(connection, 7), 1)
+	assert.Empty(t, api.GetCallsOfUser(connection, 8))
+
+	handle("CallInfo", `{"api":"RCC","mt":"CallInfo","user":7,"call":1,"msg":"del"}`)
+	assert.Empty(t, api.GetCalls(connection))
+	<-callEvents.C
+
+	// a CallInfo handled after the del does not create the call again
+	handle("CallInfo", `{"api":"RCC","mt":"CallInfo","user":7,"call":1,"msg":"x-conn"}`)
+	assert.Empty(t, api.GetCalls(connection))
+	assert.Empty(t, callEvents.C)
+	// a new call with the id
+	handle("CallInfo", `{"api":"RCC","mt":"CallInfo","user":7,"call":1,"msg":"x-setup"}`)
+	assert.True(t, (<-callEvents.C).Call.Outgoing)
+	assert.Len(t, api.GetCalls(connection), 1)
+
+	// an invalid CallInfo is not applied
+	handle("CallInfo", `{"api":"RCC","mt":"CallInfo","user":7,"call":"2","msg":"x-setup"}`)
+	assert.Len(t, api.GetCalls(connection), 1)
+	assert.Empty(t, callEvents.C)
+
+	api.OnDisconnect(connection)
+	assert.Empty(t, api.GetUsers(connection))
+}
@@ -0,0 +1,57 @@
+package rcc
+
+import (
+	"context"
+
+	"github.com/ricoschulte/go-myapps/service"
+)
+
+// starts the control of the calls of the user with the cn and returns the id of the user for the commands
+func CallUserInitialize(ctx context.Context, connection *service.AppServicePbxConnection, cn, hw string, xfer, disc bool) (*UserInitializeResult, error) {
+	return service.CallFor[UserInitializeResult](ctx, connection, "RCC", NewUserInitialize(cn, hw, xfer, disc, ""))
+}
+
+// ends the control of the user
+func CallUserEnd(ctx context.Context, connection *service.AppServicePbxConnection, user int) (*UserResult, error) {
+	return service.CallFor[UserResult](ctx, connection, "RCC", NewUserEnd(user, ""))
+}
+
+// dials the number, or the name if the number is empty, and returns the id of the call
+func CallUserCall(ctx context.Context, connection *service.AppServicePbxConnection, user int, e164, h323 string) (*UserCallResult, error) {
+	return service.CallFor[UserCallResult](ctx, connection, "RCC", NewUserCall(user, e164, h323, ""))
+}
+
+// connects the alerting call
+func CallUserConnect(ctx context.Context, connection *service.AppServicePbxConnection, call int) (*UserResult, error) {
+	return service.CallFor[UserResult](ctx, connection, "RCC", NewUserConnect(call, ""))
+}
+
+// disconnects the call
+func CallUserClear(ctx context.Context, connection *service.AppServicePbxConnection, call int) (*UserResult, error) {
+	return service.CallFor[UserResult](ctx, connection, "RCC", NewUserClear(call, ""))
+}
+
+// holds the call
+func CallUserHold(ctx context.Context, connection *service.AppServicePbxConnection, call int) (*UserResult, error) {
+	return service.CallFor[UserResult](ctx, connection, "RCC", NewUserHold(call, false, ""))
+}
+
+// retrieves the held call
+func CallUserRetrieve(ctx context.Context, connection *service.AppServicePbxConnection, call int) (*UserResult, error) {
+	return service.CallFor[UserResult](ctx, connection, "RCC", NewUserRetrieve(call, ""))
+}
+
+// connects the peers of the calls a and b
+func CallUserTransfer(ctx context.Context, connection *service.AppServicePbxConnection, a, b int) (*UserResult, error) {
+	return service.CallFor[UserResult](ctx, connection, "RCC", NewUserTransfer(a, b, ""))
+}
+
+// redirects the alerting call to the number, or the name if the number is empty
+func CallUserRedirect(ctx context.Context, connection *service.AppServicePbxConnection, call int, e164, h323 string) (*UserResult, error) {
+	return service.CallFor[UserResult](ctx, connection, "RCC", NewUserRedirect(call, e164, h323, ""))
+}
+
+// sends the DTMF digits on the call
+func CallUserDTMF(ctx context.Context, connection *service.AppServicePbxConnection, call int, digits string) (*UserResult, error) {
+	return service.CallFor[UserResult](ctx, connection, "RCC", NewUserDTMF(call, digits, ""))
+}
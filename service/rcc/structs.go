@@ -0,0 +1,241 @@
+package rcc
+
+import (
+	"time"
+
+	"github.com/ricoschulte/go-myapps/service"
+)
+
+func newBaseMessage(mt, src string) service.BaseMessage {
+	return service.BaseMessage{
+		Api: "RCC",
+		Mt:  mt,
+		Src: src,
+	}
+}
+
+// starts the monitoring of the users, the PBX sends a UserInfo for every user and a InitializeResult
+type Initialize struct {
+	service.BaseMessage
+	Calls bool `json:"calls,omitempty"` // the PBX sends CallInfo for the calls of all users
+}
+
+func NewInitialize(calls bool, src string) *Initialize {
+	return &Initialize{
+		BaseMessage: newBaseMessage("Initialize", src),
+		Calls:       calls,
+	}
+}
+
+type InitializeResult struct {
+	service.BaseMessage
+}
+
+// a user the app can control, sent after Initialize and when a user changes
+type UserInfo struct {
+	service.BaseMessage
+	Guid   string `json:"guid"`
+	Cn     string `json:"cn"`
+	Dn     string `json:"dn"`
+	H323   string `json:"h323"`
+	E164   string `json:"e164"`
+	Domain string `json:"domain"`
+	Del    bool   `json:"del,omitempty"` // the user was deleted
+}
+
+// starts the control of the calls of a user, the result has the id of the user for the call commands
+type UserInitialize struct {
+	service.BaseMessage
+	Cn   string `json:"cn"`
+	Hw   string `json:"hw,omitempty"`   // the device of the user the calls are made with
+	Xfer bool   `json:"xfer,omitempty"` // enables UserTransfer
+	Disc bool   `json:"disc,omitempty"` // calls are disconnected when the user is ended
+}
+
+func NewUserInitialize(cn, hw string, xfer, disc bool, src string) *UserInitialize {
+	return &UserInitialize{
+		BaseMessage: newBaseMessage("UserInitialize", src),
+		Cn:          cn,
+		Hw:          hw,
+		Xfer:        xfer,
+		Disc:        disc,
+	}
+}
+
+type UserInitializeResult struct {
+	service.BaseMessage
+	User int `json:"user"`
+}
+
+// ends the control of the user
+type UserEnd struct {
+	service.BaseMessage
+	User int `json:"user"`
+}
+
+func NewUserEnd(user int, src string) *UserEnd {
+	return &UserEnd{
+		BaseMessage: newBaseMessage("UserEnd", src),
+		User:        user,
+	}
+}
+
+// dials the number or name with the user
+type UserCall struct {
+	service.BaseMessage
+	User int    `json:"user"`
+	E164 string `json:"e164,omitempty"`
+	H323 string `json:"h323,omitempty"`
+}
+
+func NewUserCall(user int, e164, h323, src string) *UserCall {
+	return &UserCall{
+		BaseMessage: newBaseMessage("UserCall", src),
+		User:        user,
+		E164:        e164,
+		H323:        h323,
+	}
+}
+
+type UserCallResult struct {
+	service.BaseMessage
+	Call int `json:"call"` // the id of the new call
+}
+
+// a command for a call: UserConnect, UserClear, UserHold or UserRetrieve
+type UserCallCommand struct {
+	service.BaseMessage
+	Call   int  `json:"call"`
+	Remote bool `json:"remote,omitempty"` // UserHold only, the call is held by the peer
+}
+
+// connects an alerting call
+func NewUserConnect(call int, src string) *UserCallCommand {
+	return &UserCallCommand{BaseMessage: newBaseMessage("UserConnect", src), Call: call}
+}
+
+// disconnects the call
+func NewUserClear(call int, src string) *UserCallCommand {
+	return &UserCallCommand{BaseMessage: newBaseMessage("UserClear", src), Call: call}
+}
+
+// holds the call
+func NewUserHold(call int, remote bool, src string) *UserCallCommand {
+	return &UserCallCommand{BaseMessage: newBaseMessage("UserHold", src), Call: call, Remote: remote}
+}
+
+// retrieves the held call
+func NewUserRetrieve(call int, src string) *UserCallCommand {
+	return &UserCallCommand{BaseMessage: newBaseMessage("UserRetrieve", src), Call: call}
+}
+
+// connects the peers of the two calls of the user
+type UserTransfer struct {
+	service.BaseMessage
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func NewUserTransfer(a, b int, src string) *UserTransfer {
+	return &UserTransfer{BaseMessage: newBaseMessage("UserTransfer", src), A: a, B: b}
+}
+
+// redirects the alerting call to the number or name
+type UserRedirect struct {
+	service.BaseMessage
+	Call int    `json:"call"`
+	E164 string `json:"e164,omitempty"`
+	H323 string `json:"h323,omitempty"`
+}
+
+func NewUserRedirect(call int, e164, h323, src string) *UserRedirect {
+	return &UserRedirect{BaseMessage: newBaseMessage("UserRedirect", src), Call: call, E164: e164, H323: h323}
+}
+
+// sends DTMF digits on the connected call
+type UserDTMF struct {
+	service.BaseMessage
+	Call   int    `json:"call"`
+	Digits string `json:"digits"`
+}
+
+func NewUserDTMF(call int, digits, src string) *UserDTMF {
+	return &UserDTMF{BaseMessage: newBaseMessage("UserDTMF", src), Call: call, Digits: digits}
+}
+
+// the result of a command without data
+type UserResult struct {
+	service.BaseMessage
+	Error     int    `json:"error,omitempty"`
+	Errortext string `json:"errorText,omitempty"`
+}
+
+type Peer struct {
+	E164 string `json:"e164,omitempty"`
+	H323 string `json:"h323,omitempty"`
+	Dn   string `json:"dn,omitempty"`
+}
+
+// a change of a call of a user
+type CallInfo struct {
+	service.BaseMessage
+	User  int    `json:"user"`
+	Call  int    `json:"call"`
+	Msg   string `json:"msg"`   // the change, like "r-alert", "x-conn", "x-hold" or "del". x: by the user, r: by the peer
+	State int    `json:"state"` // the state of the call on the PBX
+	Peer  Peer   `json:"peer"`
+	Guid  string `json:"guid,omitempty"` // the guid of the user
+	H323  string `json:"h323,omitempty"` // the name of the user
+}
+
+// the values of CallInfo.Msg
+const (
+	CallInfoSetup    = "setup"
+	CallInfoAlert    = "alert"
+	CallInfoConn     = "conn"
+	CallInfoHold     = "hold"
+	CallInfoRetrieve = "retrieve"
+	CallInfoRel      = "rel"
+	CallInfoDel      = "del"
+)
+
+// the state of a call tracked from the CallInfo messages
+type CallState struct {
+	User        int
+	Call        int
+	H323        string // the name of the user
+	Peer        Peer
+	Outgoing    bool // the call was set up by the user
+	Msg         string
+	Connected   bool
+	Held        bool // held by the user or the peer
+	Released    bool // the call is released but not deleted yet
+	Started     time.Time
+	ConnectedAt time.Time
+}
+
+type RCCEvent struct {
+	Type             int
+	Connection       *service.AppServicePbxConnection
+	InitializeResult *InitializeResult
+	UserInfo         *UserInfo
+	CallInfo         *CallInfo
+	Call             *CallState // a copy of the state of the call after the CallInfo
+}
+
+const RCCEventDisconnect = -20
+const RCCEventConnect = -10
+const RCCEventInitializeResult = 10
+const RCCEventUserInfo = 20
+const RCCEventCallInfo = 30
+
+// the time a deleted call is remembered, so CallInfo handled after its del does not create it again
+var DeletedCallMemory = 10 * time.Second
+
+func (event RCCEvent) GetType() int {
+	return event.Type
+}
+
+func (event RCCEvent) GetConnection() *service.AppServicePbxConnection {
+	return event.Connection
+}
@@ -0,0 +1,452 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const ReplicatorApiName = "com.innovaphone.replicator"
+
+// a row of a replicated table, by column name. Every row has a unique "guid" column.
+type ReplicatorRow map[string]interface{}
+
+func (row ReplicatorRow) Guid() string {
+	guid, _ := row["guid"].(string)
+	return guid
+}
+
+// returns a copy of the row with only the given columns. the guid is always kept.
+func (row ReplicatorRow) Select(columns map[string]ReplicatorColumn) ReplicatorRow {
+	if len(columns) == 0 {
+		return row
+	}
+	selected := ReplicatorRow{"guid": row["guid"]}
+	for name := range columns {
+		if value, ok := row[name]; ok {
+			selected[name] = value
+		}
+	}
+	return selected
+}
+
+// The backing store of a Replicator. It must be safe for concurrent use.
+type ReplicatorStore interface {
+	List() ([]ReplicatorRow, error)         // all rows in a stable order
+	Get(guid string) (ReplicatorRow, error) // returns nil without error, if there is no row with the guid
+	Put(row ReplicatorRow) error            // adds or replaces the row with the guid of the row
+	Delete(guid string) error               // deletes the row, deleting a unknown guid is not an error
+}
+
+// a ReplicatorStore that holds the rows in memory
+type MemoryReplicatorStore struct {
+	mu   sync.Mutex
+	rows map[string]ReplicatorRow
+}
+
+func NewMemoryReplicatorStore() *MemoryReplicatorStore {
+	return &MemoryReplicatorStore{rows: map[string]ReplicatorRow{}}
+}
+
+func (store *MemoryReplicatorStore) List() ([]ReplicatorRow, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	guids := make([]string, 0, len(store.rows))
+	for guid := range store.rows {
+		guids = append(guids, guid)
+	}
+	sort.Strings(guids)
+	rows := make([]ReplicatorRow, 0, len(guids))
+	for _, guid := range guids {
+		rows = append(rows, store.rows[guid])
+	}
+	return rows, nil
+}
+
+func (store *MemoryReplicatorStore) Get(guid string) (ReplicatorRow, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.rows[guid], nil
+}
+
+func (store *MemoryReplicatorStore) Put(row ReplicatorRow) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.rows[row.Guid()] = row
+	return nil
+}
+
+func (store *MemoryReplicatorStore) Delete(guid string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.rows, guid)
+	return nil
+}
+
+type ReplicatorColumn struct {
+	Update bool `json:"update"` // send ReplicateUpdate when the value of the column changes
+}
+
+// sent by the consumer to start the replication
+type ReplicatorStart struct {
+	BaseMessage
+	Add     bool                        `json:"add,omitempty"` // the consumer wants to receive ReplicateAdd
+	Del     bool                        `json:"del,omitempty"` // the consumer wants to receive ReplicateDel
+	Columns map[string]ReplicatorColumn `json:"columns"`       // the columns to replicate, all columns if empty
+	Pseudo  []string                    `json:"pseudo,omitempty"`
+}
+
+// ReplicateNextResult, ReplicateAdd, ReplicateUpdate and ReplicateDel in both directions
+type ReplicatorRowMessage struct {
+	BaseMessage
+	Columns ReplicatorRow `json:"columns,omitempty"`
+}
+
+type ReplicatorResult struct {
+	BaseMessage
+	Guid      string `json:"guid,omitempty"`
+	Error     int    `json:"error,omitempty"`
+	Errortext string `json:"errorText,omitempty"`
+}
+
+// the replication state of one consumer
+type replicatorSession struct {
+	connection *AppServicePbxConnection
+	start      ReplicatorStart
+	rows       []ReplicatorRow        // snapshot of the rows for the initial replication
+	next       int                    // index of the next row of the initial replication
+	pending    []ReplicatorRowMessage // changes during the initial replication
+	done       bool                   // the initial replication is done
+	out        []interface{}          // the messages to write to the consumer, in order
+	sending    bool                   // a go routine writes the messages of out
+}
+
+// Implements the publisher side of the com.innovaphone.replicator api.
+// It exposes one table of the app service to the PBX or other apps, the rows are kept in Store.
+// Register it on the AppService with RegisterHandler.
+// Changes to the table are made with Add, Update and Delete to replicate them to the consumers.
+type Replicator struct {
+	ApiName  string // the name of the api, ReplicatorApiName by default
+	Store    ReplicatorStore
+	Writable bool // consumers are allowed to add, update and delete rows
+
+	changes  sync.Mutex // serializes the changes of the Store, their publishing and the snapshots of ReplicateStart
+	mu       sync.Mutex // guards the sessions, it is not held while writing to a connection
+	sessions map[*AppServicePbxConnection]*replicatorSession
+}
+
+func NewReplicator(store ReplicatorStore) *Replicator {
+	return &Replicator{
+		ApiName:  ReplicatorApiName,
+		Store:    store,
+		sessions: map[*AppServicePbxConnection]*replicatorSession{},
+	}
+}
+
+func (api *Replicator) GetApiName() string {
+	return api.ApiName
+}
+
+func (api *Replicator) OnConnect(connection *AppServicePbxConnection) {}
+
+func (api *Replicator) OnDisconnect(connection *AppServicePbxConnection) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	delete(api.sessions, connection)
+}
+
+func (api *Replicator) HandleMessage(connection *AppServicePbxConnection, msg *BaseMessage, message []byte) {
+	switch msg.Mt {
+	case "ReplicateStart":
+		start := ReplicatorStart{}
+		if err := json.Unmarshal(message, &start); err != nil {
+			connection.log().Errorf("Replicator: error unmarshalling message: %v", err)
+			return
+		}
+		api.handleStart(connection, start)
+	case "ReplicateNext":
+		api.handleNext(connection, msg.Src)
+	case "ReplicateAdd", "ReplicateUpdate", "ReplicateDel":
+		row := ReplicatorRowMessage{}
+		if err := json.Unmarshal(message, &row); err != nil {
+			connection.log().Errorf("Replicator: error unmarshalling message: %v", err)
+			return
+		}
+		api.handleChange(connection, row)
+	default:
+		log.Warnf("Replicator: unknown message received: %s", msg.Mt)
+	}
+}
+
+func (api *Replicator) handleStart(connection *AppServicePbxConnection, start ReplicatorStart) {
+	result := ReplicatorResult{BaseMessage: api.message("ReplicateStartResult", start.Src)}
+
+	// no change can happen between the snapshot and the registration of the session,
+	// the changes after it are pending until the initial replication is done
+	api.changes.Lock()
+	rows, err := api.Store.List()
+	if err != nil {
+		api.changes.Unlock()
+		connection.log().Errorf("Replicator: listing rows failed: %v", err)
+		result.Error = 1
+		result.Errortext = err.Error()
+		api.write(connection, result)
+		return
+	}
+	session := &replicatorSession{connection: connection, start: start, rows: rows}
+	api.mu.Lock()
+	if api.sessions == nil {
+		api.sessions = map[*AppServicePbxConnection]*replicatorSession{}
+	}
+	api.sessions[connection] = session
+	session.out = append(session.out, result)
+	api.mu.Unlock()
+	api.changes.Unlock()
+	api.flush(session)
+}
+
+func (api *Replicator) handleNext(connection *AppServicePbxConnection, src string) {
+	api.mu.Lock()
+	session, ok := api.sessions[connection]
+	if !ok {
+		api.mu.Unlock()
+		connection.log().Warn("Replicator: ReplicateNext without ReplicateStart")
+		api.write(connection, ReplicatorResult{BaseMessage: api.message("ReplicateNextResult", src), Error: 1, Errortext: "replication not started"})
+		return
+	}
+
+	// queued while locked, so the changes published meanwhile can not overtake the result
+	result := ReplicatorRowMessage{BaseMessage: api.message("ReplicateNextResult", src)}
+	if session.next < len(session.rows) {
+		result.Columns = session.rows[session.next].Select(session.start.Columns)
+		session.next++
+		session.out = append(session.out, result)
+	} else {
+		session.out = append(session.out, result)
+		if !session.done {
+			// the empty result marks the end of the initial replication, changes made in the meantime follow
+			session.done = true
+			session.rows = nil
+			for _, change := range session.pending {
+				session.out = append(session.out, change)
+			}
+			session.pending = nil
+		}
+	}
+	api.mu.Unlock()
+	api.flush(session)
+}
+
+// writes the queued messages of the sessions
+func (api *Replicator) flush(sessions ...*replicatorSession) {
+	for _, session := range sessions {
+		api.flushSession(session)
+	}
+}
+
+// writes the queued messages of the session. Only one go routine writes them, so their order is kept.
+func (api *Replicator) flushSession(session *replicatorSession) {
+	api.mu.Lock()
+	if session.sending {
+		// the go routine that is writing takes the new messages too
+		api.mu.Unlock()
+		return
+	}
+	session.sending = true
+	for len(session.out) > 0 {
+		out := session.out
+		session.out = nil
+		api.mu.Unlock()
+		for _, msg := range out {
+			api.write(session.connection, msg)
+		}
+		api.mu.Lock()
+	}
+	session.sending = false
+	api.mu.Unlock()
+}
+
+// applies a change made by a consumer
+func (api *Replicator) handleChange(connection *AppServicePbxConnection, change ReplicatorRowMessage) {
+	result := ReplicatorResult{BaseMessage: api.message(change.Mt+"Result", change.Src), Guid: change.Columns.Guid()}
+	var err error
+	if !api.Writable {
+		err = fmt.Errorf("the table is read only")
+	} else {
+		switch change.Mt {
+		case "ReplicateAdd":
+			if change.Columns == nil {
+				change.Columns = ReplicatorRow{}
+			}
+			if result.Guid == "" {
+				mu := &MyAppsUtils{}
+				result.Guid = mu.GetRandomHexString(32)
+				change.Columns["guid"] = result.Guid
+			}
+			err = api.add(change.Columns, connection)
+		case "ReplicateUpdate":
+			err = api.update(change.Columns, connection)
+		case "ReplicateDel":
+			err = api.delete(result.Guid, connection)
+		}
+	}
+	if err != nil {
+		connection.log().Warnf("Replicator: %s of '%s' failed: %v", change.Mt, result.Guid, err)
+		result.Error = 1
+		result.Errortext = err.Error()
+	}
+	api.write(connection, result)
+}
+
+// adds a row and replicates it to the consumers
+func (api *Replicator) Add(row ReplicatorRow) error {
+	return api.add(row, nil)
+}
+
+// updates a row and replicates the changed columns to the consumers
+func (api *Replicator) Update(row ReplicatorRow) error {
+	return api.update(row, nil)
+}
+
+// deletes a row and replicates the deletion to the consumers
+func (api *Replicator) Delete(guid string) error {
+	return api.delete(guid, nil)
+}
+
+func (api *Replicator) add(row ReplicatorRow, origin *AppServicePbxConnection) error {
+	if row.Guid() == "" {
+		return fmt.Errorf("the row has no guid")
+	}
+	var sessions []*replicatorSession
+	defer func() { api.flush(sessions...) }() // after the unlock of the changes
+	api.changes.Lock()
+	defer api.changes.Unlock()
+	existing, err := api.Store.Get(row.Guid())
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("a row with the guid '%s' exists already", row.Guid())
+	}
+	if err := api.Store.Put(row); err != nil {
+		return err
+	}
+	sessions = api.publish("ReplicateAdd", nil, row, origin)
+	return nil
+}
+
+func (api *Replicator) update(row ReplicatorRow, origin *AppServicePbxConnection) error {
+	var sessions []*replicatorSession
+	defer func() { api.flush(sessions...) }() // after the unlock of the changes
+	api.changes.Lock()
+	defer api.changes.Unlock()
+	existing, err := api.Store.Get(row.Guid())
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return fmt.Errorf("no row with the guid '%s'", row.Guid())
+	}
+	// the update contains only the changed columns
+	updated := ReplicatorRow{}
+	for name, value := range existing {
+		updated[name] = value
+	}
+	for name, value := range row {
+		updated[name] = value
+	}
+	if err := api.Store.Put(updated); err != nil {
+		return err
+	}
+	sessions = api.publish("ReplicateUpdate", existing, updated, origin)
+	return nil
+}
+
+func (api *Replicator) delete(guid string, origin *AppServicePbxConnection) error {
+	var sessions []*replicatorSession
+	defer func() { api.flush(sessions...) }() // after the unlock of the changes
+	api.changes.Lock()
+	defer api.changes.Unlock()
+	existing, err := api.Store.Get(guid)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		return fmt.Errorf("no row with the guid '%s'", guid)
+	}
+	if err := api.Store.Delete(guid); err != nil {
+		return err
+	}
+	sessions = api.publish("ReplicateDel", existing, ReplicatorRow{"guid": guid}, origin)
+	return nil
+}
+
+// queues the change for all consumers except the one that made it and returns the sessions to flush.
+// api.changes has to be locked.
+func (api *Replicator) publish(mt string, old ReplicatorRow, row ReplicatorRow, origin *AppServicePbxConnection) []*replicatorSession {
+	api.mu.Lock()
+	sessions := []*replicatorSession{}
+	for connection, session := range api.sessions {
+		if connection == origin {
+			continue
+		}
+		change, ok := session.filter(mt, old, row)
+		if !ok {
+			continue
+		}
+		change.BaseMessage = api.message(mt, "")
+		if !session.done {
+			session.pending = append(session.pending, change)
+			continue
+		}
+		session.out = append(session.out, change)
+		sessions = append(sessions, session)
+	}
+	api.mu.Unlock()
+	return sessions
+}
+
+// returns the change as it is sent to the consumer of the session and false, if the consumer is not interested in it
+func (session *replicatorSession) filter(mt string, old ReplicatorRow, row ReplicatorRow) (ReplicatorRowMessage, bool) {
+	switch mt {
+	case "ReplicateAdd":
+		if !session.start.Add {
+			return ReplicatorRowMessage{}, false
+		}
+	case "ReplicateDel":
+		if !session.start.Del {
+			return ReplicatorRowMessage{}, false
+		}
+	case "ReplicateUpdate":
+		if len(session.start.Columns) > 0 {
+			changed := false
+			for name, column := range session.start.Columns {
+				if column.Update && !reflect.DeepEqual(old[name], row[name]) {
+					changed = true
+					break
+				}
+			}
+			if !changed {
+				return ReplicatorRowMessage{}, false
+			}
+		}
+	}
+	return ReplicatorRowMessage{Columns: row.Select(session.start.Columns)}, true
+}
+
+func (api *Replicator) message(mt, src string) BaseMessage {
+	return BaseMessage{Api: api.ApiName, Mt: mt, Src: src}
+}
+
+func (api *Replicator) write(connection *AppServicePbxConnection, msg interface{}) {
+	message, err := json.Marshal(msg)
+	if err != nil {
+		connection.log().Errorf("Replicator: error marshalling message: %v", err)
+		return
+	}
+	connection.WriteMessage(message)
+}
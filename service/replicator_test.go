@@ -0,0 +1,165 @@
+package service_test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicator(t *testing.T) {
+	connection, client := newTestConnection(t, &service.AppService{})
+
+	store := service.NewMemoryReplicatorStore()
+	store.Put(service.ReplicatorRow{"guid": "a", "name": "Alice", "room": "1"})
+	store.Put(service.ReplicatorRow{"guid": "b", "name": "Bob", "room": "2"})
+	api := service.NewReplicator(store)
+
+	handle(api, connection, `{"api":"com.innovaphone.replicator","mt":"ReplicateStart","src":"1","add":true,"del":true,"columns":{"name":{"update":true},"room":{"update":false}}}`)
+	result := readMessage(t, client)
+	assert.Equal(t, "ReplicateStartResult", result["mt"])
+	assert.Equal(t, "1", result["src"])
+
+	// a change during the initial replication is sent after it
+	assert.NoError(t, api.Add(service.ReplicatorRow{"guid": "c", "name": "Carol", "room": "3", "secret": "x"}))
+
+	handle(api, connection, `{"api":"com.innovaphone.replicator","mt":"ReplicateNext","src":"2"}`)
+	result = readMessage(t, client)
+	assert.Equal(t, "ReplicateNextResult", result["mt"])
+	assert.Equal(t, map[string]interface{}{"guid": "a", "name": "Alice", "room": "1"}, result["columns"])
+
+	handle(api, connection, `{"api":"com.innovaphone.replicator","mt":"ReplicateNext","src":"3"}`)
+	result = readMessage(t, client)
+	assert.Equal(t, "b", result["columns"].(map[string]interface{})["guid"])
+
+	handle(api, connection, `{"api":"com.innovaphone.replicator","mt":"ReplicateNext","src":"4"}`)
+	result = readMessage(t, client)
+	assert.Equal(t, "ReplicateNextResult", result["mt"])
+	assert.Nil(t, result["columns"])
+
+	result = readMessage(t, client)
+	assert.Equal(t, "ReplicateAdd", result["mt"])
+	assert.Equal(t, map[string]interface{}{"guid": "c", "name": "Carol", "room": "3"}, result["columns"])
+
+	// room has no update flag, so only the change of the name is replicated
+	assert.NoError(t, api.Update(service.ReplicatorRow{"guid": "a", "room": "5"}))
+	assert.NoError(t, api.Update(service.ReplicatorRow{"guid": "a", "name": "Alice B."}))
+	result = readMessage(t, client)
+	assert.Equal(t, "ReplicateUpdate", result["mt"])
+	assert.Equal(t, map[string]interface{}{"guid": "a", "name": "Alice B.", "room": "5"}, result["columns"])
+
+	assert.NoError(t, api.Delete("b"))
+	result = readMessage(t, client)
+	assert.Equal(t, "ReplicateDel", result["mt"])
+	assert.Equal(t, "b", result["columns"].(map[string]interface{})["guid"])
+
+	assert.Error(t, api.Update(service.ReplicatorRow{"guid": "b", "name": "Bob"}))
+}
+
+func TestReplicator_ReadOnly(t *testing.T) {
+	connection, client := newTestConnection(t, &service.AppService{})
+	store := service.NewMemoryReplicatorStore()
+	api := service.NewReplicator(store)
+
+	handle(api, connection, `{"api":"com.innovaphone.replicator","mt":"ReplicateAdd","src":"1","columns":{"name":"Dave"}}`)
+	result := readMessage(t, client)
+	assert.Equal(t, "ReplicateAddResult", result["mt"])
+	assert.Equal(t, float64(1), result["error"])
+
+	api.Writable = true
+	handle(api, connection, `{"api":"com.innovaphone.replicator","mt":"ReplicateAdd","src":"2","columns":{"name":"Dave"}}`)
+	result = readMessage(t, client)
+	assert.Equal(t, "ReplicateAddResult", result["mt"])
+	assert.Nil(t, result["error"])
+	guid := result["guid"].(string)
+	assert.Len(t, guid, 32)
+
+	row, err := store.Get(guid)
+	assert.NoError(t, err)
+	assert.Equal(t, "Dave", row["name"])
+}
+
+func TestReplicator_Concurrent(t *testing.T) {
+	connection, client := newTestConnection(t, &service.AppService{})
+	store := service.NewMemoryReplicatorStore()
+	store.Put(service.ReplicatorRow{"guid": "a"})
+	api := service.NewReplicator(store)
+
+	// every row is replicated once, either in the initial replication or as a change after it
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			api.Add(service.ReplicatorRow{"guid": "row" + strconv.Itoa(i)})
+		}
+	}()
+	handle(api, connection, `{"api":"com.innovaphone.replicator","mt":"ReplicateStart","src":"1","add":true}`)
+	assert.Equal(t, "ReplicateStartResult", readMessage(t, client)["mt"])
+
+	// concurrent updates of a row do not get lost
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, api.Update(service.ReplicatorRow{"guid": "a", "c" + strconv.Itoa(i): i}))
+		}(i)
+	}
+	wg.Wait()
+	row, _ := store.Get("a")
+	assert.Len(t, row, 21)
+
+	seen := map[string]int{}
+	for src := 2; ; src++ {
+		handle(api, connection, `{"api":"com.innovaphone.replicator","mt":"ReplicateNext","src":"`+strconv.Itoa(src)+`"}`)
+		result := readMessage(t, client)
+		columns, ok := result["columns"].(map[string]interface{})
+		if !ok {
+			break
+		}
+		seen[columns["guid"].(string)]++
+	}
+	for len(seen) < 51 {
+		result := readMessage(t, client)
+		if result["mt"] == "ReplicateAdd" {
+			seen[result["columns"].(map[string]interface{})["guid"].(string)]++
+		}
+	}
+	for guid, count := range seen {
+		assert.Equal(t, 1, count, guid)
+	}
+}
+
+func TestReplicator_MessageOrder(t *testing.T) {
+	store := service.NewMemoryReplicatorStore()
+	for i := 0; i < 20; i++ {
+		store.Put(service.ReplicatorRow{"guid": "row" + strconv.Itoa(i)})
+	}
+	api := service.NewReplicator(store)
+	s := &service.AppService{}
+	s.RegisterHandler(api)
+	connection, client := newTestConnection(t, s)
+	connection.Authenticated = true
+	go connection.Loop()
+
+	// the messages are sent without waiting for the results, they are handled in order
+	assert.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(`{"api":"com.innovaphone.replicator","mt":"ReplicateStart","src":"1","add":true}`)))
+	for src := 2; src <= 22; src++ {
+		assert.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(`{"api":"com.innovaphone.replicator","mt":"ReplicateNext","src":"`+strconv.Itoa(src)+`"}`)))
+	}
+	result := readMessage(t, client)
+	assert.Equal(t, "ReplicateStartResult", result["mt"])
+	seen := map[string]bool{}
+	for src := 2; src <= 22; src++ {
+		result := readMessage(t, client)
+		assert.Equal(t, "ReplicateNextResult", result["mt"])
+		assert.Equal(t, strconv.Itoa(src), result["src"])
+		if columns, ok := result["columns"].(map[string]interface{}); ok {
+			seen[columns["guid"].(string)] = true
+		}
+	}
+	assert.Len(t, seen, 20)
+}
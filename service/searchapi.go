@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const SearchApiName = "com.innovaphone.search"
+
+// A SearchProvider answers the search requests of the myApps global search.
+//
+// Search is called in its own go routine for every request. Results can be written
+// as often as needed with w.Write, they are sent to the client immediately.
+// The ctx is canceled when the client cancels the search or disconnects.
+// When Search returns, the search is finished and the client gets a SearchResult.
+type SearchProvider interface {
+	Search(ctx context.Context, request *SearchRequest, w SearchResultWriter) error
+}
+
+// The SearchProviderFunc type is an adapter to allow the use of ordinary functions as SearchProvider.
+type SearchProviderFunc func(ctx context.Context, request *SearchRequest, w SearchResultWriter) error
+
+func (f SearchProviderFunc) Search(ctx context.Context, request *SearchRequest, w SearchResultWriter) error {
+	return f(ctx, request, w)
+}
+
+type SearchResultWriter interface {
+	Write(items ...SearchResultItem) error
+}
+
+// the Search request sent by the client
+type Search struct {
+	BaseMessage
+	Pattern string `json:"pattern"`
+	Type    string `json:"type,omitempty"`
+	Max     int    `json:"max,omitempty"`
+}
+
+// sent by the client to cancel a running search, identified by src
+type SearchCancel struct {
+	BaseMessage
+}
+
+type SearchRequest struct {
+	Search
+	Connection *AppServicePbxConnection // the connection the request was received on, holds the AppLogin of the user
+}
+
+type SearchResultItem struct {
+	Dn      string                 `json:"dn,omitempty"`
+	Cn      string                 `json:"cn,omitempty"`
+	Sip     string                 `json:"sip,omitempty"`
+	Num     string                 `json:"num,omitempty"`
+	Email   string                 `json:"email,omitempty"`
+	Company string                 `json:"company,omitempty"`
+	Url     string                 `json:"url,omitempty"`
+	Info    map[string]interface{} `json:"info,omitempty"` // additional values shown by the client
+}
+
+// a part of the results of a search, sent for every call of SearchResultWriter.Write
+type SearchInfo struct {
+	BaseMessage
+	Items []SearchResultItem `json:"items"`
+}
+
+// sent when a search is finished
+type SearchResult struct {
+	BaseMessage
+	Error     int    `json:"error,omitempty"`
+	Errortext string `json:"errorText,omitempty"`
+}
+
+var ErrSearchCanceled = errors.New("search canceled")
+
+// the time a SearchCancel for an unknown search is remembered, as the Search it cancels can be handled after it
+var SearchCancelMemory = 10 * time.Second
+
+type searchResultWriter struct {
+	ctx        context.Context
+	connection *AppServicePbxConnection
+	src        string
+}
+
+func (w *searchResultWriter) Write(items ...SearchResultItem) error {
+	if w.ctx.Err() != nil {
+		return ErrSearchCanceled
+	}
+	msg, err := json.Marshal(SearchInfo{
+		BaseMessage: BaseMessage{Api: SearchApiName, Mt: "SearchInfo", Src: w.src},
+		Items:       items,
+	})
+	if err != nil {
+		return err
+	}
+	return w.connection.WriteMessage(msg)
+}
+
+// Implements the com.innovaphone.search api for a SearchProvider.
+// Register it on the AppService with RegisterHandler.
+type SearchApi struct {
+	Provider SearchProvider
+
+	mu       sync.Mutex
+	searches map[*AppServicePbxConnection]map[string]*runningSearch // running searches by connection and src
+	canceled map[*AppServicePbxConnection]map[string]time.Time      // cancels of searches not started yet, by connection and src
+}
+
+type runningSearch struct {
+	cancel context.CancelFunc
+}
+
+func NewSearchApi(provider SearchProvider) *SearchApi {
+	return &SearchApi{
+		Provider: provider,
+		searches: map[*AppServicePbxConnection]map[string]*runningSearch{},
+		canceled: map[*AppServicePbxConnection]map[string]time.Time{},
+	}
+}
+
+func (api *SearchApi) GetApiName() string {
+	return SearchApiName
+}
+
+func (api *SearchApi) OnConnect(connection *AppServicePbxConnection) {}
+
+// cancels all running searches of the connection
+func (api *SearchApi) OnDisconnect(connection *AppServicePbxConnection) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	for _, search := range api.searches[connection] {
+		search.cancel()
+	}
+	delete(api.searches, connection)
+	delete(api.canceled, connection)
+}
+
+func (api *SearchApi) HandleMessage(connection *AppServicePbxConnection, msg *BaseMessage, message []byte) {
+	switch msg.Mt {
+	case "Search":
+		search := Search{}
+		if err := json.Unmarshal(message, &search); err != nil {
+			connection.log().Errorf("SearchApi: error unmarshalling message: %v", err)
+			return
+		}
+		api.startSearch(connection, &SearchRequest{Search: search, Connection: connection})
+	case "SearchCancel":
+		api.cancelSearch(connection, msg.Src)
+	default:
+		log.Warnf("SearchApi: unknown message received: %s", msg.Mt)
+	}
+}
+
+// returns the number of currently running searches
+func (api *SearchApi) Running() int {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	count := 0
+	for _, searches := range api.searches {
+		count += len(searches)
+	}
+	return count
+}
+
+func (api *SearchApi) startSearch(connection *AppServicePbxConnection, request *SearchRequest) {
+	ctx, cancel := context.WithCancel(context.Background())
+	search := &runningSearch{cancel: cancel}
+
+	api.mu.Lock()
+	if at, ok := api.canceled[connection][request.Src]; ok {
+		delete(api.canceled[connection], request.Src)
+		if time.Since(at) < SearchCancelMemory {
+			// the SearchCancel was handled before the Search
+			api.mu.Unlock()
+			cancel()
+			return
+		}
+	}
+	if api.searches == nil {
+		api.searches = map[*AppServicePbxConnection]map[string]*runningSearch{}
+	}
+	if api.searches[connection] == nil {
+		api.searches[connection] = map[string]*runningSearch{}
+	}
+	// a new search with the same src replaces the old one
+	if old, ok := api.searches[connection][request.Src]; ok {
+		old.cancel()
+	}
+	api.searches[connection][request.Src] = search
+	api.mu.Unlock()
+
+	go func() {
+		defer api.finishSearch(connection, request.Src, search)
+
+		w := &searchResultWriter{ctx: ctx, connection: connection, src: request.Src}
+		err := api.Provider.Search(ctx, request, w)
+		if ctx.Err() != nil {
+			// canceled by the client, it does not wait for a result anymore
+			return
+		}
+
+		result := SearchResult{BaseMessage: BaseMessage{Api: SearchApiName, Mt: "SearchResult", Src: request.Src}}
+		if err != nil {
+			connection.log().Errorf("SearchApi: search for '%s' failed: %v", request.Pattern, err)
+			result.Error = 1
+			result.Errortext = err.Error()
+		}
+		if msg, err := json.Marshal(result); err == nil {
+			connection.WriteMessage(msg)
+		}
+	}()
+}
+
+func (api *SearchApi) cancelSearch(connection *AppServicePbxConnection, src string) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if search, ok := api.searches[connection][src]; ok {
+		search.cancel()
+		delete(api.searches[connection], src)
+		return
+	}
+
+	// the Search is not handled yet, it is canceled when it starts
+	if api.canceled == nil {
+		api.canceled = map[*AppServicePbxConnection]map[string]time.Time{}
+	}
+	canceled, ok := api.canceled[connection]
+	if !ok {
+		canceled = map[string]time.Time{}
+		api.canceled[connection] = canceled
+	}
+	now := time.Now()
+	for old, at := range canceled {
+		if now.Sub(at) >= SearchCancelMemory {
+			delete(canceled, old)
+		}
+	}
+	canceled[src] = now
+}
+
+// removes the search from the list of running searches, if it was not replaced by a newer one with the same src
+func (api *SearchApi) finishSearch(connection *AppServicePbxConnection, src string, search *runningSearch) {
+	search.cancel()
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	searches, ok := api.searches[connection]
+	if !ok {
+		return
+	}
+	if searches[src] == search {
+		delete(searches, src)
+	}
+	if len(searches) == 0 {
+		delete(api.searches, connection)
+	}
+}
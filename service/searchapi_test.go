@@ -0,0 +1,115 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchApi_Search(t *testing.T) {
+	connection, client := newTestConnection(t, &service.AppService{})
+
+	api := service.NewSearchApi(service.SearchProviderFunc(func(ctx context.Context, request *service.SearchRequest, w service.SearchResultWriter) error {
+		assert.Equal(t, "schulte", request.Pattern)
+		assert.Equal(t, connection, request.Connection)
+		if err := w.Write(service.SearchResultItem{Dn: "Schulte, Rico", Sip: "rico"}); err != nil {
+			return err
+		}
+		return w.Write(service.SearchResultItem{Dn: "Schulte, Max", Num: "123"})
+	}))
+	handle(api, connection, `{"api":"com.innovaphone.search","mt":"Search","src":"s1","pattern":"schulte"}`)
+
+	info := readMessage(t, client)
+	assert.Equal(t, "SearchInfo", info["mt"])
+	assert.Equal(t, "s1", info["src"])
+	assert.Equal(t, "Schulte, Rico", info["items"].([]interface{})[0].(map[string]interface{})["dn"])
+
+	info = readMessage(t, client)
+	assert.Equal(t, "SearchInfo", info["mt"])
+	assert.Equal(t, "123", info["items"].([]interface{})[0].(map[string]interface{})["num"])
+
+	result := readMessage(t, client)
+	assert.Equal(t, "SearchResult", result["mt"])
+	assert.Equal(t, "s1", result["src"])
+	assert.Nil(t, result["error"])
+
+	assert.Eventually(t, func() bool { return api.Running() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestSearchApi_Cancel(t *testing.T) {
+	connection, client := newTestConnection(t, &service.AppService{})
+
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+	api := service.NewSearchApi(service.SearchProviderFunc(func(ctx context.Context, request *service.SearchRequest, w service.SearchResultWriter) error {
+		close(started)
+		<-ctx.Done()
+		stopped <- w.Write(service.SearchResultItem{Dn: "too late"})
+		return ctx.Err()
+	}))
+	handle(api, connection, `{"api":"com.innovaphone.search","mt":"Search","src":"s2","pattern":"x"}`)
+	<-started
+	assert.Equal(t, 1, api.Running())
+
+	handle(api, connection, `{"api":"com.innovaphone.search","mt":"SearchCancel","src":"s2"}`)
+
+	select {
+	case err := <-stopped:
+		assert.Equal(t, service.ErrSearchCanceled, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("search was not canceled")
+	}
+	assert.Eventually(t, func() bool { return api.Running() == 0 }, time.Second, 10*time.Millisecond)
+
+	// a canceled search sends no result
+	client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err := client.ReadMessage()
+	assert.Error(t, err)
+}
+
+func TestSearchApi_CancelBeforeSearch(t *testing.T) {
+	connection, client := newTestConnection(t, &service.AppService{})
+
+	searched := make(chan string, 2)
+	api := service.NewSearchApi(service.SearchProviderFunc(func(ctx context.Context, request *service.SearchRequest, w service.SearchResultWriter) error {
+		searched <- request.Src
+		return nil
+	}))
+	// the messages are handled in their own go routines, the cancel can be handled first
+	handle(api, connection, `{"api":"com.innovaphone.search","mt":"SearchCancel","src":"s4"}`)
+	handle(api, connection, `{"api":"com.innovaphone.search","mt":"Search","src":"s4","pattern":"x"}`)
+	assert.Equal(t, 0, api.Running())
+
+	// the cancel is used only once
+	handle(api, connection, `{"api":"com.innovaphone.search","mt":"Search","src":"s4","pattern":"x"}`)
+	assert.Equal(t, "s4", <-searched)
+	assert.Equal(t, "SearchResult", readMessage(t, client)["mt"])
+	assert.Empty(t, searched)
+}
+
+func TestSearchApi_Disconnect(t *testing.T) {
+	connection, _ := newTestConnection(t, &service.AppService{})
+
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	api := service.NewSearchApi(service.SearchProviderFunc(func(ctx context.Context, request *service.SearchRequest, w service.SearchResultWriter) error {
+		close(started)
+		<-ctx.Done()
+		close(stopped)
+		return nil
+	}))
+	handle(api, connection, `{"api":"com.innovaphone.search","mt":"Search","src":"s3","pattern":"x"}`)
+	<-started
+
+	api.OnDisconnect(connection)
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("search was not canceled on disconnect")
+	}
+	assert.Equal(t, 0, api.Running())
+}
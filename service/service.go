@@ -1,16 +1,21 @@
 package service
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
-	"strings"
 	"sync"
+	"time"
 
 	logger "github.com/chi-middleware/logrus-logger"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service/store"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -20,21 +25,50 @@ type AppService struct {
 	ListenPortTls int
 	TlsCertString string
 	TlsKeyString  string
-	Domain        string
-	Instance      string
-	Name          string
-	Password      string
+
+	TlsCertificateProvider CertificateProvider // provides the certificate, if set TlsCertString and TlsKeyString are not used
+	TlsMinVersion          uint16              // the minimum Tls version, default tls.VersionTLS12
+	TlsCipherSuites        []uint16            // the cipher suites for Tls 1.2 and below, the defaults of crypto/tls if empty
+	Domain                 string
+	Instance               string
+	Name                   string
+	Password               string
 
 	Fs                http.FileSystem
+	Store             *store.Store                            // the database of the instance, see OpenStore
+	Apps              map[string]*App                         // the registered apps by name, see RegisterApp
+	AppHandler        map[string]AppHandler                   // the handlers of the apps by name, see RegisterAppHandler
+	AppMessageHandler map[string]map[string]AppMessageHandler // the handlers of messages of the apps by app name and mt, see RegisterAppMessageHandler
+	AppsMutex         sync.RWMutex
 	ApiHandler        []PbxApiInterface
 	HttpRootMux       *http.ServeMux
 	Connections       []*AppServicePbxConnection // list of current connected websocket connections
 	ConnectionsMutext sync.Mutex
+
+	instances       map[string]*AppInstance // the instances added by the PBX Manager by domain/instance
+	instancesMutex  sync.RWMutex
+	instanceLocks   map[string]*instanceLock // serialize the changes of an instance by key, see lockInstance
+	InstanceStore   InstanceStore            // persists the instances, see LoadInstances
+	ManagerPassword string                   // the password of the provisioning api of the PBX Manager, see HandleManagerInstances
+	Migrations      []store.Migration        // applied to the databases of the instances
+	defaultInst     *AppInstance             // the instance of Domain, Instance, Password and Store, see defaultInstance
+	defaultInstOnce sync.Once
+
+	OnInstanceAdded   func(instance *AppInstance) error // called for new instances before they are served, e.g. to register their handlers
+	OnInstanceRemoved func(instance *AppInstance)       // called for deleted instances
+
+	routesOnce   sync.Once
+	serverMutex  sync.Mutex
+	servers      []*http.Server
+	listeners    []net.Listener
+	serverErrors chan error     // errors of the running servers, see Run
+	shuttingDown bool           // set by Shutdown, new connections and messages are rejected
+	handlers     sync.WaitGroup // the running websocket handlers and the handlers of their messages
 }
 
 func NewAppService(ip string, port int, portTls int, tlsCert string, tlsCertKey string, domain, name, instance, password string, fS http.FileSystem) (*AppService, error) {
 	if portTls != 0 && (tlsCert == "" || tlsCertKey == "") {
-		return nil, fmt.Errorf("when Tls Port set, a Certificate has to be set too. generate one with 'openssl req -new -newkey rsa:2048 -days 365 -nodes -x509 -keyout server.key -out server.crt'")
+		return nil, fmt.Errorf("when Tls Port set, a Certificate and its key in PEM format have to be set too. Use NewAppServiceWithCertificateProvider to load them from files or to get them with ACME")
 	}
 
 	return &AppService{
@@ -52,9 +86,38 @@ func NewAppService(ip string, port int, portTls int, tlsCert string, tlsCertKey
 	}, nil
 }
 
-func (s *AppService) Start() error {
-	rootpath := fmt.Sprintf("/%s/%s/%s/", s.Domain, s.Name, s.Instance)
-	log.Infof("register service with path: %s", rootpath)
+// creates a AppService with a CertificateProvider for the Http/Tls server, e.g. a FileCertificateProvider
+func NewAppServiceWithCertificateProvider(ip string, port int, portTls int, provider CertificateProvider, domain, name, instance, password string, fS http.FileSystem) (*AppService, error) {
+	if portTls != 0 && provider == nil {
+		return nil, fmt.Errorf("when Tls Port set, a CertificateProvider has to be set too")
+	}
+	s, err := NewAppService(ip, port, 0, "", "", domain, name, instance, password, fS)
+	if err != nil {
+		return nil, err
+	}
+	s.ListenPortTls = portTls
+	s.TlsCertificateProvider = provider
+	return s, nil
+}
+
+// opens the database of the instance with the schema for Domain and Instance and applies the migrations.
+// The driver of the database has to be imported by the application, see package store.
+func (s *AppService) OpenStore(ctx context.Context, dialect store.Dialect, dsn string, migrations ...store.Migration) error {
+	db, err := store.Open(ctx, dialect, dsn, s.Domain, s.Instance)
+	if err != nil {
+		return err
+	}
+	if err := db.Migrate(ctx, migrations...); err != nil {
+		db.Close()
+		return err
+	}
+	s.Store = db
+	return nil
+}
+
+// registers the routes of the service on the HttpRootMux
+func (s *AppService) registerRoutes() {
+	log.Infof("register service with path: /{domain}/%s/{instance}/", s.Name)
 
 	router := chi.NewRouter()
 	logs := log.New()
@@ -66,73 +129,271 @@ func (s *AppService) Start() error {
 	router.Use(middleware.Recoverer)
 	router.Use(middleware.SetHeader("Server", s.Name))
 
-	s.HttpRootMux.HandleFunc(fmt.Sprintf("/%s/%s/%s", s.Domain, s.Name, s.Instance), func(w http.ResponseWriter, r *http.Request) {
-		handleConnectionForHttpOrWebsocket(s, w, r)
+	// the websocket of the PBX and the clients
+	router.HandleFunc(fmt.Sprintf("/{domain}/%s/{instance}", s.Name), func(w http.ResponseWriter, r *http.Request) {
+		instance, ok := s.GetInstance(chi.URLParam(r, "domain"), chi.URLParam(r, "instance"))
+		if !ok {
+			notFound(w, r)
+			return
+		}
+		handleConnectionForHttpOrWebsocket(s, instance, w, r)
 	})
 
-	// the router for the Webpage
-	router_api := chi.NewRouter()
-	//GetHttpRoutes(s, router_api)
-	router_api.Get("/*",
-		http.StripPrefix(fmt.Sprintf("/%s/%s/%s/", s.Domain, s.Name, s.Instance),
-			http.FileServer(s.Fs),
-		).ServeHTTP,
-	)
-
-	router.Mount(rootpath, router_api)
-	s.HttpRootMux.Handle(rootpath, router)
+	// the Webpage
+	router.Get(fmt.Sprintf("/{domain}/%s/{instance}/*", s.Name), func(w http.ResponseWriter, r *http.Request) {
+		instance, ok := s.GetInstance(chi.URLParam(r, "domain"), chi.URLParam(r, "instance"))
+		if !ok {
+			notFound(w, r)
+			return
+		}
+		fs := instance.getFs()
+		if fs == nil {
+			fs = s.Fs
+		}
+		if fs == nil {
+			notFound(w, r)
+			return
+		}
+		http.StripPrefix(fmt.Sprintf("/%s/%s/%s/", chi.URLParam(r, "domain"), s.Name, instance.Instance),
+			http.FileServer(fs),
+		).ServeHTTP(w, r)
+	})
+	router.NotFound(notFound)
 
 	s.HttpRootMux.HandleFunc("/manager/fixcert.htm", s.HandleManagerFixCertResponse)
-	s.HttpRootMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		log.
-			WithField("path", r.URL.Path).
-			Info("404 not found")
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("not found"))
-	})
+	s.HttpRootMux.HandleFunc(managerInstancesPath, s.HandleManagerInstances)
+	s.HttpRootMux.HandleFunc(managerInstancesPath+"/", s.HandleManagerInstances)
+	s.HttpRootMux.Handle("/", router)
+}
 
-	go func() {
-		if s.ListenPort > 0 {
-			log.Infof("starting Http %s:%v", s.ListenIp, s.ListenPort)
-			if err := http.ListenAndServe(fmt.Sprintf("%s:%v", s.ListenIp, s.ListenPort), s.HttpRootMux); err != nil {
-				log.Errorf("Error starting Http server: '%s:%d' %v ", s.ListenIp, s.ListenPort, err)
-			}
+// returns the HttpRootMux with the routes of the service, e.g. to serve it with an own http.Server
+func (s *AppService) Handler() http.Handler {
+	if s.HttpRootMux == nil {
+		s.HttpRootMux = http.NewServeMux()
+	}
+	s.routesOnce.Do(s.registerRoutes)
+	return s.HttpRootMux
+}
+
+func notFound(w http.ResponseWriter, r *http.Request) {
+	log.
+		WithField("path", r.URL.Path).
+		Info("404 not found")
+	w.WriteHeader(http.StatusNotFound)
+	w.Write([]byte("not found"))
+}
+
+/*
+starts the Http and Http/Tls servers.
+
+The listeners are opened before Start returns, so errors like an address already in use are returned.
+The servers run until Shutdown is called. Errors of a running server are logged and passed to Run.
+Domain, Instance, Password and Store have to be set before, the connections use the values of the first Start.
+*/
+func (s *AppService) Start() error {
+	s.serverMutex.Lock()
+	defer s.serverMutex.Unlock()
+	if len(s.servers) > 0 {
+		return fmt.Errorf("the service is already started")
+	}
+	handler := s.Handler()
+	s.defaultInstance()
+	s.serverErrors = make(chan error, 2)
+	s.shuttingDown = false
+
+	if s.ListenPort > 0 {
+		log.Infof("starting Http %s:%v", s.ListenIp, s.ListenPort)
+		listener, err := net.Listen("tcp", fmt.Sprintf("%s:%v", s.ListenIp, s.ListenPort))
+		if err != nil {
+			return fmt.Errorf("error starting Http server: '%s:%d' %v", s.ListenIp, s.ListenPort, err)
 		}
-	}()
+		if challengeHandler, ok := s.TlsCertificateProvider.(httpChallengeHandler); ok {
+			// answers ACME http-01 challenges
+			handler = challengeHandler.HTTPHandler(handler)
+		}
+		server := &http.Server{Handler: handler}
+		s.serve(server, listener, func() error { return server.Serve(listener) })
+	}
+
+	if s.ListenPortTls > 0 {
+		log.Infof("starting Http/Tls %s:%v", s.ListenIp, s.ListenPortTls)
+		server, listener, err := s.listenTls(s.HttpRootMux)
+		if err != nil {
+			s.closeServers()
+			return fmt.Errorf("error starting Http/Tls server: '%s:%d' %v", s.ListenIp, s.ListenPortTls, err)
+		}
+		s.serve(server, listener, func() error { return server.ServeTLS(listener, "", "") })
+	}
+	return nil
+}
 
+// runs a server in a go routine, the serverMutex has to be locked
+func (s *AppService) serve(server *http.Server, listener net.Listener, serve func() error) {
+	s.servers = append(s.servers, server)
+	s.listeners = append(s.listeners, listener)
 	go func() {
-		if s.ListenPortTls > 0 {
-			log.Infof("starting Http/Tls %s:%v", s.ListenIp, s.ListenPortTls)
-			err := s.StartTls(s.HttpRootMux)
-			if err != nil {
-				log.Errorf("Error starting Http/Tls server: '%s:%d' %v ", s.ListenIp, s.ListenPortTls, err)
-			}
+		if err := serve(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("Error running server '%s': %v", listener.Addr(), err)
+			s.serverErrors <- err
 		}
 	}()
+}
 
-	return nil
+// closes the servers after a failed Start, the serverMutex has to be locked
+func (s *AppService) closeServers() {
+	for _, server := range s.servers {
+		server.Close()
+	}
+	s.servers = nil
+	s.listeners = nil
+}
+
+// returns the addresses the servers are listening on
+func (s *AppService) Addrs() []net.Addr {
+	s.serverMutex.Lock()
+	defer s.serverMutex.Unlock()
+	addrs := []net.Addr{}
+	for _, listener := range s.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+	return addrs
 }
 
 /*
-openssl req -new -newkey rsa:2048 -days 365 -nodes -x509 -keyout server.key -out server.crt
+stops the service gracefully.
+
+The servers stop accepting new connections and wait for running http requests.
+All websocket connections get a close frame and Shutdown waits until their handlers
+and the handlers of their messages are finished. When ctx is done before, the remaining
+connections are closed and ctx.Err() is returned.
 */
-func (s *AppService) StartTls(mux *http.ServeMux) error {
+func (s *AppService) Shutdown(ctx context.Context) error {
+	s.serverMutex.Lock()
+	servers := s.servers
+	s.servers = nil
+	s.listeners = nil
+	s.shuttingDown = true
+	s.serverMutex.Unlock()
+
+	var result error
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			result = err
+		}
+	}
+
+	for _, connection := range s.GetConnections() {
+		connection.Close(websocket.CloseGoingAway, "service shutdown")
+	}
 
-	cert, err := tls.X509KeyPair([]byte(s.TlsCertString), []byte(s.TlsKeyString))
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		for _, connection := range s.GetConnections() {
+			connection.conn.Close()
+		}
+		return ctx.Err()
+	}
+	return result
+}
+
+/*
+starts the service and runs it until ctx is done, then it is shut down gracefully.
+
+shutdownTimeout limits the time to wait for the connections to close.
+Returns the error of Start or of a failed server. In this case the service is shut down too.
+*/
+func (s *AppService) Run(ctx context.Context, shutdownTimeout time.Duration) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	s.serverMutex.Lock()
+	serverErrors := s.serverErrors
+	s.serverMutex.Unlock()
+
+	var result error
+	select {
+	case <-ctx.Done():
+	case result = <-serverErrors:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil && result == nil {
+		result = err
+	}
+	return result
+}
+
+// returns false, if the service is shutting down and new connections or messages should not be handled anymore
+func (s *AppService) startHandler() bool {
+	s.serverMutex.Lock()
+	defer s.serverMutex.Unlock()
+	if s.shuttingDown {
+		return false
+	}
+	s.handlers.Add(1)
+	return true
+}
+
+func (s *AppService) finishHandler() {
+	s.handlers.Done()
+}
+
+// runs the Http/Tls server with the mux until it fails
+func (s *AppService) StartTls(mux *http.ServeMux) error {
+	server, listener, err := s.listenTls(mux)
 	if err != nil {
-		log.Errorf("Error loading certificate:", err)
 		return err
 	}
+	return server.ServeTLS(listener, "", "")
+}
+
+// returns the Http/Tls server and its listener
+func (s *AppService) listenTls(mux *http.ServeMux) (*http.Server, net.Listener, error) {
+	tlsConfig, err := s.TlsConfig()
+	if err != nil {
+		log.Errorf("Error loading certificate: %v", err)
+		return nil, nil, err
+	}
 
 	server := &http.Server{
-		Addr: fmt.Sprintf("%s:%v", s.ListenIp, s.ListenPortTls),
-		TLSConfig: &tls.Config{
-			Certificates: []tls.Certificate{cert},
-		},
-		Handler: mux,
+		Addr:      fmt.Sprintf("%s:%v", s.ListenIp, s.ListenPortTls),
+		TLSConfig: tlsConfig,
+		Handler:   mux,
+	}
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return nil, nil, err
 	}
+	return server, listener, nil
+}
 
-	return server.ListenAndServeTLS("", "")
+// returns the configuration of the Http/Tls server
+func (s *AppService) TlsConfig() (*tls.Config, error) {
+	provider := s.TlsCertificateProvider
+	if provider == nil {
+		static, err := NewStaticCertificateProvider(s.TlsCertString, s.TlsKeyString)
+		if err != nil {
+			return nil, err
+		}
+		provider = static
+	}
+	minVersion := s.TlsMinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+	return &tls.Config{
+		GetCertificate: provider.GetCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   s.TlsCipherSuites,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}, nil
 }
 
 func (s *AppService) RegisterHandler(handler PbxApiInterface) error {
@@ -140,9 +401,18 @@ func (s *AppService) RegisterHandler(handler PbxApiInterface) error {
 	return nil
 }
 
+// returns the handlers of the AppService and of the instance of the connection
+func (s *AppService) apiHandler(connection *AppServicePbxConnection) []PbxApiInterface {
+	handler := append([]PbxApiInterface{}, s.ApiHandler...)
+	if connection.Instance != nil {
+		handler = append(handler, connection.Instance.getApiHandler()...)
+	}
+	return handler
+}
+
 func (s *AppService) HandleApiConnected(connection *AppServicePbxConnection, msg []byte) {
 	for _, apiName := range connection.PbxInfo.Apis {
-		for _, handler := range s.ApiHandler {
+		for _, handler := range s.apiHandler(connection) {
 			if handler.GetApiName() == apiName {
 				handler.OnConnect(connection)
 			}
@@ -150,33 +420,62 @@ func (s *AppService) HandleApiConnected(connection *AppServicePbxConnection, msg
 	}
 }
 func (s *AppService) HandleUserConnected(connection *AppServicePbxConnection, msg []byte) {
-	for _, handler := range s.ApiHandler {
-		if handler.GetApiName() == "user" {
-			handler.OnConnect(connection)
-		}
-	}
+	s.HandleAppConnected(connection, msg)
 }
 func (s *AppService) HandleAdminConnected(connection *AppServicePbxConnection, msg []byte) {
-	for _, handler := range s.ApiHandler {
-		if handler.GetApiName() == "admin" {
+	s.HandleAppConnected(connection, msg)
+}
+
+// called when a client of an app has logged in
+func (s *AppService) HandleAppConnected(connection *AppServicePbxConnection, msg []byte) {
+	login, _ := connection.GetAppLogin()
+	app := AppName(login.App)
+	handled := false
+	if handler, ok := s.GetAppHandler(app); ok {
+		handler.OnConnect(connection)
+		handled = true
+	}
+	// api handlers named like the app, e.g. "user" or "admin"
+	for _, handler := range s.apiHandler(connection) {
+		if handler.GetApiName() == app {
 			handler.OnConnect(connection)
+			handled = true
 		}
 	}
+	if !handled {
+		log.Debugf("no handler for App '%s'", app)
+	}
 }
 
 func (s *AppService) HandleApiDisConnected(connection *AppServicePbxConnection) {
 	for _, apiName := range connection.PbxInfo.Apis {
-		for _, handler := range s.ApiHandler {
+		for _, handler := range s.apiHandler(connection) {
+			if handler.GetApiName() == apiName {
+				handler.OnDisconnect(connection)
+			}
+		}
+	}
+
+	// apis used by clients, like com.innovaphone.search of the myApps search, are not part of the PbxInfo
+	for _, apiName := range connection.getUsedApis() {
+		if containsString(connection.PbxInfo.Apis, apiName) {
+			continue
+		}
+		for _, handler := range s.apiHandler(connection) {
 			if handler.GetApiName() == apiName {
 				handler.OnDisconnect(connection)
 			}
 		}
 	}
 
-	log.Debug("on disconnect of ", connection.AppLogin.App)
-	if strings.HasSuffix(connection.AppLogin.App, ".htm") {
-		app := strings.TrimSuffix(connection.AppLogin.App, ".htm")
-		for _, handler := range s.ApiHandler {
+	login, _ := connection.GetAppLogin()
+	log.Debug("on disconnect of ", login.App)
+	if connection.Authenticated && login.App != "" {
+		app := AppName(login.App)
+		if handler, ok := s.GetAppHandler(app); ok {
+			handler.OnDisconnect(connection)
+		}
+		for _, handler := range s.apiHandler(connection) {
 			if handler.GetApiName() == app {
 				handler.OnDisconnect(connection)
 			}
@@ -189,7 +488,8 @@ func (s *AppService) HandleApiMessage(connection *AppServicePbxConnection, apiNa
 	if err := json.Unmarshal(message, &msg); err != nil {
 		connection.log().Errorf("server: error unmarshalling message: %v", err)
 	}
-	for _, handler := range s.ApiHandler {
+	connection.addUsedApi(apiName)
+	for _, handler := range s.apiHandler(connection) {
 		if handler.GetApiName() == apiName {
 			handler.HandleMessage(connection, &msg, message)
 			return
@@ -223,6 +523,9 @@ func (s *AppService) AddConnection(connection *AppServicePbxConnection) {
 	s.ConnectionsMutext.Lock()
 	defer s.ConnectionsMutext.Unlock()
 	s.Connections = append(s.Connections, connection)
+	if connection.Instance != nil {
+		connection.Instance.addConnection(connection)
+	}
 }
 
 func (s *AppService) DeleteConnection(connection *AppServicePbxConnection) {
@@ -236,6 +539,18 @@ func (s *AppService) DeleteConnection(connection *AppServicePbxConnection) {
 			break
 		}
 	}
+	if connection.Instance != nil {
+		connection.Instance.deleteConnection(connection)
+	}
+}
+
+// returns a copy of the list of connections in a go routine save way
+func (s *AppService) GetConnections() []*AppServicePbxConnection {
+	s.ConnectionsMutext.Lock()
+	defer s.ConnectionsMutext.Unlock()
+	connections := make([]*AppServicePbxConnection, len(s.Connections))
+	copy(connections, s.Connections)
+	return connections
 }
 
 /*
@@ -252,13 +567,7 @@ send the message to all connected Users
 */
 func (s *AppService) SendToAllUsers(message []byte) {
 	log.Debug("SendToAllUsers")
-	for _, connection := range s.Connections {
-		log.Tracef("SendToAllUsers %s", connection.AppLogin.App)
-		if connection.AppLogin.App == "user.htm" {
-			log.Tracef("SendToAllUsers %s", string(message))
-			connection.WriteMessage(message)
-		}
-	}
+	s.SendToApp("user", message)
 }
 
 /*
@@ -266,10 +575,19 @@ send the message to all connected Admins
 */
 func (s *AppService) SendToAllAdmins(message []byte) {
 	log.Debug("SendToAllAdmins")
-	for _, connection := range s.Connections {
-		log.Tracef("SendToAllAdmins %s", connection.AppLogin.App)
-		if connection.AppLogin.App == "admin.htm" {
-			log.Tracef("SendToAllAdmins %s", string(message))
+	s.SendToApp("admin", message)
+}
+
+/*
+send the message to all logged in clients of an app
+
+app is the name of the app, with or without .htm
+*/
+func (s *AppService) SendToApp(app string, message []byte) {
+	log.Debugf("SendToApp %s", app)
+	for _, connection := range s.GetConnections() {
+		if login, _ := connection.GetAppLogin(); connection.Authenticated && AppName(login.App) == AppName(app) {
+			log.Tracef("SendToApp %s %s", app, string(message))
 			connection.WriteMessage(message)
 		}
 	}
@@ -283,9 +601,9 @@ sip is a string of the sip/h323 name of the user
 func (s *AppService) SendToAllConnectionsOfSip(message []byte, sip string) {
 	log.Debug("SendToAllConnectionsOfSip")
 
-	for _, connection := range s.Connections {
-		if connection.AppLogin.Sip == sip {
-			log.Tracef("SendToAllConnectionsOfSip ", sip, string(message))
+	for _, connection := range s.GetConnections() {
+		if login, _ := connection.GetAppLogin(); login.Sip == sip {
+			log.Tracef("SendToAllConnectionsOfSip %s %s", sip, string(message))
 			connection.WriteMessage(message)
 		}
 	}
@@ -299,3 +617,12 @@ type PbxApiInterface interface {
 }
 
 type Guid string
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package service_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// returns a port that is free to listen on
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestAppService_StartAddressInUse(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	s, err := service.NewAppService("127.0.0.1", listener.Addr().(*net.TCPAddr).Port, 0, "", "", "example.com", "go", "instance", "pwd", nil)
+	assert.NoError(t, err)
+	assert.Error(t, s.Start())
+	assert.Len(t, s.Addrs(), 0)
+}
+
+func TestAppService_Shutdown(t *testing.T) {
+	port := freePort(t)
+	s, err := service.NewAppService("127.0.0.1", port, 0, "", "", "example.com", "go", "instance", "pwd", nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Run(ctx, 2*time.Second)
+	}()
+
+	var client *websocket.Conn
+	assert.Eventually(t, func() bool {
+		client, _, err = websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/example.com/go/instance", port), nil)
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	defer client.Close()
+	assert.Eventually(t, func() bool { return len(s.GetConnections()) == 1 }, time.Second, 10*time.Millisecond)
+
+	cancel()
+
+	// the client gets a close frame, gorilla answers it while reading
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = client.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), "unexpected error: %v", err)
+
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("Run did not return")
+	}
+	assert.Len(t, s.GetConnections(), 0)
+
+	_, _, err = websocket.DefaultDialer.Dial(fmt.Sprintf("ws://127.0.0.1:%d/example.com/go/instance", port), nil)
+	assert.Error(t, err)
+}
@@ -1,11 +1,30 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
 	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/events"
 	log "github.com/sirupsen/logrus"
 )
 
 type Services struct {
+	events.Publisher[ServicesEvent]
+
+	// sends SubscribeServices on connect, the services are tracked from the ServicesInfo messages
+	SubscribeOnConnect bool
+
+	mu       sync.Mutex
+	services map[*service.AppServicePbxConnection]map[string]Service
+}
+
+func NewServices() *Services {
+	return &Services{
+		services: map[*service.AppServicePbxConnection]map[string]Service{},
+	}
 }
 
 func (api *Services) GetApiName() string {
@@ -13,13 +32,101 @@ func (api *Services) GetApiName() string {
 }
 
 func (api *Services) OnConnect(connection *service.AppServicePbxConnection) {
-	log.WithField("api", api.GetApiName()).Warn("OnConnect not implemented ")
+	api.Publish(ServicesEvent{Type: ServicesEventConnect, Connection: connection})
+	if api.SubscribeOnConnect {
+		if err := api.SubscribeServices(connection); err != nil {
+			log.Errorf("Services: sending SubscribeServices failed: %v", err)
+		}
+	}
 }
 
 func (api *Services) OnDisconnect(connection *service.AppServicePbxConnection) {
-	log.WithField("api", api.GetApiName()).Warn("OnDisconnect not implemented ")
+	api.mu.Lock()
+	delete(api.services, connection)
+	api.mu.Unlock()
+	api.Publish(ServicesEvent{Type: ServicesEventDisconnect, Connection: connection})
 }
 
 func (api *Services) HandleMessage(connection *service.AppServicePbxConnection, msg *service.BaseMessage, message []byte) {
-	log.WithField("api", api.GetApiName()).Warn("HandleMessage not implemented ")
+	switch msg.Mt {
+	case "SubscribeServicesResult":
+		log.Trace("Services: SubscribeServicesResult")
+	case "ServicesInfo":
+		msg := ServicesInfo{}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Errorf("Services: error unmarshalling message: %v", err)
+			return
+		}
+		api.setServices(connection, msg.Services)
+		api.Publish(ServicesEvent{Type: ServicesEventServicesInfo, ServicesInfo: &msg, Connection: connection})
+	default:
+		log.Warnf("unknown message received: %s", msg.Mt)
+	}
+}
+
+// sends SubscribeServices, the services are sent as ServicesInfo events
+func (api *Services) SubscribeServices(connection *service.AppServicePbxConnection) error {
+	mbytes, err := json.Marshal(NewSubscribeServices(""))
+	if err != nil {
+		return err
+	}
+	return connection.WriteMessage(mbytes)
+}
+
+// replaces the services of the connection
+func (api *Services) setServices(connection *service.AppServicePbxConnection, list []Service) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if api.services == nil {
+		api.services = map[*service.AppServicePbxConnection]map[string]Service{}
+	}
+	services := map[string]Service{}
+	for _, s := range list {
+		services[s.Name] = s
+	}
+	api.services[connection] = services
+}
+
+// returns the services of the connection, sorted by name
+func (api *Services) GetServices(connection *service.AppServicePbxConnection) []Service {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	services := make([]Service, 0, len(api.services[connection]))
+	for _, s := range api.services[connection] {
+		services = append(services, s)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services
+}
+
+// returns the service of the app
+func (api *Services) GetService(connection *service.AppServicePbxConnection, app string) (Service, bool) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	s, ok := api.services[connection][app]
+	return s, ok
+}
+
+// returns the services offering the api, like "com.innovaphone.search"
+func (api *Services) GetServicesWithApi(connection *service.AppServicePbxConnection, name string) []Service {
+	services := []Service{}
+	for _, s := range api.GetServices(connection) {
+		if s.HasApi(name) {
+			services = append(services, s)
+		}
+	}
+	return services
+}
+
+// connects to the app service of the app, the url is requested with GetServiceInfo if the service is not known
+func (api *Services) Connect(ctx context.Context, connection *service.AppServicePbxConnection, app string) (*ServiceConnection, error) {
+	s, ok := api.GetService(connection, app)
+	if !ok || s.Url == "" {
+		info, err := CallGetServiceInfo(ctx, connection, app)
+		if err != nil {
+			return nil, err
+		}
+		s.Url = info.Url
+	}
+	return Connect(ctx, connection, app, s.Url)
 }
@@ -0,0 +1,166 @@
+package services_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/ricoschulte/go-myapps/service/events"
+	"github.com/ricoschulte/go-myapps/service/services"
+	"github.com/ricoschulte/go-myapps/service/servicetest"
+	"github.com/stretchr/testify/assert"
+)
+
+// returns the http url of an app service that accepts the AppLogin with the digest of the challenge and answers Search
+func newAppService(t *testing.T) string {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			message := map[string]interface{}{}
+			if err := conn.ReadJSON(&message); err != nil {
+				return
+			}
+			switch message["mt"] {
+			case "AppChallenge":
+				conn.WriteJSON(map[string]interface{}{"mt": "AppChallengeResult", "challenge": "c1"})
+			case "AppLogin":
+				conn.WriteJSON(map[string]interface{}{"mt": "AppLoginResult", "app": message["app"], "ok": message["digest"] == "digest-c1"})
+				conn.WriteJSON(map[string]interface{}{"api": "Contacts", "mt": "Welcome"})
+			case "Search":
+				result := map[string]interface{}{"api": message["api"], "mt": "SearchResult", "src": message["src"], "count": 2}
+				if message["text"] == "" {
+					result["error"] = 1
+					result["errorText"] = "no text"
+				}
+				conn.WriteJSON(result)
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestServices_ServicesInfo(t *testing.T) {
+	requests := make(chan map[string]interface{}, 1)
+	connection := servicetest.NewConnection(t, func(peer *websocket.Conn, message map[string]interface{}) {
+		requests <- message
+	})
+	api := services.NewServices()
+	api.SubscribeOnConnect = true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	infos := api.Subscribe(ctx, events.ForTypes[services.ServicesEvent](services.ServicesEventServicesInfo))
+
+	api.OnConnect(connection)
+	request := <-requests
+	assert.Equal(t, "Services", request["api"])
+	assert.Equal(t, "SubscribeServices", request["mt"])
+
+	handle := func(message string) {
+		api.HandleMessage(connection, &service.BaseMessage{Api: "Services", Mt: "ServicesInfo"}, []byte(message))
+		<-infos.C
+	}
+	handle(`{"api":"Services","mt":"ServicesInfo","services":[
+		{"name":"reports","title":"Reports","url":"https://apps/reports","info":{"apis":{"com.innovaphone.reports":{}}}},
+		{"name":"contacts","title":"Contacts","url":"https://apps/contacts","info":{"apis":{"com.innovaphone.search":{},"com.innovaphone.contacts":{}}}}
+	]}`)
+	list := api.GetServices(connection)
+	if assert.Len(t, list, 2) {
+		assert.Equal(t, "contacts", list[0].Name)
+		assert.Equal(t, "reports", list[1].Name)
+	}
+	search := api.GetServicesWithApi(connection, "com.innovaphone.search")
+	if assert.Len(t, search, 1) {
+		assert.Equal(t, "https://apps/contacts", search[0].Url)
+	}
+
+	// a ServicesInfo replaces the services
+	handle(`{"api":"Services","mt":"ServicesInfo","services":[{"name":"reports","url":"https://apps/reports"}]}`)
+	_, ok := api.GetService(connection, "contacts")
+	assert.False(t, ok)
+	assert.Len(t, api.GetServices(connection), 1)
+
+	api.OnDisconnect(connection)
+	assert.Empty(t, api.GetServices(connection))
+}
+
+func TestServices_Connect(t *testing.T) {
+	url := newAppService(t)
+	connection := servicetest.NewConnection(t, func(peer *websocket.Conn, message map[string]interface{}) {
+		result := map[string]interface{}{"api": "Services", "mt": message["mt"].(string) + "Result", "src": message["src"]}
+		switch message["mt"] {
+		case "GetServiceInfo":
+			if message["app"] != "contacts" {
+				result["error"] = 1
+				result["errorText"] = "no such service"
+				break
+			}
+			result["app"] = "contacts"
+			result["url"] = url
+			result["info"] = map[string]interface{}{"apis": map[string]interface{}{"com.innovaphone.search": map[string]interface{}{}}}
+		case "GetServiceLogin":
+			result["app"] = message["app"]
+			result["domain"] = "example.com"
+			result["sip"] = "myapp"
+			result["digest"] = "digest-" + message["challenge"].(string)
+			result["info"] = map[string]interface{}{"appobj": "myapp"}
+		}
+		peer.WriteJSON(result)
+	})
+	connection.Authenticated = true
+	go connection.Loop()
+	ctx := context.Background()
+
+	info, err := services.CallGetServiceInfo(ctx, connection, "contacts")
+	assert.NoError(t, err)
+	assert.Equal(t, url, info.Url)
+	assert.Contains(t, info.Info.Apis, "com.innovaphone.search")
+
+	api := services.NewServices()
+	_, err = api.Connect(ctx, connection, "unknown")
+	callErr := &service.CallError{}
+	assert.ErrorAs(t, err, &callErr)
+
+	sc, err := api.Connect(ctx, connection, "contacts")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Contains(t, string(<-sc.Messages), "Welcome")
+
+	type searchResult struct {
+		service.BaseMessage
+		Count int `json:"count"`
+	}
+	result, err := services.ServiceCallFor[searchResult](ctx, sc, "Contacts", map[string]interface{}{"mt": "Search", "text": "alice"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Count)
+	_, err = sc.Call(ctx, "Contacts", map[string]interface{}{"mt": "Search", "text": ""})
+	assert.ErrorAs(t, err, &callErr)
+	assert.Equal(t, "Contacts", callErr.Api)
+
+	assert.NoError(t, sc.Close())
+	_, ok := <-sc.Messages
+	assert.False(t, ok)
+	_, err = sc.Call(ctx, "Contacts", map[string]interface{}{"mt": "Search", "text": "alice"})
+	assert.Error(t, err)
+}
+
+func TestServices_ConnectLoginFailed(t *testing.T) {
+	url := newAppService(t)
+	connection := servicetest.NewConnection(t, func(peer *websocket.Conn, message map[string]interface{}) {
+		peer.WriteJSON(map[string]interface{}{"api": "Services", "mt": "GetServiceLoginResult", "src": message["src"], "app": message["app"], "digest": "wrong"})
+	})
+	connection.Authenticated = true
+	go connection.Loop()
+
+	_, err := services.Connect(context.Background(), connection, "contacts", url)
+	assert.ErrorIs(t, err, services.ErrLoginFailed)
+}
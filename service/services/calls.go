@@ -0,0 +1,22 @@
+package services
+
+import (
+	"context"
+
+	"github.com/ricoschulte/go-myapps/service"
+)
+
+// starts the subscription of the services, they are sent as ServicesInfo events
+func CallSubscribeServices(ctx context.Context, connection *service.AppServicePbxConnection) (*SubscribeServicesResult, error) {
+	return service.CallFor[SubscribeServicesResult](ctx, connection, "Services", NewSubscribeServices(""))
+}
+
+// returns the info of the service of the app, like the apis it offers
+func CallGetServiceInfo(ctx context.Context, connection *service.AppServicePbxConnection, app string) (*GetServiceInfoResult, error) {
+	return service.CallFor[GetServiceInfoResult](ctx, connection, "Services", NewGetServiceInfo(app, ""))
+}
+
+// returns a login for the service of the app with the challenge of its AppChallengeResult
+func CallGetServiceLogin(ctx context.Context, connection *service.AppServicePbxConnection, app, challenge string) (*GetServiceLoginResult, error) {
+	return service.CallFor[GetServiceLoginResult](ctx, connection, "Services", NewGetServiceLogin(app, challenge, ""))
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+	log "github.com/sirupsen/logrus"
+)
+
+// the dialer of Connect, e.g. with a TLSClientConfig for app services with self signed certificates
+var Dialer = &websocket.Dialer{
+	Proxy:            http.ProxyFromEnvironment,
+	HandshakeTimeout: 45 * time.Second,
+}
+
+// the number of messages of ServiceConnection.Messages that are buffered, further messages are dropped
+var MessagesBuffer = 100
+
+var ErrLoginFailed = errors.New("login to the app service failed")
+
+// a websocket connection to another app service, logged in with a login of the PBX
+type ServiceConnection struct {
+	App string
+	Url string
+
+	// the messages of the app service that are not results of Call, closed when the connection is closed
+	Messages chan json.RawMessage
+
+	conn       *websocket.Conn
+	writeMutex sync.Mutex
+	calls      service.Calls
+}
+
+// returns the websocket url of the url of a service
+func websocketUrl(url string) string {
+	switch {
+	case strings.HasPrefix(url, "http://"):
+		return "ws://" + strings.TrimPrefix(url, "http://")
+	case strings.HasPrefix(url, "https://"):
+		return "wss://" + strings.TrimPrefix(url, "https://")
+	}
+	return url
+}
+
+func readJSON(ctx context.Context, conn *websocket.Conn, v interface{}) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetReadDeadline(deadline)
+		defer conn.SetReadDeadline(time.Time{})
+	}
+	return conn.ReadJSON(v)
+}
+
+/*
+connects to the app service of the app at the url and logs in with a login of the PBX of the connection.
+
+The challenge of the app service is passed to GetServiceLogin, the result is sent to the app service as AppLogin.
+Without a deadline of the ctx, the login times out after ConnectTimeout.
+*/
+func Connect(ctx context.Context, connection *service.AppServicePbxConnection, app, url string) (*ServiceConnection, error) {
+	if url == "" {
+		return nil, fmt.Errorf("the service of the app '%s' has no url", app)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ConnectTimeout)
+		defer cancel()
+	}
+	conn, _, err := Dialer.DialContext(ctx, websocketUrl(url), nil)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to '%s' failed: %v", url, err)
+	}
+	sc := &ServiceConnection{
+		App:      app,
+		Url:      url,
+		Messages: make(chan json.RawMessage, MessagesBuffer),
+		conn:     conn,
+	}
+	if err := sc.login(ctx, connection); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go sc.loop()
+	return sc, nil
+}
+
+func (sc *ServiceConnection) login(ctx context.Context, connection *service.AppServicePbxConnection) error {
+	if err := sc.Send(map[string]string{"mt": "AppChallenge"}); err != nil {
+		return err
+	}
+	challenge := service.AppChallengeResult{}
+	if err := readJSON(ctx, sc.conn, &challenge); err != nil {
+		return fmt.Errorf("reading AppChallengeResult failed: %v", err)
+	}
+	if challenge.Mt != "AppChallengeResult" || challenge.Challenge == "" {
+		return fmt.Errorf("unexpected message '%s' instead of AppChallengeResult", challenge.Mt)
+	}
+
+	login, err := CallGetServiceLogin(ctx, connection, sc.App, challenge.Challenge)
+	if err != nil {
+		return err
+	}
+	if err := sc.Send(login.AppLogin()); err != nil {
+		return err
+	}
+	result := service.AppLoginResult{}
+	if err := readJSON(ctx, sc.conn, &result); err != nil {
+		return fmt.Errorf("reading AppLoginResult failed: %v", err)
+	}
+	if result.Mt != "AppLoginResult" || !result.Ok {
+		return fmt.Errorf("%w: %s", ErrLoginFailed, sc.App)
+	}
+	return nil
+}
+
+// reads the messages until the connection is closed
+func (sc *ServiceConnection) loop() {
+	defer sc.shutdown()
+	for {
+		_, message, err := sc.conn.ReadMessage()
+		if err != nil {
+			log.WithField("app", sc.App).Debugf("connection to the app service closed: %v", err)
+			return
+		}
+		msg := service.BaseMessage{}
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.WithField("app", sc.App).Errorf("error unmarshalling message: %v", err)
+			continue
+		}
+		if sc.calls.Resolve(msg.Src, message) {
+			continue
+		}
+		select {
+		case sc.Messages <- message:
+		default:
+			log.WithField("app", sc.App).Warnf("message %s dropped, Messages is full", msg.Mt)
+		}
+	}
+}
+
+// lets the running calls return service.ErrConnectionClosed and closes Messages
+func (sc *ServiceConnection) shutdown() {
+	sc.calls.Close()
+	close(sc.Messages)
+}
+
+// sends the message to the app service
+func (sc *ServiceConnection) Send(msg interface{}) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return sc.write(b)
+}
+
+func (sc *ServiceConnection) write(message []byte) error {
+	sc.writeMutex.Lock()
+	defer sc.writeMutex.Unlock()
+	return sc.conn.WriteMessage(websocket.TextMessage, message)
+}
+
+/*
+sends a message of the api of the app service and returns the first message sent back with the same src.
+
+The src of msg is set to a new unique value. If the result has an error code, it is returned together
+with a *service.CallError. Without a deadline of the ctx, the call times out after service.DefaultCallTimeout.
+*/
+func (sc *ServiceConnection) Call(ctx context.Context, api string, msg interface{}) (json.RawMessage, error) {
+	return sc.calls.Call(ctx, api, msg, sc.write)
+}
+
+// sends msg with the Call of the connection and unmarshals the result into a T
+func ServiceCallFor[T any](ctx context.Context, sc *ServiceConnection, api string, msg interface{}) (*T, error) {
+	message, err := sc.Call(ctx, api, msg)
+	return service.ResultFor[T](api, message, err)
+}
+
+// closes the connection to the app service
+func (sc *ServiceConnection) Close() error {
+	sc.writeMutex.Lock()
+	sc.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	sc.writeMutex.Unlock()
+	return sc.conn.Close()
+}
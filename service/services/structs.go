@@ -0,0 +1,137 @@
+package services
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ricoschulte/go-myapps/service"
+)
+
+func newBaseMessage(mt, src string) service.BaseMessage {
+	return service.BaseMessage{
+		Api: "Services",
+		Mt:  mt,
+		Src: src,
+	}
+}
+
+// the apis an app service offers to other apps, by the name of the api like "com.innovaphone.search"
+type ServiceInfo struct {
+	Apis map[string]json.RawMessage `json:"apis,omitempty"`
+}
+
+// an app service on the PBX
+type Service struct {
+	Name  string      `json:"name"` // the name of the app object
+	Title string      `json:"title,omitempty"`
+	Url   string      `json:"url,omitempty"` // the websocket url of the app service
+	Info  ServiceInfo `json:"info"`
+}
+
+// returns true if the service offers the api
+func (s *Service) HasApi(api string) bool {
+	_, ok := s.Info.Apis[api]
+	return ok
+}
+
+// starts the subscription of the services, the PBX sends ServicesInfo with all services and on every change
+type SubscribeServices struct {
+	service.BaseMessage
+}
+
+func NewSubscribeServices(src string) *SubscribeServices {
+	return &SubscribeServices{BaseMessage: newBaseMessage("SubscribeServices", src)}
+}
+
+type SubscribeServicesResult struct {
+	service.BaseMessage
+}
+
+// the available services, sent after SubscribeServices and when a service is added, changed or removed
+type ServicesInfo struct {
+	service.BaseMessage
+	Services []Service `json:"services"`
+}
+
+// requests the info of the service of the app
+type GetServiceInfo struct {
+	service.BaseMessage
+	App string `json:"app"`
+}
+
+func NewGetServiceInfo(app, src string) *GetServiceInfo {
+	return &GetServiceInfo{BaseMessage: newBaseMessage("GetServiceInfo", src), App: app}
+}
+
+type GetServiceInfoResult struct {
+	service.BaseMessage
+	App       string      `json:"app"`
+	Title     string      `json:"title,omitempty"`
+	Url       string      `json:"url,omitempty"`
+	Info      ServiceInfo `json:"info"`
+	Error     int         `json:"error,omitempty"`
+	Errortext string      `json:"errorText,omitempty"`
+}
+
+// requests a login for the service of the app with the challenge of the app service
+type GetServiceLogin struct {
+	service.BaseMessage
+	App       string `json:"app"`
+	Challenge string `json:"challenge"`
+}
+
+func NewGetServiceLogin(app, challenge, src string) *GetServiceLogin {
+	return &GetServiceLogin{BaseMessage: newBaseMessage("GetServiceLogin", src), App: app, Challenge: challenge}
+}
+
+// the values of the AppLogin sent to the app service
+type GetServiceLoginResult struct {
+	service.BaseMessage
+	App       string          `json:"app"`
+	Url       string          `json:"url,omitempty"`
+	Domain    string          `json:"domain"`
+	Sip       string          `json:"sip"`
+	Guid      string          `json:"guid"`
+	Dn        string          `json:"dn"`
+	PbxObj    string          `json:"pbxObj,omitempty"`
+	Info      json.RawMessage `json:"info"`
+	Digest    string          `json:"digest"`
+	Error     int             `json:"error,omitempty"`
+	Errortext string          `json:"errorText,omitempty"`
+}
+
+// returns the AppLogin for the app service
+func (result *GetServiceLoginResult) AppLogin() *service.AppLogin {
+	return &service.AppLogin{
+		BaseMessage: service.BaseMessage{Mt: "AppLogin"},
+		Sip:         result.Sip,
+		Guid:        result.Guid,
+		Dn:          result.Dn,
+		Digest:      result.Digest,
+		Domain:      result.Domain,
+		App:         result.App,
+		Info:        result.Info,
+		PbxObj:      result.PbxObj,
+	}
+}
+
+type ServicesEvent struct {
+	Type         int
+	Connection   *service.AppServicePbxConnection
+	ServicesInfo *ServicesInfo
+}
+
+const ServicesEventDisconnect = -20
+const ServicesEventConnect = -10
+const ServicesEventServicesInfo = 10
+
+// the timeout of the AppChallenge and AppLogin of Connect, if the context has no deadline
+var ConnectTimeout = 10 * time.Second
+
+func (event ServicesEvent) GetType() int {
+	return event.Type
+}
+
+func (event ServicesEvent) GetConnection() *service.AppServicePbxConnection {
+	return event.Connection
+}
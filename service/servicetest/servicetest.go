@@ -0,0 +1,44 @@
+/*
+Package servicetest provides utilities for the tests of the apis of an app service.
+*/
+package servicetest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+)
+
+// returns a connection to a fake PBX that passes the messages sent on the connection to answer, or ignores them
+// if answer is nil. The PBX sends messages to the connection with peer. Both are closed when the test ends.
+func NewConnection(t testing.TB, answer func(peer *websocket.Conn, message map[string]interface{})) *service.AppServicePbxConnection {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			message := map[string]interface{}{}
+			if err := conn.ReadJSON(&message); err != nil {
+				return
+			}
+			if answer != nil {
+				answer(conn, message)
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return service.NewAppServicePbxConnection(&service.AppService{}, conn)
+}
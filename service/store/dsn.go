@@ -0,0 +1,39 @@
+package store
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redacted = "xxxxx"
+
+// the key=value pairs of secrets in a DSN, like "password=secret" of PostgreSQL
+var secretKeyValue = regexp.MustCompile(`(?i)\b(password|passwd|pwd|pass|sslpassword|_auth_pass)(\s*=\s*)('(?:[^'\\]|\\.)*'|[^\s&;]*)`)
+
+// returns the DSN without the password or other secrets, e.g. for logs or apis
+func RedactDSN(dsn string) string {
+	if dsn == "" {
+		return dsn
+	}
+	if strings.Contains(dsn, "://") {
+		if u, err := url.Parse(dsn); err == nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), redacted)
+			}
+			query := u.Query()
+			changed := false
+			for key := range query {
+				if secretKeyValue.MatchString(key + "=") {
+					query.Set(key, redacted)
+					changed = true
+				}
+			}
+			if changed {
+				u.RawQuery = query.Encode()
+			}
+			return u.String()
+		}
+	}
+	return secretKeyValue.ReplaceAllString(dsn, "${1}${2}"+redacted)
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// a change of the tables of an instance, applied once by Migrate
+type Migration struct {
+	Version int    // the migrations are applied ordered by version, it must be unique and greater than 0
+	Name    string // a description for the log
+	Up      func(ctx context.Context, tx *Tx) error
+}
+
+// returns a migration that executes the statements, see Store.Expand for their syntax
+func SQLMigration(version int, name string, statements ...string) Migration {
+	return Migration{
+		Version: version,
+		Name:    name,
+		Up: func(ctx context.Context, tx *Tx) error {
+			for _, statement := range statements {
+				if _, err := tx.Exec(ctx, statement); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// the table with the applied migrations of the instance
+const migrationsTable = "schema_migrations"
+
+// applies the migrations that are not applied yet to the schema of the instance.
+// Every migration runs in its own transaction together with recording its version.
+func (s *Store) Migrate(ctx context.Context, migrations ...Migration) error {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, migration := range sorted {
+		if migration.Version <= 0 {
+			return fmt.Errorf("migration '%s' has invalid version %d", migration.Name, migration.Version)
+		}
+		if i > 0 && sorted[i-1].Version == migration.Version {
+			return fmt.Errorf("migrations '%s' and '%s' have the same version %d", sorted[i-1].Name, migration.Name, migration.Version)
+		}
+	}
+
+	if _, err := s.Exec(ctx, "CREATE TABLE IF NOT EXISTS {{"+migrationsTable+"}} (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at TEXT NOT NULL)"); err != nil {
+		return fmt.Errorf("creating migrations table failed: %v", err)
+	}
+	applied, err := s.AppliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range sorted {
+		if applied[migration.Version] {
+			continue
+		}
+		log.Infof("store %s: applying migration %d '%s'", s.Schema, migration.Version, migration.Name)
+		err := s.InTx(ctx, func(tx *Tx) error {
+			if err := migration.Up(ctx, tx); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO {{"+migrationsTable+"}} (version, name, applied_at) VALUES (?, ?, ?)",
+				migration.Version, migration.Name, time.Now().UTC().Format(time.RFC3339))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d '%s' failed: %v", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// returns the versions of the applied migrations
+func (s *Store) AppliedMigrations(ctx context.Context) (map[int]bool, error) {
+	rows, err := s.Query(ctx, "SELECT version FROM {{"+migrationsTable+"}}")
+	if err != nil {
+		return nil, fmt.Errorf("reading migrations failed: %v", err)
+	}
+	defer rows.Close()
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
@@ -0,0 +1,10 @@
+/*
+Package postgres registers a PostgreSQL driver for the store, it is imported for its side effect:
+
+	import _ "github.com/ricoschulte/go-myapps/service/store/postgres"
+
+The driver is github.com/lib/pq with the driver name "postgres".
+*/
+package postgres
+
+import _ "github.com/lib/pq"
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// stores values of T as json by id in a table of the instance
+type Repository[T any] struct {
+	Store *Store
+	Name  string // the name of the table
+}
+
+// returns the repository for the table and creates it, if it not exists
+func NewRepository[T any](ctx context.Context, store *Store, name string) (*Repository[T], error) {
+	r := &Repository[T]{Store: store, Name: name}
+	if _, err := store.Exec(ctx, "CREATE TABLE IF NOT EXISTS "+r.table()+" (id TEXT PRIMARY KEY, data TEXT NOT NULL)"); err != nil {
+		return nil, fmt.Errorf("creating table '%s' failed: %v", name, err)
+	}
+	return r, nil
+}
+
+func (r *Repository[T]) table() string {
+	return "{{" + r.Name + "}}"
+}
+
+// returns the value with the id or ErrNotFound
+func (r *Repository[T]) Get(ctx context.Context, id string) (*T, error) {
+	var data string
+	err := r.Store.QueryRow(ctx, "SELECT data FROM "+r.table()+" WHERE id = ?", id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	value := new(T)
+	if err := json.Unmarshal([]byte(data), value); err != nil {
+		return nil, fmt.Errorf("invalid data of '%s' in '%s': %v", id, r.Name, err)
+	}
+	return value, nil
+}
+
+// adds or replaces the value with the id
+func (r *Repository[T]) Put(ctx context.Context, id string, value *T) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = r.Store.Exec(ctx, "INSERT INTO "+r.table()+" (id, data) VALUES (?, ?) ON CONFLICT (id) DO UPDATE SET data = excluded.data", id, string(data))
+	return err
+}
+
+// removes the value with the id, returns ErrNotFound if there is none
+func (r *Repository[T]) Delete(ctx context.Context, id string) error {
+	result, err := r.Store.Exec(ctx, "DELETE FROM "+r.table()+" WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err == nil && count == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// returns all values by id
+func (r *Repository[T]) List(ctx context.Context) (map[string]*T, error) {
+	rows, err := r.Store.Query(ctx, "SELECT id, data FROM "+r.table()+" ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	values := map[string]*T{}
+	for rows.Next() {
+		var id, data string
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		value := new(T)
+		if err := json.Unmarshal([]byte(data), value); err != nil {
+			return nil, fmt.Errorf("invalid data of '%s' in '%s': %v", id, r.Name, err)
+		}
+		values[id] = value
+	}
+	return values, rows.Err()
+}
@@ -0,0 +1,10 @@
+/*
+Package sqlite registers a SQLite driver for the store, it is imported for its side effect:
+
+	import _ "github.com/ricoschulte/go-myapps/service/store/sqlite"
+
+The driver is github.com/mattn/go-sqlite3 with the driver name "sqlite3", it needs cgo.
+*/
+package sqlite
+
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,80 @@
+//go:build cgo
+
+package sqlite_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/ricoschulte/go-myapps/service/store"
+	_ "github.com/ricoschulte/go-myapps/service/store/sqlite"
+	"github.com/stretchr/testify/assert"
+)
+
+type device struct {
+	Name string `json:"name"`
+	Hw   string `json:"hw"`
+}
+
+func TestSQLite(t *testing.T) {
+	ctx := context.Background()
+	dsn := filepath.Join(t.TempDir(), "store.db")
+	s, err := store.Open(ctx, store.SQLite, dsn, "example.com", "myapp")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+
+	migrations := []store.Migration{
+		store.SQLMigration(1, "create items", "CREATE TABLE IF NOT EXISTS {{items}} (a TEXT PRIMARY KEY, b INTEGER)"),
+		store.SQLMigration(2, "add column", "ALTER TABLE {{items}} ADD COLUMN c TEXT"),
+	}
+	assert.NoError(t, s.Migrate(ctx, migrations...))
+	assert.NoError(t, s.Migrate(ctx, migrations...))
+	applied, err := s.AppliedMigrations(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]bool{1: true, 2: true}, applied)
+
+	// a failed migration is rolled back
+	err = s.Migrate(ctx, append(migrations, store.SQLMigration(3, "broken", "INSERT INTO {{items}} (a, b) VALUES ('x', 1)", "NOT SQL"))...)
+	assert.Error(t, err)
+	var count int
+	assert.NoError(t, s.QueryRow(ctx, "SELECT COUNT(*) FROM {{items}}").Scan(&count))
+	assert.Equal(t, 0, count)
+
+	assert.NoError(t, s.InTx(ctx, func(tx *store.Tx) error {
+		_, err := tx.Exec(ctx, "INSERT INTO {{items}} (a, b, c) VALUES (?, ?, ?)", "y", 2, "z")
+		return err
+	}))
+	var b int
+	var c string
+	assert.NoError(t, s.QueryRow(ctx, "SELECT b, c FROM {{items}} WHERE a = ?", "y").Scan(&b, &c))
+	assert.Equal(t, 2, b)
+	assert.Equal(t, "z", c)
+
+	devices, err := store.NewRepository[device](ctx, s, "devices")
+	assert.NoError(t, err)
+	_, err = devices.Get(ctx, "1")
+	assert.Equal(t, store.ErrNotFound, err)
+	assert.NoError(t, devices.Put(ctx, "1", &device{Name: "phone", Hw: "IP232"}))
+	assert.NoError(t, devices.Put(ctx, "2", &device{Name: "gateway", Hw: "IP811"}))
+	assert.NoError(t, devices.Put(ctx, "1", &device{Name: "desk phone", Hw: "IP232"}))
+	d, err := devices.Get(ctx, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, &device{Name: "desk phone", Hw: "IP232"}, d)
+	all, err := devices.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, all, 2)
+	assert.NoError(t, devices.Delete(ctx, "2"))
+	assert.Equal(t, store.ErrNotFound, devices.Delete(ctx, "2"))
+
+	// the tables of another instance are separate
+	other, err := store.New(ctx, s.DB, store.SQLite, "example.com", "other")
+	assert.NoError(t, err)
+	otherDevices, err := store.NewRepository[device](ctx, other, "devices")
+	assert.NoError(t, err)
+	all, err = otherDevices.List(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, all)
+}
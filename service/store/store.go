@@ -0,0 +1,244 @@
+/*
+Package store is the storage of app services, a database/sql database with a schema per app instance.
+
+The package imports no database driver, the application has to import the driver of its database.
+The packages sqlite and postgres register one:
+
+	import _ "github.com/ricoschulte/go-myapps/service/store/sqlite"   // github.com/mattn/go-sqlite3, needs cgo
+	import _ "github.com/ricoschulte/go-myapps/service/store/postgres" // github.com/lib/pq
+
+Other drivers work as well, Open uses the first registered driver of the names in DriverNames of the dialect,
+e.g. "sqlite" of modernc.org/sqlite or "pgx" of github.com/jackc/pgx.
+
+Queries are written with ? as placeholder and {{name}} for the tables of the instance,
+they are expanded to the syntax of the Dialect by the Store.
+*/
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// The differences of the SQL of the databases
+type Dialect interface {
+	Name() string
+	DriverNames() []string               // the names of the database/sql drivers of the database, in the order they are used
+	Placeholder(n int) string            // the placeholder of the n-th parameter, starting with 1
+	QuoteIdent(name string) string       // quotes a table or column name
+	CreateSchema(schema string) []string // the statements to create the schema of an instance, if not exists
+	Table(schema, table string) string   // the quoted name of a table in the schema
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string                  { return "sqlite" }
+func (sqliteDialect) DriverNames() []string         { return []string{"sqlite3", "sqlite"} }
+func (sqliteDialect) Placeholder(n int) string      { return "?" }
+func (sqliteDialect) QuoteIdent(name string) string { return quoteIdent(name) }
+
+// SQLite has no schemas, the tables of an instance get the schema as prefix
+func (sqliteDialect) CreateSchema(schema string) []string { return nil }
+func (sqliteDialect) Table(schema, table string) string {
+	return quoteIdent(schema + "_" + table)
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string                  { return "postgres" }
+func (postgresDialect) DriverNames() []string         { return []string{"postgres", "pgx"} }
+func (postgresDialect) Placeholder(n int) string      { return fmt.Sprintf("$%d", n) }
+func (postgresDialect) QuoteIdent(name string) string { return quoteIdent(name) }
+func (postgresDialect) CreateSchema(schema string) []string {
+	return []string{"CREATE SCHEMA IF NOT EXISTS " + quoteIdent(schema)}
+}
+func (postgresDialect) Table(schema, table string) string {
+	return quoteIdent(schema) + "." + quoteIdent(table)
+}
+
+var (
+	SQLite   Dialect = sqliteDialect{}
+	Postgres Dialect = postgresDialect{}
+)
+
+// returns the dialect by its name, "sqlite", "sqlite3", "postgres" or "postgresql"
+func GetDialect(name string) (Dialect, error) {
+	switch strings.ToLower(name) {
+	case "sqlite", "sqlite3":
+		return SQLite, nil
+	case "postgres", "postgresql", "pgx":
+		return Postgres, nil
+	}
+	return nil, fmt.Errorf("unknown database dialect '%s'", name)
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// returns the first registered driver of the dialect
+func DriverName(dialect Dialect) (string, error) {
+	registered := map[string]bool{}
+	for _, name := range sql.Drivers() {
+		registered[name] = true
+	}
+	for _, name := range dialect.DriverNames() {
+		if registered[name] {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no database/sql driver for %s registered, one of %s has to be imported", dialect.Name(), strings.Join(dialect.DriverNames(), ", "))
+}
+
+var invalidSchemaChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+/*
+returns the name of the schema of an app instance, e.g. "example_com_myapp_833b529e" for "example.com" and "myapp".
+
+The name is readable, but not unique, so a short hash of the domain and the instance is appended.
+The name is at most 63 characters long, the maximum length of names in PostgreSQL.
+*/
+func SchemaName(domain, instance string) string {
+	sum := sha256.Sum256([]byte(domain + "/" + instance))
+	hash := hex.EncodeToString(sum[:4])
+
+	name := invalidSchemaChars.ReplaceAllString(strings.ToLower(domain+"_"+instance), "_")
+	name = strings.Trim(name, "_")
+	if name == "" {
+		name = "i"
+	} else if name[0] >= '0' && name[0] <= '9' {
+		name = "i_" + name
+	}
+	if max := 63 - len(hash) - 1; len(name) > max {
+		name = strings.TrimRight(name[:max], "_")
+	}
+	return name + "_" + hash
+}
+
+// the storage of an app instance
+type Store struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Schema  string
+}
+
+// opens the database with a registered driver of the dialect and creates the schema of the instance
+func Open(ctx context.Context, dialect Dialect, dsn string, domain, instance string) (*Store, error) {
+	driver, err := DriverName(dialect)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s database failed: %v", dialect.Name(), err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connecting to %s database failed: %v", dialect.Name(), err)
+	}
+	s, err := New(ctx, db, dialect, domain, instance)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// returns a store for the instance in an opened database and creates its schema
+func New(ctx context.Context, db *sql.DB, dialect Dialect, domain, instance string) (*Store, error) {
+	s := &Store{
+		DB:      db,
+		Dialect: dialect,
+		Schema:  SchemaName(domain, instance),
+	}
+	for _, statement := range dialect.CreateSchema(s.Schema) {
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return nil, fmt.Errorf("creating schema '%s' failed: %v", s.Schema, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *Store) Close() error {
+	return s.DB.Close()
+}
+
+// returns the quoted name of the table of the instance
+func (s *Store) Table(name string) string {
+	return s.Dialect.Table(s.Schema, name)
+}
+
+var tablePattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+// replaces {{name}} with the tables of the instance and ? with the placeholders of the dialect.
+// A ? in a string literal would be replaced too, use a parameter for those values.
+func (s *Store) Expand(query string) string {
+	query = tablePattern.ReplaceAllStringFunc(query, func(match string) string {
+		return s.Table(tablePattern.FindStringSubmatch(match)[1])
+	})
+	if s.Dialect.Placeholder(1) == "?" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString(s.Dialect.Placeholder(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func (s *Store) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.DB.ExecContext(ctx, s.Expand(query), args...)
+}
+
+func (s *Store) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return s.DB.QueryContext(ctx, s.Expand(query), args...)
+}
+
+func (s *Store) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return s.DB.QueryRowContext(ctx, s.Expand(query), args...)
+}
+
+// a transaction of a Store, the queries are expanded like the ones of the Store
+type Tx struct {
+	Tx    *sql.Tx
+	store *Store
+}
+
+func (tx *Tx) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, tx.store.Expand(query), args...)
+}
+
+func (tx *Tx) Query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(ctx, tx.store.Expand(query), args...)
+}
+
+func (tx *Tx) QueryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, tx.store.Expand(query), args...)
+}
+
+// runs f in a transaction, it is committed if f returns nil and rolled back otherwise
+func (s *Store) InTx(ctx context.Context, f func(tx *Tx) error) error {
+	sqlTx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(&Tx{Tx: sqlTx, store: s}); err != nil {
+		sqlTx.Rollback()
+		return err
+	}
+	return sqlTx.Commit()
+}
@@ -1,13 +1,14 @@
 package service
 
 import (
+	"crypto/subtle"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service/store"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -22,7 +23,8 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin:     checkOrigin,
 }
 
-var challenges = make(map[*websocket.Conn]string)
+// length of the hex encoded challenges sent to clients
+const challengeLength = 32
 
 type BaseMessage struct {
 	Api string `json:"api"`
@@ -104,23 +106,20 @@ type AppInfo struct {
 }
 
 type AppInfoInfo struct {
-	Hidden bool `json:"hidden"`
-	Apis   struct {
-		ComInnovaphoneSearch map[string]interface{} `json:"com.innovaphone.search"`
-	} `json:"apis"`
+	Hidden bool                   `json:"hidden"`
+	Icon   string                 `json:"icon,omitempty"`
+	Apis   map[string]interface{} `json:"apis"`
 }
 
 type AppInfoServiceInfo struct {
-	Apis struct {
-		ComInnovaphoneReplicator map[string]interface{} `json:"com.innovaphone.replicator"`
-	} `json:"apis"`
+	Apis map[string]interface{} `json:"apis"`
 }
 
 type AppInfoResult struct {
 	BaseMessage
 	App         string             `json:"app"`
 	Info        AppInfoInfo        `json:"info"`
-	Serviceinfo AppInfoServiceInfo `json:"sericeinfo"`
+	Serviceinfo AppInfoServiceInfo `json:"serviceInfo"`
 }
 
 type PbxInfo struct {
@@ -135,30 +134,127 @@ type PbxInfo struct {
 
 type AppServicePbxConnection struct {
 	AppService    *AppService
+	Instance      *AppInstance // the instance the connection belongs to
 	conn          *websocket.Conn
 	PbxInfo       PbxInfo
-	AppLogin      AppLogin
-	Info          *AppLoginInfo
+	AppLogin      AppLogin      // the login of the client, read it with GetAppLogin while the connection runs
+	Info          *AppLoginInfo // the info of the AppLogin, read it with GetAppLogin while the connection runs
 	Authenticated bool
 	WriteMutext   sync.Mutex
+
+	loginMutex sync.RWMutex // guards AppLogin and Info, they are set by the AppLogin of the client
+
+	usedApis      map[string]bool // the apis messages were received for
+	usedApisMutex sync.Mutex
+
+	challenge      string // the challenge of the last AppChallenge, it can be used for one AppLogin only
+	challengeMutex sync.Mutex
+
+	calls Calls // the running calls, see Call
+
+	apiHandlers handlerQueue // runs the handlers of the api messages in the order they were received
+	appHandlers handlerQueue // runs the handlers of the messages of the client of an app in the order they were received
+
+	handlers sync.WaitGroup // the websocket handler of the connection and the handlers of its messages, see startHandler
+}
+
+// runs functions one after the other in the order they were added, without blocking the caller
+type handlerQueue struct {
+	mu      sync.Mutex
+	pending []func()
+	running bool
+}
+
+// adds f to the queue, a go routine runs the queue while it is not empty
+func (q *handlerQueue) run(f func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, f)
+	if !q.running {
+		q.running = true
+		go q.work()
+	}
+}
+
+func (q *handlerQueue) work() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		f := q.pending[0]
+		q.pending[0] = nil
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+		f()
+	}
 }
 
 func NewAppServicePbxConnection(appservice *AppService, conn *websocket.Conn) *AppServicePbxConnection {
 	return &AppServicePbxConnection{
 		AppService: appservice,
+		Instance:   appservice.defaultInstance(),
 		conn:       conn,
 	}
 }
+
+// returns the AppLogin of the client and its info, the info is nil before the login
+func (connection *AppServicePbxConnection) GetAppLogin() (AppLogin, *AppLoginInfo) {
+	connection.loginMutex.RLock()
+	defer connection.loginMutex.RUnlock()
+	return connection.AppLogin, connection.Info
+}
+
+// starts a handler of a message of the connection, returns false if the service is shutting down
+func (connection *AppServicePbxConnection) startHandler() bool {
+	if !connection.AppService.startHandler() {
+		return false
+	}
+	connection.handlers.Add(1)
+	return true
+}
+
+func (connection *AppServicePbxConnection) finishHandler() {
+	connection.handlers.Done()
+	connection.AppService.finishHandler()
+}
+
+// returns the database of the instance of the connection, nil if it has none
+func (connection *AppServicePbxConnection) GetStore() *store.Store {
+	return connection.Instance.GetStore()
+}
+func (connection *AppServicePbxConnection) addUsedApi(api string) {
+	connection.usedApisMutex.Lock()
+	defer connection.usedApisMutex.Unlock()
+	if connection.usedApis == nil {
+		connection.usedApis = map[string]bool{}
+	}
+	connection.usedApis[api] = true
+}
+
+func (connection *AppServicePbxConnection) getUsedApis() []string {
+	connection.usedApisMutex.Lock()
+	defer connection.usedApisMutex.Unlock()
+	apis := []string{}
+	for api := range connection.usedApis {
+		apis = append(apis, api)
+	}
+	return apis
+}
+
 func (connection *AppServicePbxConnection) log() *log.Entry {
 	return log.
-		WithField("Domain", connection.AppService.Domain).
-		WithField("Instance", connection.AppService.Instance).
+		WithField("Domain", connection.Instance.Domain).
+		WithField("Instance", connection.Instance.Instance).
 		WithField("name", connection.AppService.Name).
 		WithField("pbx", connection.PbxInfo.Pbx).
 		WithField("pbxDns", connection.PbxInfo.PbxDns)
 }
 
 func (connection *AppServicePbxConnection) Loop() {
+	defer connection.calls.Close()
 
 	for {
 		// Read message
@@ -195,9 +291,9 @@ func (connection *AppServicePbxConnection) Loop() {
 
 		switch mt {
 		case "AppChallenge":
-			response, hErr = connection.handleAppChallenge(connection.conn, message)
+			response, hErr = connection.handleAppChallenge(message)
 		case "AppLogin":
-			response, hErr = connection.handleAppLogin(challenges[connection.conn], message)
+			response, hErr = connection.handleAppLogin(message)
 		case "AppInfo":
 			response, hErr = connection.handleAppInfo(connection.conn, message)
 		case "PbxInfo":
@@ -216,13 +312,30 @@ func (connection *AppServicePbxConnection) Loop() {
 					log.Warn("message for a api received but connection isnt authenticated. Closing connection.")
 					connection.conn.Close()
 
+				} else if src, _ := msg["src"].(string); connection.calls.Resolve(src, message) {
+					// the result of a Call
 				} else {
-					// look for a api handler in app service
-					go func() {
+					// look for a api handler in app service, the messages of a connection are handled in order,
+					// so e.g. a ReplicateNext is not handled before the ReplicateStart of its session
+					if !connection.startHandler() {
+						continue
+					}
+					connection.apiHandlers.run(func() {
+						defer connection.finishHandler()
 						connection.AppService.HandleApiMessage(connection, msg["api"].(string), message)
-					}()
+					})
 				}
 				continue
+			} else if login, _ := connection.GetAppLogin(); connection.Authenticated && connection.AppService.HandlesApp(login.App) {
+				// a message of a client of the app, handled in order like the api messages
+				if !connection.startHandler() {
+					continue
+				}
+				connection.appHandlers.run(func() {
+					defer connection.finishHandler()
+					connection.AppService.HandleAppMessage(connection, message)
+				})
+				continue
 			} else {
 				connection.log().Warnf("unknown mt: %s", string(message))
 				response = []byte("{\"mt\":\"Error\",\"text\":\"unknown mt '" + mt + "'\"}")
@@ -249,26 +362,52 @@ func (connection *AppServicePbxConnection) Loop() {
 	}
 }
 
+// sends a close frame with the code and text to the peer, the connection is closed when the peer answers it
+func (connection *AppServicePbxConnection) Close(code int, text string) error {
+	deadline := time.Now().Add(time.Second)
+	// the peer has to answer in time, otherwise the read in Loop fails
+	connection.conn.SetReadDeadline(deadline.Add(5 * time.Second))
+	return connection.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), deadline)
+}
+
 func (connection *AppServicePbxConnection) WriteMessage(message []byte) error {
 	connection.WriteMutext.Lock()
 	defer connection.WriteMutext.Unlock()
 	err := connection.conn.WriteMessage(websocket.TextMessage, message)
 	if err != nil {
-		log.Errorf("Error writing message:", err)
+		log.Errorf("Error writing message: %v", err)
 		return err
 	}
 	return nil
 }
 
-func (connection *AppServicePbxConnection) handleAppChallenge(conn *websocket.Conn, msg []byte) ([]byte, error) {
+func (connection *AppServicePbxConnection) handleAppChallenge(msg []byte) ([]byte, error) {
+	mu := &MyAppsUtils{}
+	challenge := mu.GetRandomHexString(challengeLength)
+
+	connection.challengeMutex.Lock()
+	connection.challenge = challenge
+	connection.challengeMutex.Unlock()
+
 	response := &AppChallengeResult{
 		Mt:        "AppChallengeResult",
-		Challenge: challenges[conn],
+		Challenge: challenge,
 	}
 	return json.Marshal(response)
 }
 
-func (connection *AppServicePbxConnection) handleAppLogin(challenge string, msg []byte) ([]byte, error) {
+// returns the current challenge of the connection and removes it, so a AppLogin can not be replayed
+func (connection *AppServicePbxConnection) takeChallenge() string {
+	connection.challengeMutex.Lock()
+	defer connection.challengeMutex.Unlock()
+	challenge := connection.challenge
+	connection.challenge = ""
+	return challenge
+}
+
+func (connection *AppServicePbxConnection) handleAppLogin(msg []byte) ([]byte, error) {
+	challenge := connection.takeChallenge()
+
 	var msgin AppLogin
 	if err := json.Unmarshal([]byte(msg), &msgin); err != nil {
 		return nil, err
@@ -277,18 +416,20 @@ func (connection *AppServicePbxConnection) handleAppLogin(challenge string, msg
 	if err != nil {
 		return nil, err
 	}
-	connection.AppLogin = msgin
-	connection.Info = info
 	mu := &MyAppsUtils{}
-	calculated_digest, err := mu.GetDigestForAppLoginFromJson(string(msg), connection.AppService.Password, challenge)
+	calculated_digest, err := mu.GetDigestForAppLoginFromJson(string(msg), connection.Instance.getPassword(), challenge)
 	if err != nil {
 		return nil, err
 	}
 
-	if msgin.Digest == calculated_digest {
+	if challenge != "" && subtle.ConstantTimeCompare([]byte(msgin.Digest), []byte(calculated_digest)) == 1 {
+		connection.loginMutex.Lock()
+		connection.AppLogin = msgin
+		connection.Info = info
+		connection.loginMutex.Unlock()
 		log.
-			WithField("Domain", connection.AppService.Domain).
-			WithField("Instance", connection.AppService.Instance).
+			WithField("Domain", connection.Instance.Domain).
+			WithField("Instance", connection.Instance.Instance).
 			WithField("name", connection.AppService.Name).
 			WithField("app", msgin.App).
 			WithField("sip", msgin.Sip).
@@ -302,27 +443,21 @@ func (connection *AppServicePbxConnection) handleAppLogin(challenge string, msg
 			Ok:     true,
 		}
 		connection.Authenticated = true
-		go func() {
-			app := strings.TrimSuffix(msgin.App, ".htm")
-			switch app {
-			case "user":
-
-				connection.AppService.HandleUserConnected(connection, msg)
-			case "admin":
-				connection.AppService.HandleAdminConnected(connection, msg)
-			default:
-				log.Warnf("unknown App '%s'", app)
-			}
-		}()
+		if connection.startHandler() {
+			go func() {
+				defer connection.finishHandler()
+				connection.AppService.HandleAppConnected(connection, msg)
+			}()
+		}
 		return json.Marshal(response)
 	} else {
 		log.
-			WithField("Domain", connection.AppService.Domain).
-			WithField("Instance", connection.AppService.Instance).
+			WithField("Domain", connection.Instance.Domain).
+			WithField("Instance", connection.Instance.Instance).
 			WithField("name", connection.AppService.Name).
 			WithField("app", msgin.App).
 			WithField("sip", msgin.Sip).
-			Warn("appservice login failed: digest not correct")
+			Warn("appservice login failed: no challenge requested or digest not correct")
 
 		response := AppLoginResult{
 			BaseMessage: BaseMessage{
@@ -349,49 +484,22 @@ func (connection *AppServicePbxConnection) handleAppInfo(conn *websocket.Conn, m
 			Errorf("could not unmashal AppInfo: %v", err)
 		return nil, err
 	}
-	resp := `{
-		"mt": "AppInfoResult",
-		"app": "%s",
-		"info": {
-			"hidden": %t,
-			"apis": {
-				"com.innovaphone.search": {}
-			}
-		},
-		"serviceInfo": {
-			"apis": {
-				"com.innovaphone.replicator": {}
-			}
-		}
-	}`
-	var jsn string
-	switch msgin.App {
-	default:
-		jsn = fmt.Sprintf(resp, msgin.App, false)
-
-	case "searchapi":
-		jsn = fmt.Sprintf(resp, msgin.App, true)
-
-	}
-
-	// pasre from json
-	var respmsg AppInfoResult
-	if err := json.Unmarshal([]byte(jsn), &respmsg); err != nil {
-		log.
-			WithField("msg", string(msg)).
-			Errorf("could not unmashal AppInfoResult: %v", err)
-		return nil, err
-	}
 
-	// to json and return
+	respmsg := connection.AppService.GetAppInfoResult(msgin.App)
+	respmsg.Src = msgin.Src
 	return json.Marshal(respmsg)
 }
 
+// handles the websocket of the instance of the Domain, Instance and Password of the AppService
 func HandleWebsocket(appservice *AppService, w http.ResponseWriter, req *http.Request) {
+	handleWebsocket(appservice, appservice.defaultInstance(), w, req)
+}
+
+func handleWebsocket(appservice *AppService, instance *AppInstance, w http.ResponseWriter, req *http.Request) {
 	ctx := req.Context()
 	mlog := log.
-		WithField("Domain", appservice.Domain).
-		WithField("Instance", appservice.Instance).
+		WithField("Domain", instance.Domain).
+		WithField("Instance", instance.Instance).
 		WithField("name", appservice.Name)
 
 	defer func() {
@@ -409,23 +517,32 @@ func HandleWebsocket(appservice *AppService, w http.ResponseWriter, req *http.Re
 	}()
 	mlog.Debug("server: websocket handler started")
 
+	if !appservice.startHandler() {
+		http.Error(w, "service is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer appservice.finishHandler()
+
 	conn, err := upgrader.Upgrade(w, req, nil)
 	if err != nil {
 		mlog.Errorf("upgrading websocket failed: %s", err)
 		return
 	}
 	connection := NewAppServicePbxConnection(appservice, conn)
+	connection.Instance = instance
+	connection.handlers.Add(1)
 	appservice.AddConnection(connection)
 	defer func() {
 		conn.Close()
 		appservice.DeleteConnection(connection)
 		appservice.HandleApiDisConnected(connection)
+		connection.handlers.Done()
 	}()
-
-	// Generate challenge for the connection
-	mu := &MyAppsUtils{}
-	challenge := mu.GetRandomHexString(16)
-	challenges[conn] = challenge
+	if instance.isDeleted() {
+		// DeleteInstance did not see the connection
+		connection.Close(websocket.CloseGoingAway, "instance deleted")
+		return
+	}
 
 	select {
 	case <-ctx.Done():
@@ -434,7 +551,6 @@ func HandleWebsocket(appservice *AppService, w http.ResponseWriter, req *http.Re
 		mlog.Errorf("websocket error: %s", err)
 		internalError := http.StatusInternalServerError
 		http.Error(w, err.Error(), internalError)
-		delete(challenges, conn)
 		return
 	default:
 		connection.Loop()
@@ -0,0 +1,92 @@
+package service_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/ricoschulte/go-myapps/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// returns a client connected to the websocket of the appservice
+func dialAppService(t *testing.T, appservice *service.AppService) *websocket.Conn {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		service.HandleWebsocket(appservice, w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// returns a AppLogin message with the digest for the challenge
+func newAppLogin(t *testing.T, password, challenge string) []byte {
+	login := `{"mt":"AppLogin","app":"user","domain":"fritz.box","sip":"rico","guid":"f48b06484a8a61015853009033400109","dn":"Schulte, Rico","info":{"appobj":"go","appdn":"go","appurl":"http://192.168.178.29:5000/fritz.box/go/instance/user","pbx":"pbx-main","cn":"rico","groups":[],"apps":[]},"pbxObj":"go"}`
+	mu := &service.MyAppsUtils{}
+	digest, err := mu.GetDigestForAppLoginFromJson(login, password, challenge)
+	assert.NoError(t, err)
+	return []byte(strings.Replace(login, `"pbxObj":"go"`, `"pbxObj":"go","digest":"`+digest+`"`, 1))
+}
+
+func getChallenge(t *testing.T, client *websocket.Conn) string {
+	assert.NoError(t, client.WriteMessage(websocket.TextMessage, []byte(`{"mt":"AppChallenge"}`)))
+	result := readMessage(t, client)
+	assert.Equal(t, "AppChallengeResult", result["mt"])
+	return result["challenge"].(string)
+}
+
+func login(t *testing.T, client *websocket.Conn, message []byte) bool {
+	assert.NoError(t, client.WriteMessage(websocket.TextMessage, message))
+	result := readMessage(t, client)
+	assert.Equal(t, "AppLoginResult", result["mt"])
+	return result["ok"].(bool)
+}
+
+func TestAppLogin(t *testing.T) {
+	appservice := &service.AppService{Password: "go"}
+
+	t.Run("valid digest", func(t *testing.T) {
+		client := dialAppService(t, appservice)
+		challenge := getChallenge(t, client)
+		assert.Len(t, challenge, 32)
+		assert.True(t, login(t, client, newAppLogin(t, "go", challenge)))
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		client := dialAppService(t, appservice)
+		challenge := getChallenge(t, client)
+		assert.False(t, login(t, client, newAppLogin(t, "wrong", challenge)))
+	})
+
+	t.Run("without challenge", func(t *testing.T) {
+		client := dialAppService(t, appservice)
+		assert.False(t, login(t, client, newAppLogin(t, "go", "")))
+	})
+
+	t.Run("replayed login", func(t *testing.T) {
+		client := dialAppService(t, appservice)
+		message := newAppLogin(t, "go", getChallenge(t, client))
+		assert.True(t, login(t, client, message))
+		assert.False(t, login(t, client, message))
+
+		// the same login on a different connection fails too
+		other := dialAppService(t, appservice)
+		getChallenge(t, other)
+		assert.False(t, login(t, other, message))
+	})
+
+	t.Run("new challenge per request", func(t *testing.T) {
+		client := dialAppService(t, appservice)
+		first := getChallenge(t, client)
+		second := getChallenge(t, client)
+		assert.NotEqual(t, first, second)
+		assert.False(t, login(t, client, newAppLogin(t, "go", first)))
+	})
+}